@@ -9,12 +9,19 @@ import (
 
 	"majmun/internal/config"
 	"majmun/internal/logging"
+	"majmun/internal/metrics"
 	"majmun/internal/server"
 )
 
 func main() {
 	ctx := context.Background()
+
+	if len(os.Args) > 1 && os.Args[1] == "revoke" {
+		os.Exit(runRevoke(ctx, os.Args[2:]))
+	}
+
 	configPath := flag.String("config", "config.yaml", "path to configuration (file or dir)")
+	watchInterval := flag.Duration("watch", 0, "poll interval for config changes, disabled if 0")
 	flag.Parse()
 
 	logging.Info(ctx, "starting majmun", "config_path", *configPath)
@@ -36,6 +43,31 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	reload := make(chan struct{}, 1)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			requestReload(reload)
+		}
+	}()
+
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
+
+	if *watchInterval > 0 {
+		go config.Watch(watchCtx, *configPath, *watchInterval, func() {
+			requestReload(reload)
+		})
+	}
+
+	go func() {
+		for range reload {
+			reloadConfig(ctx, s, *configPath)
+		}
+	}()
+
 	go func() {
 		if err := s.Start(); err != nil {
 			logging.Error(ctx, err, "server error")
@@ -49,3 +81,31 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func requestReload(reload chan<- struct{}) {
+	select {
+	case reload <- struct{}{}:
+	default:
+	}
+}
+
+func reloadConfig(ctx context.Context, s *server.Server, configPath string) {
+	logging.Info(ctx, "reloading config", "config_path", configPath)
+
+	c, err := config.Load(configPath)
+	if err != nil {
+		metrics.IncConfigReloads(metrics.ReloadStatusFailure)
+		logging.Error(ctx, err, "config reload failed, keeping current config")
+		return
+	}
+
+	if err := s.Reload(c); err != nil {
+		metrics.IncConfigReloads(metrics.ReloadStatusFailure)
+		logging.Error(ctx, err, "config reload failed, keeping current config")
+		return
+	}
+
+	logging.SetLevelAndFormat(c.Logs.Level, c.Logs.Format)
+	metrics.IncConfigReloads(metrics.ReloadStatusSuccess)
+	logging.Info(ctx, "config reloaded")
+}
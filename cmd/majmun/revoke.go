@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"majmun/internal/config"
+	"majmun/internal/logging"
+)
+
+const adminRequestTimeout = 10 * time.Second
+
+func runRevoke(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to configuration (file or dir)")
+	clientName := fs.String("client", "", "name of the client whose tokens are revoked")
+	before := fs.String("before", "", "revoke tokens issued before this RFC 3339 time, now if empty")
+	_ = fs.Parse(args)
+
+	if *clientName == "" {
+		fmt.Fprintln(os.Stderr, "-client is required")
+		fs.Usage()
+		return 2
+	}
+
+	body := map[string]any{}
+	if *before != "" {
+		t, err := time.Parse(time.RFC3339, *before)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -before: %v\n", err)
+			return 2
+		}
+		body["before"] = t
+	}
+
+	c, err := config.Load(*configPath)
+	if err != nil {
+		logging.Error(ctx, err, "failed to load config")
+		return 1
+	}
+
+	revokedBefore, err := revokeClient(ctx, c.Server, *clientName, body)
+	if err != nil {
+		logging.Error(ctx, err, "failed to revoke client tokens", "client", *clientName)
+		return 1
+	}
+
+	fmt.Printf("revoked tokens of client %s issued before %s\n", *clientName, revokedBefore.Format(time.RFC3339))
+	return 0
+}
+
+func revokeClient(ctx context.Context, cfg config.ServerConfig, clientName string, body map[string]any) (time.Time, error) {
+	endpoint, err := adminURL(cfg.AdminAddr, "/api/clients/"+url.PathEscape(clientName)+"/revoke")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: adminRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return time.Time{}, fmt.Errorf("admin api returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		RevokedBefore time.Time `json:"revoked_before"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.RevokedBefore, nil
+}
+
+func adminURL(addr, path string) (string, error) {
+	if addr == "" {
+		return "", fmt.Errorf("server.admin_addr is not configured")
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid server.admin_addr: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + path, nil
+}
@@ -5,62 +5,256 @@ import (
 	"fmt"
 	"majmun/internal/config"
 	"majmun/internal/httpclient"
+	"majmun/internal/listing"
+	"majmun/internal/listing/snapshot"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
 	"majmun/internal/urlgen"
+	"majmun/internal/utils"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
 type Manager struct {
 	config         *config.Config
-	semaphore      *semaphore.Weighted
+	viewerLimits   []*utils.Limit
+	upstreamLimit  *utils.Limit
+	semaphores     *semaphoreRegistry
+	mirrorSets     map[string]*mirrorSet
 	clients        []*Client
 	secretToClient map[string]*Client
+	tokenToClients map[string][]*Client
 	publicURLBase  string
-	cacheStore     *httpclient.Store
+	cacheStore     *cacheStore
+	cachePath      string
+	snapshots      listing.SnapshotStore
+	snapshotPath   string
+	revocations    *revocationStore
+	revocationPath string
+	refs           atomic.Int64
+}
+
+// cacheStore is shared by managers with the same cache path and closed
+// when the last reference to it is released.
+type cacheStore struct {
+	*httpclient.Store
+	refs atomic.Int64
+}
+
+func newCacheStore(path string) (*cacheStore, error) {
+	st, err := httpclient.NewStore(path)
+	if err != nil {
+		return nil, err
+	}
+	cs := &cacheStore{Store: st}
+	cs.refs.Store(1)
+	return cs, nil
+}
+
+func (cs *cacheStore) acquire() *cacheStore {
+	cs.refs.Add(1)
+	return cs
+}
+
+func (cs *cacheStore) release() {
+	if cs.refs.Add(-1) == 0 {
+		cs.Close()
+	}
 }
 
 func NewManager(cfg *config.Config) (*Manager, error) {
+	return newManager(cfg, nil)
+}
+
+// Reload builds a manager for cfg that shares the stores and semaphores of m.
+// The caller releases m once the returned manager has replaced it.
+func (m *Manager) Reload(cfg *config.Config) (*Manager, error) {
+	return newManager(cfg, m)
+}
+
+// Acquire keeps the resources of m open until the matching Release. It fails
+// once m has been closed and all its users are done.
+func (m *Manager) Acquire() bool {
+	for {
+		refs := m.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if m.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (m *Manager) Release() {
+	if m.refs.Add(-1) == 0 && m.cacheStore != nil {
+		m.cacheStore.release()
+	}
+}
+
+// Close drops the reference held since creation. The cache store is closed
+// after the last in-flight user of m has released it.
+func (m *Manager) Close() {
+	m.Release()
+}
+
+func newManager(cfg *config.Config, prev *Manager) (_ *Manager, err error) {
 	m := &Manager{
 		config:         cfg,
 		secretToClient: make(map[string]*Client),
+		tokenToClients: make(map[string][]*Client),
+		mirrorSets:     make(map[string]*mirrorSet),
 		publicURLBase:  cfg.Server.PublicURL.String(),
 	}
+	m.refs.Store(1)
 
-	if cfg.Proxy.Enabled != nil && *cfg.Proxy.Enabled && cfg.Proxy.ConcurrentStreams > 0 {
-		m.semaphore = semaphore.NewWeighted(cfg.Proxy.ConcurrentStreams)
+	if prev != nil {
+		m.semaphores = prev.semaphores
+	} else {
+		m.semaphores = newSemaphoreRegistry()
+	}
+	m.semaphores.begin()
+
+	if cfg.Proxy.Enabled != nil && *cfg.Proxy.Enabled {
+		m.viewerLimits = []*utils.Limit{
+			m.semaphores.limit(metrics.LimitConcurrency, metrics.LimitScopeGlobal, "",
+				metrics.FailureReasonGlobalLimit, cfg.Proxy.ConcurrentStreams),
+			m.semaphores.limit(metrics.LimitViewerConcurrency, metrics.LimitScopeGlobal, "",
+				metrics.FailureReasonGlobalViewerLimit, cfg.Proxy.ViewerConcurrency),
+		}
+		m.upstreamLimit = m.semaphores.limit(metrics.LimitUpstreamConcurrency, metrics.LimitScopeGlobal, "",
+			metrics.FailureReasonGlobalUpstreamLimit, cfg.Proxy.UpstreamConcurrency)
 	}
 
 	if cfg.Proxy.HTTPClient.Cache.Enabled != nil && *cfg.Proxy.HTTPClient.Cache.Enabled {
 		if cfg.Proxy.HTTPClient.Cache.Path == nil {
 			return nil, fmt.Errorf("proxy.http_client.cache.path is required when cache is enabled")
 		}
-		st, err := httpclient.NewStore(*cfg.Proxy.HTTPClient.Cache.Path)
-		if err != nil {
-			return nil, err
+		m.cachePath = *cfg.Proxy.HTTPClient.Cache.Path
+
+		if prev != nil && prev.cacheStore != nil && prev.cachePath == m.cachePath {
+			m.cacheStore = prev.cacheStore.acquire()
+		} else {
+			st, err := newCacheStore(m.cachePath)
+			if err != nil {
+				return nil, err
+			}
+			m.cacheStore = st
 		}
-		m.cacheStore = st
+
+		defer func() {
+			if err != nil {
+				m.cacheStore.release()
+			}
+		}()
+	}
+
+	if cfg.Server.DataDir != "" {
+		m.snapshotPath = filepath.Join(cfg.Server.DataDir, "snapshots")
+
+		if prev != nil && prev.snapshots != nil && prev.snapshotPath == m.snapshotPath {
+			m.snapshots = prev.snapshots
+		} else {
+			st, err := snapshot.NewStore(m.snapshotPath)
+			if err != nil {
+				return nil, err
+			}
+			m.snapshots = st
+		}
+
+		m.revocationPath = filepath.Join(cfg.Server.DataDir, "revocations.json")
+
+		if prev != nil && prev.revocations != nil && prev.revocationPath == m.revocationPath {
+			m.revocations = prev.revocations
+		} else {
+			st, err := newRevocationStore(m.revocationPath)
+			if err != nil {
+				return nil, err
+			}
+			m.revocations = st
+		}
+	} else if slices.ContainsFunc(cfg.Playlists, func(pl config.Playlist) bool { return pl.OnSourceError == "" }) {
+		logging.Info(context.TODO(), "server.data_dir is not set, playlists without on_source_error skip failed sources")
 	}
 
 	if err := m.initClients(); err != nil {
 		return nil, err
 	}
+	m.semaphores.commit()
 
 	return m, nil
 }
 
+func (m *Manager) httpCacheStore() *httpclient.Store {
+	if m.cacheStore == nil {
+		return nil
+	}
+	return m.cacheStore.Store
+}
+
 func (m *Manager) Client(secret string) *Client {
 	return m.secretToClient[secret]
 }
 
+func (m *Manager) ClientByCredentials(name, secret string) *Client {
+	c := m.secretToClient[secret]
+	if c == nil || c.Name() != name {
+		return nil
+	}
+	return c
+}
+
+func (m *Manager) ClientByName(name string) *Client {
+	for _, c := range m.clients {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
 func (m *Manager) Clients() []*Client {
 	return m.clients
 }
 
-func (m *Manager) Semaphore() *semaphore.Weighted {
-	return m.semaphore
+func (m *Manager) ClientsForToken(token string) []*Client {
+	if clientID, ok := urlgen.TokenClientID(token); ok {
+		return m.tokenToClients[clientID]
+	}
+	return m.clients
+}
+
+func (m *Manager) RevokeClient(name string, before time.Time) (time.Time, error) {
+	clientConf, ok := m.findClient(name)
+	if !ok {
+		return time.Time{}, fmt.Errorf("client not found: %s", name)
+	}
+	if m.revocations == nil {
+		return time.Time{}, fmt.Errorf("server.data_dir is required to revoke tokens")
+	}
+
+	if err := m.revocations.set(name, before); err != nil {
+		return time.Time{}, err
+	}
+
+	effective := m.revokedBefore(clientConf)
+	if cl := m.ClientByName(name); cl != nil {
+		cl.URLGenerator().SetRevokedBefore(effective)
+	}
+
+	return effective, nil
+}
+
+func (m *Manager) revokedBefore(clientConf config.Client) time.Time {
+	result := clientConf.RevokedBefore
+	if m.revocations != nil {
+		if stored := m.revocations.get(clientConf.Name); stored.After(result) {
+			result = stored
+		}
+	}
+	return result
 }
 
 func (m *Manager) initClients() error {
@@ -75,6 +269,9 @@ func (m *Manager) initClients() error {
 		m.clients = append(m.clients, clientInstance)
 		m.secretToClient[clientConf.Secret] = clientInstance
 
+		clientID := urlgen.NewClientID(clientConf.Name)
+		m.tokenToClients[clientID] = append(m.tokenToClients[clientID], clientInstance)
+
 		logging.Debug(context.TODO(), "client initialized", "name", clientConf.Name)
 	}
 
@@ -82,16 +279,32 @@ func (m *Manager) initClients() error {
 }
 
 func (m *Manager) createClient(clientConf config.Client) (*Client, error) {
-	urlGen, err := m.createURLGenerator(clientConf.Secret)
+	urlGen, err := m.createURLGenerator(clientConf.Name, clientConf.Secret)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create URL generator: %w", err)
 	}
+	urlGen.SetRevokedBefore(m.revokedBefore(clientConf))
+
+	viewerLimits := compactLimits(append([]*utils.Limit{
+		m.semaphores.limit(metrics.LimitConcurrency, metrics.LimitScopeClient, clientConf.Name,
+			metrics.FailureReasonClientLimit, clientConf.Proxy.ConcurrentStreams),
+		m.semaphores.limit(metrics.LimitViewerConcurrency, metrics.LimitScopeClient, clientConf.Name,
+			metrics.FailureReasonClientViewerLimit, clientConf.Proxy.ViewerConcurrency),
+	}, m.viewerLimits...)...)
+	upstreamLimits := compactLimits(
+		m.semaphores.limit(metrics.LimitUpstreamConcurrency, metrics.LimitScopeClient, clientConf.Name,
+			metrics.FailureReasonClientUpstreamLimit, clientConf.Proxy.UpstreamConcurrency),
+		m.upstreamLimit,
+	)
 
-	cl, err := NewClient(clientConf, urlGen, m.config.ChannelRules, m.config.PlaylistRules, m.publicURLBase, m.cacheStore)
+	cl, err := NewClient(
+		clientConf, urlGen, m.config.ChannelRules, m.config.PlaylistRules, m.publicURLBase, m.httpCacheStore(),
+		viewerLimits, upstreamLimits)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"failed to initialize client %s: %w", clientConf.Name, err)
 	}
+	cl.queue = mergeProxies(m.config.Proxy, clientConf.Proxy).Queue
 
 	if err := m.initClientProviders(cl, clientConf.Playlists, clientConf.EPGs); err != nil {
 		return nil, fmt.Errorf(
@@ -142,15 +355,40 @@ func (m *Manager) initClientProviders(cl *Client, playlistNames, epgNames []stri
 }
 
 func (m *Manager) addPlaylistProvider(cl *Client, playlistConf config.Playlist) error {
-	var sem *semaphore.Weighted
-	if playlistConf.Proxy.ConcurrentStreams > 0 {
-		sem = semaphore.NewWeighted(playlistConf.Proxy.ConcurrentStreams)
+	var accountLimit *utils.Limit
+	var mirrors *mirrorSet
+	if playlistConf.Account != "" {
+		account, ok := m.findAccount(playlistConf.Account)
+		if !ok {
+			return fmt.Errorf(
+				"account '%s' for playlist '%s' is not defined in config", playlistConf.Account, playlistConf.Name)
+		}
+		playlistConf = account.Apply(playlistConf)
+		accountLimit = m.accountLimit(account)
+
+		var err error
+		if mirrors, err = m.mirrorSet(playlistConf, account, accountLimit); err != nil {
+			return err
+		}
 	}
 
-	metrics.SetPlaylistStreamsActive(playlistConf.Name, 0)
+	sem := m.semaphores.get("playlist/"+cl.name+"/"+playlistConf.Name, playlistConf.Proxy.ConcurrentStreams)
+	upstreamLimits := compactLimits(
+		m.semaphores.limit(
+			metrics.LimitUpstreamConcurrency, metrics.LimitScopePlaylist, playlistConf.Name,
+			metrics.FailureReasonPlaylistUpstreamLimit, playlistConf.Proxy.UpstreamConcurrency),
+		accountLimit,
+	)
+	viewerLimit := m.semaphores.limit(
+		metrics.LimitViewerConcurrency, metrics.LimitScopePlaylist, playlistConf.Name,
+		metrics.FailureReasonPlaylistViewerLimit, playlistConf.Proxy.ViewerConcurrency)
+
+	metrics.InitPlaylistStreamsActive(playlistConf.Name)
+
+	profile, _ := m.findProfile(cl.profile)
 
 	if err := cl.BuildPlaylistProvider(
-		playlistConf, m.config.Proxy, sem); err != nil {
+		playlistConf, m.config.Proxy, profile, sem, upstreamLimits, viewerLimit, mirrors, m.snapshots); err != nil {
 		return fmt.Errorf(
 			"failed to build playlist subscription '%s' for client '%s': %w",
 			playlistConf.Name, cl.name, err)
@@ -159,6 +397,43 @@ func (m *Manager) addPlaylistProvider(cl *Client, playlistConf config.Playlist)
 	return nil
 }
 
+func (m *Manager) accountLimit(account config.Account) *utils.Limit {
+	return m.semaphores.limit(
+		metrics.LimitUpstreamConcurrency, metrics.LimitScopeAccount, account.Name,
+		metrics.FailureReasonAccountLimit, account.Concurrency)
+}
+
+func (m *Manager) mirrorSet(
+	playlistConf config.Playlist, account config.Account, accountLimit *utils.Limit) (*mirrorSet, error) {
+	if len(playlistConf.Mirrors) == 0 {
+		return nil, nil
+	}
+	if ms, ok := m.mirrorSets[playlistConf.Name]; ok {
+		return ms, nil
+	}
+
+	ms := &mirrorSet{
+		primary:  accountLimit,
+		mirrors:  []mirror{{account: account.Name, limit: accountLimit}},
+		strategy: playlistConf.Balance,
+	}
+	for _, mirrorConf := range playlistConf.Mirrors {
+		mirrorAccount, ok := m.findAccount(mirrorConf.Account)
+		if !ok {
+			return nil, fmt.Errorf(
+				"mirror account '%s' for playlist '%s' is not defined in config", mirrorConf.Account, playlistConf.Name)
+		}
+		ms.mirrors = append(ms.mirrors, mirror{
+			account: mirrorAccount.Name,
+			limit:   m.accountLimit(mirrorAccount),
+			rewrite: mirrorRewrite(account, mirrorAccount, mirrorConf),
+		})
+	}
+
+	m.mirrorSets[playlistConf.Name] = ms
+	return ms, nil
+}
+
 func (m *Manager) addEPGProvider(cl *Client, epgConf config.EPG) error {
 	if err := cl.BuildEPGProvider(epgConf, m.config.Proxy); err != nil {
 		return fmt.Errorf(
@@ -177,6 +452,33 @@ func (m *Manager) findPlaylist(name string) (config.Playlist, error) {
 	return config.Playlist{}, fmt.Errorf("playlist not found: %s", name)
 }
 
+func (m *Manager) findClient(name string) (config.Client, bool) {
+	for _, client := range m.config.Clients {
+		if client.Name == name {
+			return client, true
+		}
+	}
+	return config.Client{}, false
+}
+
+func (m *Manager) findAccount(name string) (config.Account, bool) {
+	for _, account := range m.config.Accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+	return config.Account{}, false
+}
+
+func (m *Manager) findProfile(name string) (config.Profile, bool) {
+	for _, profile := range m.config.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return config.Profile{}, false
+}
+
 func (m *Manager) findEPG(name string) (config.EPG, error) {
 	for _, epg := range m.config.EPGs {
 		if epg.Name == name {
@@ -186,12 +488,25 @@ func (m *Manager) findEPG(name string) (config.EPG, error) {
 	return config.EPG{}, fmt.Errorf("EPG not found: %s", name)
 }
 
-func (m *Manager) createURLGenerator(clientSecret string) (*urlgen.Generator, error) {
-	secretKey := m.config.URLGenerator.Secret + clientSecret
+func (m *Manager) createURLGenerator(clientName, clientSecret string) (*urlgen.Generator, error) {
+	cfg := m.config.URLGenerator
+
+	var keys []urlgen.Key
+	if cfg.Secret != "" {
+		keys = append(keys, urlgen.Key{Secret: cfg.Secret + clientSecret})
+	}
+	for _, key := range cfg.Keys {
+		keys = append(keys, urlgen.Key{
+			ID:      key.ID,
+			Secret:  key.Secret + clientSecret,
+			Retired: key.Retired,
+		})
+	}
 
-	return urlgen.NewGenerator(
-		m.publicURLBase, secretKey,
-		time.Duration(m.config.URLGenerator.StreamTTL),
-		time.Duration(m.config.URLGenerator.FileTTL),
+	return urlgen.NewRotatingGenerator(
+		m.publicURLBase, urlgen.NewClientID(clientName), keys, cfg.ActiveKey,
+		time.Duration(cfg.StreamTTL),
+		time.Duration(cfg.FileTTL),
+		time.Duration(cfg.StableWindow),
 	)
 }
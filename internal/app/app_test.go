@@ -0,0 +1,48 @@
+package app
+
+import (
+	"majmun/internal/config"
+	"testing"
+)
+
+func TestManagerReleasesCacheStoreAfterLastUser(t *testing.T) {
+	cfg := config.DefaultConfig()
+	path := t.TempDir()
+	cfg.Proxy.HTTPClient.Cache.Path = &path
+
+	old, err := NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := old.cacheStore
+
+	if !old.Acquire() {
+		t.Fatal("expected to acquire a live manager")
+	}
+
+	next, err := old.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.cacheStore != store {
+		t.Fatal("expected reload with the same path to share the cache store")
+	}
+
+	old.Close()
+	if refs := store.refs.Load(); refs != 2 {
+		t.Errorf("expected the in-flight request and the new manager to hold the store, got %d refs", refs)
+	}
+
+	old.Release()
+	if refs := store.refs.Load(); refs != 1 {
+		t.Errorf("expected only the new manager to hold the store, got %d refs", refs)
+	}
+	if old.Acquire() {
+		t.Error("expected acquire to fail once the closed manager has no users")
+	}
+
+	next.Close()
+	if refs := store.refs.Load(); refs != 0 {
+		t.Errorf("expected the store to be released, got %d refs", refs)
+	}
+}
@@ -12,17 +12,21 @@ import (
 	"majmun/internal/listing/m3u8/rules/playlist"
 	"majmun/internal/shell"
 	"majmun/internal/urlgen"
-
-	"golang.org/x/sync/semaphore"
+	"majmun/internal/utils"
+	"time"
 )
 
 type Client struct {
 	name              string
 	secret            string
-	semaphore         *semaphore.Weighted
+	viewerLimits      []*utils.Limit
+	upstreamLimits    []*utils.Limit
 	playlistProviders []*Playlist
 	epgProviders      []*EPG
 	proxy             proxy.Proxy
+	profile           string
+	priority          int
+	queue             proxy.Queue
 	channelProcessor  *channel.Processor
 	playlistProcessor *playlist.Processor
 	epgLink           string
@@ -47,21 +51,21 @@ func NewClient(
 	playlistRules []*playlistconf.Rule,
 	publicURL string,
 	cacheStore *httpclient.Store,
+	viewerLimits []*utils.Limit,
+	upstreamLimits []*utils.Limit,
 ) (*Client, error) {
 	if clientCfg.Secret == "" {
 		return nil, fmt.Errorf("client secret cannot be empty")
 	}
 
-	var sem *semaphore.Weighted
-	if clientCfg.Proxy.ConcurrentStreams > 0 {
-		sem = semaphore.NewWeighted(clientCfg.Proxy.ConcurrentStreams)
-	}
-
 	return &Client{
 		name:              clientCfg.Name,
 		secret:            clientCfg.Secret,
-		semaphore:         sem,
+		viewerLimits:      viewerLimits,
+		upstreamLimits:    upstreamLimits,
 		proxy:             clientCfg.Proxy,
+		profile:           clientCfg.Profile,
+		priority:          clientCfg.Priority,
 		channelProcessor:  channel.NewRulesProcessor(clientCfg.Name, channelRules),
 		playlistProcessor: playlist.NewRulesProcessor(clientCfg.Name, playlistRules),
 		epgLink:           fmt.Sprintf("%s/%s/epg.xml.gz", publicURL, clientCfg.Secret),
@@ -73,15 +77,30 @@ func NewClient(
 func (c *Client) BuildPlaylistProvider(
 	playlistConf config.Playlist,
 	serverProxy proxy.Proxy,
-	sem *semaphore.Weighted,
+	profile config.Profile,
+	sem *utils.Semaphore,
+	upstreamLimits []*utils.Limit,
+	viewerLimit *utils.Limit,
+	mirrors *mirrorSet,
+	snapshots listing.SnapshotStore,
 ) error {
 	mergedProxy := mergeProxies(serverProxy, playlistConf.Proxy, c.proxy)
 	httpClient := c.newHTTPClient(mergedProxy)
 
+	onSourceError := playlistConf.OnSourceError
+	if onSourceError == "" {
+		onSourceError = config.OnSourceErrorLastGood
+		if snapshots == nil {
+			onSourceError = config.OnSourceErrorSkip
+		}
+	}
+
 	pr, err := NewPlaylistProvider(
 		playlistConf.Name,
 		c.urlGen,
 		playlistConf.Sources,
+		onSourceError,
+		snapshots,
 		mergedProxy,
 		nil,
 		sem,
@@ -91,6 +110,16 @@ func (c *Client) BuildPlaylistProvider(
 		return err
 	}
 
+	pr.upstreamLimits = compactLimits(append(upstreamLimits, c.upstreamLimits...)...)
+	pr.viewerLimit = viewerLimit
+	pr.mirrors = mirrors
+
+	if len(profile.Segmenter.Command) > 0 {
+		pr.profile = profile.Name
+		pr.profileSegmenter = mergedProxy.Segmenter
+		mergeSegmenter(&pr.profileSegmenter, profile.Segmenter)
+	}
+
 	c.playlistProviders = append(c.playlistProviders, pr)
 	return nil
 }
@@ -162,8 +191,24 @@ func (c *Client) GetProvider(prType urlgen.ProviderType, prName string) Provider
 	return nil
 }
 
-func (c *Client) Semaphore() *semaphore.Weighted {
-	return c.semaphore
+func (c *Client) ViewerLimits() []*utils.Limit {
+	return c.viewerLimits
+}
+
+func (c *Client) ConcurrentStreams() int64 {
+	limit := c.proxy.ConcurrentStreams
+	if viewers := c.proxy.ViewerConcurrency; viewers > 0 && (limit <= 0 || viewers < limit) {
+		limit = viewers
+	}
+	return limit
+}
+
+func (c *Client) Priority() int {
+	return c.priority
+}
+
+func (c *Client) QueueTimeout() time.Duration {
+	return queueTimeout(c.queue)
 }
 
 func (c *Client) EPGLink() string {
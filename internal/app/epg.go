@@ -1,6 +1,7 @@
 package app
 
 import (
+	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
 	"majmun/internal/listing"
 	"majmun/internal/shell"
@@ -9,14 +10,14 @@ import (
 
 type EPG struct {
 	name         string
-	sources      []string
+	sources      []common.Source
 	urlGenerator *urlgen.Generator
 	proxyConfig  proxy.Proxy
 	httpClient   listing.HTTPClient
 }
 
 func NewEPGProvider(
-	name string, urlGen *urlgen.Generator, sources []string, proxy proxy.Proxy,
+	name string, urlGen *urlgen.Generator, sources []common.Source, proxy proxy.Proxy,
 	httpClient listing.HTTPClient) (*EPG, error) {
 	return &EPG{
 		name:         name,
@@ -35,7 +36,7 @@ func (es *EPG) Type() string {
 	return "epg"
 }
 
-func (es *EPG) EPGs() []string {
+func (es *EPG) EPGs() []common.Source {
 	return es.sources
 }
 
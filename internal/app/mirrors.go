@@ -0,0 +1,99 @@
+package app
+
+import (
+	"majmun/internal/config"
+	"majmun/internal/streampool"
+	"majmun/internal/utils"
+	"majmun/internal/xtream"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+type mirror struct {
+	account string
+	limit   *utils.Limit
+	rewrite func(string) (string, bool)
+}
+
+type mirrorSet struct {
+	primary  *utils.Limit
+	mirrors  []mirror
+	strategy string
+	next     atomic.Uint64
+}
+
+// mirrorRewrite returns the function that moves a stream URL of the primary
+// account to the mirror. It reports false for URLs it cannot move, which are
+// then never charged to the mirror.
+func mirrorRewrite(primary, account config.Account, m config.Mirror) func(string) (string, bool) {
+	if len(m.Rewrite) > 0 {
+		pairs := make([]string, 0, len(m.Rewrite)*2)
+		for _, r := range m.Rewrite {
+			pairs = append(pairs, r.Name, r.Value)
+		}
+		replacer := strings.NewReplacer(pairs...)
+		return func(streamURL string) (string, bool) {
+			rewritten := replacer.Replace(streamURL)
+			return rewritten, rewritten != streamURL
+		}
+	}
+
+	return func(streamURL string) (string, bool) {
+		return xtream.RewriteCredentials(streamURL, primary.Xtream, account.Xtream)
+	}
+}
+
+func (ms *mirrorSet) order() []mirror {
+	result := make([]mirror, len(ms.mirrors))
+
+	switch ms.strategy {
+	case config.BalanceLeastUsed:
+		copy(result, ms.mirrors)
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].limit.Usage() < result[j].limit.Usage()
+		})
+	default:
+		start := int((ms.next.Add(1) - 1) % uint64(len(ms.mirrors)))
+		for i := range ms.mirrors {
+			result[i] = ms.mirrors[(start+i)%len(ms.mirrors)]
+		}
+	}
+
+	return result
+}
+
+func (ms *mirrorSet) requests(req streampool.Request) []streampool.Request {
+	mirrors := ms.order()
+	result := make([]streampool.Request, 0, len(mirrors))
+
+	for _, m := range mirrors {
+		r := req
+		r.Account = m.account
+		if m.rewrite != nil {
+			streamURL, ok := m.rewrite(req.StreamURL)
+			if !ok {
+				continue
+			}
+			r.StreamURL = streamURL
+			r.Fallbacks = make([]string, 0, len(req.Fallbacks))
+			for _, fallback := range req.Fallbacks {
+				if rewritten, ok := m.rewrite(fallback); ok {
+					r.Fallbacks = append(r.Fallbacks, rewritten)
+				}
+			}
+		}
+		r.Limits = make([]*utils.Limit, 0, len(req.Limits))
+		for _, limit := range req.Limits {
+			if limit.Semaphore() != ms.primary.Semaphore() {
+				r.Limits = append(r.Limits, limit)
+			}
+		}
+		if m.limit != nil {
+			r.Limits = append(r.Limits, m.limit)
+		}
+		result = append(result, r)
+	}
+
+	return result
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"majmun/internal/config"
+	"majmun/internal/config/common"
+	"majmun/internal/streampool"
+	"majmun/internal/utils"
+	"testing"
+)
+
+func testMirrorSet(strategy string) (*mirrorSet, *utils.Limit, *utils.Limit) {
+	r := newSemaphoreRegistry()
+	first := r.limit("upstream_concurrency", "account", "first", "account_limit", 2)
+	second := r.limit("upstream_concurrency", "account", "second", "account_limit", 2)
+
+	primary := config.Account{Name: "first", Xtream: &common.Xtream{
+		URL: "http://provider.com/", Username: "alice", Password: "one"}}
+	mirrorAccount := config.Account{Name: "second", Xtream: &common.Xtream{
+		URL: "http://provider.com", Username: "bob", Password: "two"}}
+
+	return &mirrorSet{
+		primary: first,
+		mirrors: []mirror{
+			{account: "first", limit: first},
+			{account: "second", limit: second,
+				rewrite: mirrorRewrite(primary, mirrorAccount, config.Mirror{Account: "second"})},
+		},
+		strategy: strategy,
+	}, first, second
+}
+
+func TestMirrorSet_RoundRobin(t *testing.T) {
+	ms, _, _ := testMirrorSet(config.BalanceRoundRobin)
+
+	var accounts []string
+	for range 3 {
+		accounts = append(accounts, ms.order()[0].account)
+	}
+
+	if accounts[0] != "first" || accounts[1] != "second" || accounts[2] != "first" {
+		t.Errorf("expected accounts to rotate, got %v", accounts)
+	}
+}
+
+func TestMirrorSet_LeastUsed(t *testing.T) {
+	ms, first, _ := testMirrorSet(config.BalanceLeastUsed)
+
+	if got := ms.order()[0].account; got != "first" {
+		t.Errorf("expected first account while both are idle, got %s", got)
+	}
+
+	if !first.Semaphore().TryAcquire() {
+		t.Fatal("failed to acquire first account slot")
+	}
+	defer first.Semaphore().Release()
+
+	if got := ms.order()[0].account; got != "second" {
+		t.Errorf("expected less used second account, got %s", got)
+	}
+}
+
+func TestMirrorSet_Requests(t *testing.T) {
+	ms, first, second := testMirrorSet(config.BalanceRoundRobin)
+	global := utils.NewLimit(utils.NewSemaphore(5), "upstream_concurrency", "global", "", "global_upstream_limit")
+
+	reqs := ms.requests(streampool.Request{
+		StreamKey: "key",
+		StreamURL: "http://provider.com/live/alice/one/42.ts",
+		Fallbacks: []string{"http://provider.com/live/alice/one/43.ts"},
+		Limits:    []*utils.Limit{first, global},
+	})
+
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(reqs))
+	}
+	if reqs[0].StreamURL != "http://provider.com/live/alice/one/42.ts" || reqs[0].Account != "first" {
+		t.Errorf("unexpected primary request: %s %s", reqs[0].Account, reqs[0].StreamURL)
+	}
+	if reqs[1].StreamURL != "http://provider.com/live/bob/two/42.ts" || reqs[1].Account != "second" {
+		t.Errorf("unexpected mirror request: %s %s", reqs[1].Account, reqs[1].StreamURL)
+	}
+	if len(reqs[1].Fallbacks) != 1 || reqs[1].Fallbacks[0] != "http://provider.com/live/bob/two/43.ts" {
+		t.Errorf("expected mirror fallbacks to use mirror credentials, got %v", reqs[1].Fallbacks)
+	}
+	if reqs[0].Fallbacks[0] != "http://provider.com/live/alice/one/43.ts" {
+		t.Errorf("expected primary fallbacks to stay unchanged, got %v", reqs[0].Fallbacks)
+	}
+	if reqs[1].StreamKey != "key" {
+		t.Errorf("expected mirror to keep stream key, got %s", reqs[1].StreamKey)
+	}
+	if len(reqs[1].Limits) != 2 || reqs[1].Limits[0] != global || reqs[1].Limits[1] != second {
+		t.Errorf("expected mirror to swap account limit, got %v", reqs[1].Limits)
+	}
+}
+
+func TestMirrorSet_RequestsRewriteEveryCredentialForm(t *testing.T) {
+	ms, _, _ := testMirrorSet(config.BalanceRoundRobin)
+
+	for _, tt := range []struct{ primary, mirror string }{
+		{"http://provider.com/alice/one/42", "http://provider.com/bob/two/42"},
+		{"http://provider.com/movie/alice/one/7.mkv", "http://provider.com/movie/bob/two/7.mkv"},
+		{"http://provider.com/series/alice/one/9.mp4", "http://provider.com/series/bob/two/9.mp4"},
+		{"http://provider.com/timeshift/alice/one/60/2024-01-01:10-00/42.ts",
+			"http://provider.com/timeshift/bob/two/60/2024-01-01:10-00/42.ts"},
+	} {
+		reqs := ms.requests(streampool.Request{StreamURL: tt.primary})
+		if len(reqs) != 2 {
+			t.Fatalf("expected 2 requests for %s, got %d", tt.primary, len(reqs))
+		}
+		for _, r := range reqs {
+			if r.Account == "second" && r.StreamURL != tt.mirror {
+				t.Errorf("expected mirror url %s, got %s", tt.mirror, r.StreamURL)
+			}
+		}
+	}
+}
+
+func TestMirrorSet_RequestsSkipMirrorForUnknownURL(t *testing.T) {
+	ms, first, _ := testMirrorSet(config.BalanceRoundRobin)
+	ms.next.Store(1)
+
+	reqs := ms.requests(streampool.Request{
+		StreamURL: "http://cdn.example.com/channel/42.m3u8",
+		Limits:    []*utils.Limit{first},
+	})
+
+	if len(reqs) != 1 || reqs[0].Account != "first" {
+		t.Fatalf("expected only the primary account, got %v", reqs)
+	}
+	if reqs[0].StreamURL != "http://cdn.example.com/channel/42.m3u8" {
+		t.Errorf("expected primary url to stay unchanged, got %s", reqs[0].StreamURL)
+	}
+}
@@ -2,39 +2,50 @@ package app
 
 import (
 	"fmt"
+	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
 	"majmun/internal/config/rules/channel"
 	"majmun/internal/listing"
 	"majmun/internal/shell"
 	"majmun/internal/streampool"
 	"majmun/internal/urlgen"
-
-	"golang.org/x/sync/semaphore"
+	"majmun/internal/utils"
+	"time"
 )
 
 type Playlist struct {
 	name string
 
-	sources []string
+	sources       []common.Source
+	onSourceError string
+	snapshots     listing.SnapshotStore
 
-	urlGenerator *urlgen.Generator
-	semaphore    *semaphore.Weighted
+	urlGenerator   *urlgen.Generator
+	semaphore      *utils.Semaphore
+	upstreamLimits []*utils.Limit
+	viewerLimit    *utils.Limit
+	mirrors        *mirrorSet
 
 	rules []*channel.Rule
 
 	proxyConfig proxy.Proxy
 	httpClient  listing.HTTPClient
 
+	profile          string
+	profileSegmenter proxy.Segmenter
+
 	streamer              *shell.Streamer
 	rateLimitStreamer     *shell.Streamer
 	upstreamErrorStreamer *shell.Streamer
 	expiredLinkStreamer   *shell.Streamer
+	queuedStreamer        *shell.Streamer
+	preemptedStreamer     *shell.Streamer
 }
 
 func NewPlaylistProvider(
 	name string, urlGen *urlgen.Generator,
-	sources []string,
-	proxy proxy.Proxy, rules []*channel.Rule, sem *semaphore.Weighted,
+	sources []common.Source, onSourceError string, snapshots listing.SnapshotStore,
+	proxy proxy.Proxy, rules []*channel.Rule, sem *utils.Semaphore,
 	httpClient listing.HTTPClient) (*Playlist, error) {
 
 	streamStreamer, err := shell.NewShellStreamer(
@@ -73,10 +84,30 @@ func NewPlaylistProvider(
 		return nil, fmt.Errorf("failed to create expired link command: %w", err)
 	}
 
+	queuedStreamer, err := shell.NewShellStreamer(
+		proxy.Error.Queued.Command,
+		proxy.Error.Queued.EnvVars,
+		proxy.Error.Queued.TemplateVars,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queued command: %w", err)
+	}
+
+	preemptedStreamer, err := shell.NewShellStreamer(
+		proxy.Error.Preempted.Command,
+		proxy.Error.Preempted.EnvVars,
+		proxy.Error.Preempted.TemplateVars,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preempted command: %w", err)
+	}
+
 	return &Playlist{
 		name:                  name,
 		urlGenerator:          urlGen,
 		sources:               sources,
+		onSourceError:         onSourceError,
+		snapshots:             snapshots,
 		semaphore:             sem,
 		proxyConfig:           proxy,
 		httpClient:            httpClient,
@@ -85,6 +116,8 @@ func NewPlaylistProvider(
 		rateLimitStreamer:     rateLimitStreamer,
 		upstreamErrorStreamer: upstreamErrorStreamer,
 		expiredLinkStreamer:   expiredLinkStreamer,
+		queuedStreamer:        queuedStreamer,
+		preemptedStreamer:     preemptedStreamer,
 	}, nil
 }
 
@@ -96,10 +129,18 @@ func (ps *Playlist) Type() string {
 	return "playlist"
 }
 
-func (ps *Playlist) Playlists() []string {
+func (ps *Playlist) Playlists() []common.Source {
 	return ps.sources
 }
 
+func (ps *Playlist) OnSourceError() string {
+	return ps.onSourceError
+}
+
+func (ps *Playlist) Snapshots() listing.SnapshotStore {
+	return ps.snapshots
+}
+
 func (ps *Playlist) URLGenerator() *urlgen.Generator {
 	return ps.urlGenerator
 }
@@ -112,10 +153,25 @@ func (ps *Playlist) Rules() []*channel.Rule {
 	return ps.rules
 }
 
-func (ps *Playlist) Semaphore() *semaphore.Weighted {
+func (ps *Playlist) Semaphore() *utils.Semaphore {
 	return ps.semaphore
 }
 
+func (ps *Playlist) UpstreamLimits() []*utils.Limit {
+	return ps.upstreamLimits
+}
+
+func (ps *Playlist) ViewerLimit() *utils.Limit {
+	return ps.viewerLimit
+}
+
+func (ps *Playlist) Mirrors(req streampool.Request) []streampool.Request {
+	if ps.mirrors == nil {
+		return nil
+	}
+	return ps.mirrors.requests(req)
+}
+
 func (ps *Playlist) IsProxied() bool {
 	return ps.proxyConfig.Enabled != nil && *ps.proxyConfig.Enabled
 }
@@ -136,10 +192,36 @@ func (ps *Playlist) ExpiredLinkStreamer() *shell.Streamer {
 	return ps.expiredLinkStreamer
 }
 
-func (ps *Playlist) ClientStreamer(playlistPath string) streampool.Streamer {
-	return ps.streamer.WithTemplateVars(map[string]any{"input": playlistPath})
+func (ps *Playlist) QueuedStreamer() *shell.Streamer {
+	if ps.proxyConfig.Queue.Placeholder == nil || !*ps.proxyConfig.Queue.Placeholder {
+		return nil
+	}
+	return ps.queuedStreamer
+}
+
+func (ps *Playlist) PreemptedStreamer() *shell.Streamer {
+	return ps.preemptedStreamer
+}
+
+func (ps *Playlist) QueueTimeout() time.Duration {
+	return queueTimeout(ps.proxyConfig.Queue)
+}
+
+func (ps *Playlist) ClientStreamer(playlistPath string, liveStartIndex int) streampool.Streamer {
+	return ps.streamer.WithTemplateVars(map[string]any{
+		"input":            playlistPath,
+		"live_start_index": liveStartIndex,
+	})
 }
 
 func (ps *Playlist) SegmenterConfig() proxy.Segmenter {
 	return ps.proxyConfig.Segmenter
 }
+
+func (ps *Playlist) Profile() string {
+	return ps.profile
+}
+
+func (ps *Playlist) ProfileSegmenterConfig() proxy.Segmenter {
+	return ps.profileSegmenter
+}
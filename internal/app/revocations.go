@@ -0,0 +1,82 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type revocationStore struct {
+	path    string
+	revoked map[string]time.Time
+	mu      sync.Mutex
+}
+
+func newRevocationStore(path string) (*revocationStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create revocation directory: %w", err)
+	}
+
+	s := &revocationStore{
+		path:    path,
+		revoked: make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocations: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.revoked); err != nil {
+		return nil, fmt.Errorf("failed to parse revocations: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *revocationStore) get(clientName string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[clientName]
+}
+
+func (s *revocationStore) set(clientName string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.revoked[clientName]
+	s.revoked[clientName] = before.UTC()
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.revoked[clientName] = prev
+		} else {
+			delete(s.revoked, clientName)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *revocationStore) save() error {
+	data, err := json.MarshalIndent(s.revoked, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode revocations: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write revocations: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write revocations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRevocationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "revocations.json")
+
+	st, err := newRevocationStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	if got := st.get("alice"); !got.IsZero() {
+		t.Errorf("expected no revocation, got %v", got)
+	}
+
+	revoked := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := st.set("alice", revoked); err != nil {
+		t.Fatalf("failed to set revocation: %v", err)
+	}
+
+	reopened, err := newRevocationStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	if got := reopened.get("alice"); !got.Equal(revoked) {
+		t.Errorf("expected %v after reopen, got %v", revoked, got)
+	}
+	if got := reopened.get("bob"); !got.IsZero() {
+		t.Errorf("expected no revocation for other client, got %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"majmun/internal/metrics"
+	"majmun/internal/utils"
+	"sync"
+)
+
+type semaphoreEntry struct {
+	limit  int64
+	sem    *utils.Semaphore
+	metric []string
+}
+
+// semaphoreRegistry keeps semaphores across config reloads, so segmenters and
+// viewers started before a reload stay counted against the reloaded limits.
+type semaphoreRegistry struct {
+	entries map[string]*semaphoreEntry
+	pending map[string]*semaphoreEntry
+	mu      sync.Mutex
+}
+
+func newSemaphoreRegistry() *semaphoreRegistry {
+	return &semaphoreRegistry{
+		entries: make(map[string]*semaphoreEntry),
+		pending: make(map[string]*semaphoreEntry),
+	}
+}
+
+// begin starts collecting the limits of a new config. They are staged until
+// commit, so a rejected config leaves the registry untouched.
+func (r *semaphoreRegistry) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = make(map[string]*semaphoreEntry)
+}
+
+// commit applies the limits collected since begin: running semaphores are
+// resized, new ones are added and the ones the config no longer has are
+// dropped.
+func (r *semaphoreRegistry) commit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.entries {
+		if _, ok := r.pending[key]; !ok {
+			delete(r.entries, key)
+		}
+	}
+
+	for key, p := range r.pending {
+		e, ok := r.entries[key]
+		if !ok {
+			e = &semaphoreEntry{sem: p.sem}
+			r.entries[key] = e
+		}
+		if p.limit != e.limit {
+			e.sem.Resize(p.limit)
+			e.limit = p.limit
+		}
+		e.metric = p.metric
+		if e.metric != nil {
+			metrics.SetConcurrencyLimit(e.metric[0], e.metric[1], e.metric[2], e.limit)
+		}
+	}
+
+	r.pending = make(map[string]*semaphoreEntry)
+}
+
+func (r *semaphoreRegistry) get(key string, limit int64) *utils.Semaphore {
+	return r.stage(key, limit, nil)
+}
+
+// stage records limit for key and returns the semaphore that will carry it
+// after commit: the running one if there is one, a new one otherwise.
+func (r *semaphoreRegistry) stage(key string, limit int64, metric []string) *utils.Semaphore {
+	if limit <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.pending[key]; ok {
+		p.limit = limit
+		if metric != nil {
+			p.metric = metric
+		}
+		return p.sem
+	}
+
+	p := &semaphoreEntry{limit: limit, metric: metric}
+	if e, ok := r.entries[key]; ok {
+		p.sem = e.sem
+	} else {
+		p.sem = utils.NewSemaphore(limit)
+	}
+	r.pending[key] = p
+	return p.sem
+}
+
+func (r *semaphoreRegistry) limit(name, scope, target, reason string, limit int64) *utils.Limit {
+	key := name + "/" + scope
+	if target != "" {
+		key += "/" + target
+	}
+
+	sem := r.stage(key, limit, []string{name, scope, target})
+	if sem == nil {
+		return nil
+	}
+
+	return utils.NewLimit(sem, name, scope, target, reason)
+}
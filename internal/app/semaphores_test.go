@@ -0,0 +1,106 @@
+package app
+
+import "testing"
+
+func TestSemaphoreRegistry(t *testing.T) {
+	r := newSemaphoreRegistry()
+
+	if sem := r.get("playlist", 0); sem != nil {
+		t.Error("expected nil semaphore for zero limit")
+	}
+
+	first := r.get("playlist", 2)
+	if first == nil {
+		t.Fatal("expected semaphore for positive limit")
+	}
+
+	if second := r.get("playlist", 2); second != first {
+		t.Error("expected same semaphore for unchanged limit")
+	}
+
+	if third := r.get("playlist", 3); third != first {
+		t.Error("expected same semaphore after limit change")
+	}
+
+	if other := r.get("client", 2); other == first {
+		t.Error("expected separate semaphore for different key")
+	}
+}
+
+func TestSemaphoreRegistry_Limit(t *testing.T) {
+	r := newSemaphoreRegistry()
+
+	if l := r.limit("upstream_concurrency", "global", "", "global_upstream_limit", 0); l != nil {
+		t.Error("expected nil limit for zero limit")
+	}
+
+	first := r.limit("upstream_concurrency", "playlist", "tv", "playlist_upstream_limit", 2)
+	if first == nil {
+		t.Fatal("expected limit for positive limit")
+	}
+	if first.Reason != "playlist_upstream_limit" {
+		t.Errorf("unexpected reason: %s", first.Reason)
+	}
+
+	if second := r.limit("upstream_concurrency", "playlist", "tv", "playlist_upstream_limit", 2); second.Semaphore() != first.Semaphore() {
+		t.Error("expected limits with the same key to share a semaphore")
+	}
+
+	if viewer := r.limit("viewer_concurrency", "playlist", "tv", "playlist_viewer_limit", 2); viewer.Semaphore() == first.Semaphore() {
+		t.Error("expected separate semaphore for viewer limit")
+	}
+}
+
+func TestSemaphoreRegistry_CommitResizesInPlace(t *testing.T) {
+	r := newSemaphoreRegistry()
+
+	r.begin()
+	sem := r.get("playlist", 2)
+	r.get("removed", 1)
+	r.commit()
+
+	if !sem.TryAcquire() || !sem.TryAcquire() {
+		t.Fatal("expected two free slots")
+	}
+
+	r.begin()
+	if r.get("playlist", 1) != sem {
+		t.Fatal("expected reload to keep the semaphore")
+	}
+	sem.Release()
+	if !sem.TryAcquire() {
+		t.Error("expected the previous limit to apply until commit")
+	}
+	sem.Release()
+	r.commit()
+
+	if sem.TryAcquire() {
+		t.Error("expected the held slot to count against the new limit")
+	}
+	if _, ok := r.entries["removed"]; ok {
+		t.Error("expected semaphore missing from the config to be pruned")
+	}
+}
+
+func TestSemaphoreRegistry_RejectedConfigLeavesEntries(t *testing.T) {
+	r := newSemaphoreRegistry()
+
+	r.begin()
+	sem := r.get("playlist", 2)
+	r.commit()
+
+	r.begin()
+	r.get("playlist", 1)
+	r.get("phantom", 1)
+
+	r.begin()
+	if _, ok := r.entries["phantom"]; ok {
+		t.Error("expected semaphore of a rejected config not to be registered")
+	}
+	if !sem.TryAcquire() || !sem.TryAcquire() {
+		t.Error("expected rejected config not to resize the running semaphore")
+	}
+	if got := r.get("playlist", 2); got != sem {
+		t.Error("expected the next config to reuse the running semaphore")
+	}
+}
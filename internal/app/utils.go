@@ -3,6 +3,7 @@ package app
 import (
 	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
+	"majmun/internal/utils"
 	"time"
 )
 
@@ -54,6 +55,23 @@ func uniqueNames(names []string) []string {
 	return result
 }
 
+func compactLimits(limits ...*utils.Limit) []*utils.Limit {
+	result := make([]*utils.Limit, 0, len(limits))
+	for _, l := range limits {
+		if l != nil {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+func queueTimeout(q proxy.Queue) time.Duration {
+	if q.Timeout == nil {
+		return 0
+	}
+	return time.Duration(*q.Timeout)
+}
+
 func mergeProxies(proxies ...proxy.Proxy) proxy.Proxy {
 	result := proxy.Proxy{}
 	for _, p := range proxies {
@@ -63,6 +81,21 @@ func mergeProxies(proxies ...proxy.Proxy) proxy.Proxy {
 		if p.ConcurrentStreams > 0 {
 			result.ConcurrentStreams = p.ConcurrentStreams
 		}
+		if p.UpstreamConcurrency > 0 {
+			result.UpstreamConcurrency = p.UpstreamConcurrency
+		}
+		if p.ViewerConcurrency > 0 {
+			result.ViewerConcurrency = p.ViewerConcurrency
+		}
+		if p.HLS != nil {
+			result.HLS = p.HLS
+		}
+		if p.Queue.Timeout != nil {
+			result.Queue.Timeout = p.Queue.Timeout
+		}
+		if p.Queue.Placeholder != nil {
+			result.Queue.Placeholder = p.Queue.Placeholder
+		}
 
 		if p.HTTPClient.Cache.Enabled != nil {
 			result.HTTPClient.Cache.Enabled = p.HTTPClient.Cache.Enabled
@@ -93,6 +126,12 @@ func mergeProxies(proxies ...proxy.Proxy) proxy.Proxy {
 
 		result.Error.UpstreamError = mergeHandlers(
 			result.Error.Handler, result.Error.UpstreamError, p.Error.UpstreamError)
+
+		result.Error.Queued = mergeHandlers(
+			result.Error.Handler, result.Error.Queued, p.Error.Queued)
+
+		result.Error.Preempted = mergeHandlers(
+			result.Error.Handler, result.Error.Preempted, p.Error.Preempted)
 	}
 
 	return result
@@ -123,4 +162,16 @@ func mergeSegmenter(dst *proxy.Segmenter, src proxy.Segmenter) {
 	if src.ReadyTimeout != nil {
 		dst.ReadyTimeout = src.ReadyTimeout
 	}
+	if src.HLSIdleTimeout != nil {
+		dst.HLSIdleTimeout = src.HLSIdleTimeout
+	}
+	if src.Linger != nil {
+		dst.Linger = src.Linger
+	}
+	if src.StallSegments != nil {
+		dst.StallSegments = src.StallSegments
+	}
+	if src.Timeshift != nil {
+		dst.Timeshift = src.Timeshift
+	}
 }
@@ -0,0 +1,120 @@
+package catchup
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeDefault   = "default"
+	TypeAppend    = "append"
+	TypeShift     = "shift"
+	TypeTimeshift = "timeshift"
+
+	QueryStart = "utc"
+	QueryEnd   = "utcend"
+
+	SourceQuery = QueryStart + "={utc}&" + QueryEnd + "={utcend}"
+)
+
+var durationDivider = regexp.MustCompile(`\{duration:(\d+)\}`)
+
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+func Template(catchupType, source, streamURL string) string {
+	switch catchupType {
+	case TypeDefault:
+		return source
+	case TypeAppend:
+		if source == "" {
+			return ""
+		}
+		return streamURL + source
+	case TypeShift, TypeTimeshift:
+		return appendQuery(streamURL, "utc={utc}&lutc={lutc}")
+	}
+
+	if u, err := url.Parse(source); err == nil && u.Host != "" {
+		return source
+	}
+	return ""
+}
+
+func ParseQuery(rawQuery string) (Window, bool) {
+	if rawQuery == "" {
+		return Window{}, false
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Window{}, false
+	}
+
+	start, err := strconv.ParseInt(values.Get(QueryStart), 10, 64)
+	if err != nil || start <= 0 {
+		return Window{}, false
+	}
+
+	w := Window{Start: time.Unix(start, 0)}
+	if end, err := strconv.ParseInt(values.Get(QueryEnd), 10, 64); err == nil && end > start {
+		w.End = time.Unix(end, 0)
+	} else {
+		w.End = time.Now()
+	}
+
+	return w, true
+}
+
+func Expand(template string, w Window, now time.Time) string {
+	start, end := w.Start.UTC(), w.End.UTC()
+	duration := int64(end.Sub(start).Seconds())
+
+	result := durationDivider.ReplaceAllStringFunc(template, func(m string) string {
+		divider, _ := strconv.ParseInt(durationDivider.FindStringSubmatch(m)[1], 10, 64)
+		if divider <= 0 {
+			return m
+		}
+		return strconv.FormatInt(duration/divider, 10)
+	})
+
+	startUnix := strconv.FormatInt(start.Unix(), 10)
+	endUnix := strconv.FormatInt(end.Unix(), 10)
+	nowUnix := strconv.FormatInt(now.Unix(), 10)
+	offset := strconv.FormatInt(int64(now.Sub(start).Seconds()), 10)
+
+	return strings.NewReplacer(
+		"${start}", startUnix,
+		"${end}", endUnix,
+		"${timestamp}", nowUnix,
+		"${now}", nowUnix,
+		"${duration}", strconv.FormatInt(duration, 10),
+		"${offset}", offset,
+		"{utcend}", endUnix,
+		"{utc}", startUnix,
+		"{lutc}", nowUnix,
+		"{start}", startUnix,
+		"{end}", endUnix,
+		"{now}", nowUnix,
+		"{duration}", strconv.FormatInt(duration, 10),
+		"{offset}", offset,
+		"{Y}", start.Format("2006"),
+		"{m}", start.Format("01"),
+		"{d}", start.Format("02"),
+		"{H}", start.Format("15"),
+		"{M}", start.Format("04"),
+		"{S}", start.Format("05"),
+	).Replace(result)
+}
+
+func appendQuery(rawURL, query string) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
@@ -0,0 +1,63 @@
+package catchup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		catchupType string
+		source      string
+		streamURL   string
+		want        string
+	}{
+		{"default", TypeDefault, "http://p.com/arch/{utc}.ts", "http://p.com/live.ts", "http://p.com/arch/{utc}.ts"},
+		{"append", TypeAppend, "?start=${start}", "http://p.com/live.ts", "http://p.com/live.ts?start=${start}"},
+		{"append without source", TypeAppend, "", "http://p.com/live.ts", ""},
+		{"shift", TypeShift, "", "http://p.com/live.ts?a=1", "http://p.com/live.ts?a=1&utc={utc}&lutc={lutc}"},
+		{"unknown type with absolute source", "", "http://p.com/arch/{utc}.ts", "http://p.com/live.ts", "http://p.com/arch/{utc}.ts"},
+		{"unsupported", "flussonic", "", "http://p.com/live.ts", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Template(tt.catchupType, tt.source, tt.streamURL))
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	w, ok := ParseQuery("utc=1700000000&utcend=1700003600")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1700000000), w.Start.Unix())
+	assert.Equal(t, int64(1700003600), w.End.Unix())
+
+	_, ok = ParseQuery("token=abc")
+	assert.False(t, ok)
+
+	_, ok = ParseQuery("")
+	assert.False(t, ok)
+}
+
+func TestExpand(t *testing.T) {
+	w := Window{Start: time.Unix(1700000000, 0), End: time.Unix(1700003600, 0)}
+	now := time.Unix(1700007200, 0)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"http://p.com/arch?start=${start}&dur=${duration}", "http://p.com/arch?start=1700000000&dur=3600"},
+		{"http://p.com/arch?utc={utc}&end={utcend}&now={lutc}", "http://p.com/arch?utc=1700000000&end=1700003600&now=1700007200"},
+		{"http://p.com/{Y}/{m}/{d}/{H}-{M}-{S}.ts", "http://p.com/2023/11/14/22-13-20.ts"},
+		{"http://p.com/arch?min={duration:60}&offset=${offset}&ts=${timestamp}", "http://p.com/arch?min=60&offset=7200&ts=1700007200"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Expand(tt.template, w, now))
+	}
+}
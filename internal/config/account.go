@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+	"net/url"
+)
+
+type Account struct {
+	Name        string             `yaml:"name"`
+	Concurrency int64              `yaml:"concurrency,omitempty"`
+	Xtream      *common.Xtream     `yaml:"xtream,omitempty"`
+	Headers     []common.NameValue `yaml:"headers,omitempty"`
+}
+
+func (a *Account) Validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if a.Concurrency < 0 {
+		return fmt.Errorf("concurrency cannot be negative")
+	}
+	if a.Xtream != nil && a.Xtream.URL != "" {
+		u, err := url.Parse(a.Xtream.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("xtream: url must be an http(s) url")
+		}
+	}
+	for i, header := range a.Headers {
+		if err := header.Validate(); err != nil {
+			return fmt.Errorf("headers[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (a *Account) Apply(p Playlist) Playlist {
+	if len(p.Sources) == 0 && a.Xtream != nil {
+		xtream := *a.Xtream
+		p.Sources = common.Sources{{Xtream: &xtream}}
+	} else if a.Xtream != nil {
+		sources := make(common.Sources, len(p.Sources))
+		for i, source := range p.Sources {
+			if source.Xtream != nil {
+				xtream := *source.Xtream
+				if xtream.URL == "" {
+					xtream.URL = a.Xtream.URL
+				}
+				if xtream.Username == "" {
+					xtream.Username = a.Xtream.Username
+				}
+				if xtream.Password == "" {
+					xtream.Password = a.Xtream.Password
+				}
+				source.Xtream = &xtream
+			}
+			sources[i] = source
+		}
+		p.Sources = sources
+	}
+
+	p.Proxy.HTTPClient.Headers = common.MergeNameValues(a.Headers, p.Proxy.HTTPClient.Headers)
+	return p
+}
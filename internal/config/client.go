@@ -4,17 +4,21 @@ import (
 	"fmt"
 	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
+	"time"
 )
 
 type Client struct {
-	Name      string             `yaml:"name"`
-	Secret    string             `yaml:"secret"`
-	Playlists common.StringOrArr `yaml:"playlists"`
-	EPGs      common.StringOrArr `yaml:"epgs"`
-	Proxy     proxy.Proxy        `yaml:"proxy,omitempty"`
+	Name          string             `yaml:"name"`
+	Secret        string             `yaml:"secret"`
+	Playlists     common.StringOrArr `yaml:"playlists"`
+	EPGs          common.StringOrArr `yaml:"epgs"`
+	RevokedBefore time.Time          `yaml:"revoked_before,omitempty"`
+	Profile       string             `yaml:"profile,omitempty"`
+	Priority      int                `yaml:"priority,omitempty"`
+	Proxy         proxy.Proxy        `yaml:"proxy,omitempty"`
 }
 
-func (c *Client) Validate(playlistNames, epgNames map[string]bool) error {
+func (c *Client) Validate(playlistNames, epgNames, profileNames map[string]bool) error {
 	if c.Name == "" {
 		return fmt.Errorf("name is required")
 	}
@@ -32,6 +36,10 @@ func (c *Client) Validate(playlistNames, epgNames map[string]bool) error {
 		}
 	}
 
+	if c.Profile != "" && !profileNames[c.Profile] {
+		return fmt.Errorf("unknown profile: %s", c.Profile)
+	}
+
 	if err := c.Proxy.ValidateOverride(); err != nil {
 		return fmt.Errorf("proxy: %w", err)
 	}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Xtream struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type Source struct {
+	URL    string  `yaml:"url"`
+	Xtream *Xtream `yaml:"xtream"`
+}
+
+type Sources []Source
+
+func (s *Source) UnmarshalYAML(node *yaml.Node) error {
+	var single string
+	if err := node.Decode(&single); err == nil {
+		*s = Source{URL: single}
+		return nil
+	}
+
+	type sourceYAML Source
+	var src sourceYAML
+	if err := DecodeStrict(node, &src); err != nil {
+		return err
+	}
+
+	*s = Source(src)
+	return nil
+}
+
+func (s *Sources) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var single Source
+		if err := node.Decode(&single); err != nil {
+			return err
+		}
+		*s = Sources{single}
+		return nil
+	}
+
+	var multiple []Source
+	if err := node.Decode(&multiple); err != nil {
+		return err
+	}
+
+	*s = multiple
+	return nil
+}
+
+func (s Source) Validate() error {
+	if s.Xtream == nil {
+		if s.URL == "" {
+			return fmt.Errorf("cannot be empty")
+		}
+		return nil
+	}
+
+	if s.URL != "" {
+		return fmt.Errorf("url and xtream cannot be used together")
+	}
+	return s.Xtream.Validate()
+}
+
+func (s Source) String() string {
+	if s.Xtream != nil {
+		return s.Xtream.URL
+	}
+	return s.URL
+}
+
+// ID identifies the source across reloads. Unlike String it tells apart
+// Xtream accounts on the same panel.
+func (s Source) ID() string {
+	if s.Xtream != nil {
+		return "xtream:" + s.Xtream.URL + "\n" + s.Xtream.Username
+	}
+	return s.URL
+}
+
+func (x *Xtream) Validate() error {
+	if x.URL == "" {
+		return fmt.Errorf("xtream: url is required")
+	}
+	u, err := url.Parse(x.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("xtream: url must be an http(s) url")
+	}
+	if x.Username == "" {
+		return fmt.Errorf("xtream: username is required")
+	}
+	if x.Password == "" {
+		return fmt.Errorf("xtream: password is required")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSources_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		yamlData string
+		expected Sources
+		wantErr  bool
+	}{
+		{
+			name:     "single string",
+			yamlData: `"http://example.com/playlist.m3u"`,
+			expected: Sources{{URL: "http://example.com/playlist.m3u"}},
+		},
+		{
+			name:     "string array",
+			yamlData: `["http://a.com/1.m3u", "/local/2.m3u"]`,
+			expected: Sources{{URL: "http://a.com/1.m3u"}, {URL: "/local/2.m3u"}},
+		},
+		{
+			name:     "single xtream",
+			yamlData: "xtream:\n  url: http://x.com\n  username: u\n  password: p\n",
+			expected: Sources{{Xtream: &Xtream{URL: "http://x.com", Username: "u", Password: "p"}}},
+		},
+		{
+			name:     "mixed array",
+			yamlData: "- http://a.com/1.m3u\n- xtream:\n    url: http://x.com\n    username: u\n    password: p\n",
+			expected: Sources{
+				{URL: "http://a.com/1.m3u"},
+				{Xtream: &Xtream{URL: "http://x.com", Username: "u", Password: "p"}},
+			},
+		},
+		{
+			name:     "url mapping",
+			yamlData: "- url: http://a.com/1.m3u\n",
+			expected: Sources{{URL: "http://a.com/1.m3u"}},
+		},
+		{
+			name:     "unknown field",
+			yamlData: "m3u:\n  url: http://x.com\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Sources
+			err := yaml.Unmarshal([]byte(tt.yamlData), &s)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s)
+		})
+	}
+}
+
+func TestSource_Validate(t *testing.T) {
+	assert.NoError(t, Source{URL: "/local/file.m3u"}.Validate())
+	assert.Error(t, Source{}.Validate())
+	assert.Error(t, Source{URL: "http://a.com", Xtream: &Xtream{URL: "http://x.com", Username: "u", Password: "p"}}.Validate())
+	assert.NoError(t, Source{Xtream: &Xtream{URL: "http://x.com", Username: "u", Password: "p"}}.Validate())
+	assert.Error(t, Source{Xtream: &Xtream{URL: "x.com", Username: "u", Password: "p"}}.Validate())
+	assert.Error(t, Source{Xtream: &Xtream{URL: "http://x.com", Password: "p"}}.Validate())
+	assert.Error(t, Source{Xtream: &Xtream{URL: "http://x.com", Username: "u"}}.Validate())
+}
+
+func TestSource_ID(t *testing.T) {
+	assert.Equal(t, "http://a.com/list.m3u", Source{URL: "http://a.com/list.m3u"}.ID())
+
+	first := Source{Xtream: &Xtream{URL: "http://x.com", Username: "a", Password: "p"}}
+	second := Source{Xtream: &Xtream{URL: "http://x.com", Username: "b", Password: "p"}}
+	assert.NotEqual(t, first.ID(), second.ID())
+	assert.Equal(t, first.ID(), Source{Xtream: &Xtream{URL: "http://x.com", Username: "a", Password: "q"}}.ID())
+}
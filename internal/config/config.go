@@ -15,7 +15,10 @@ type Config struct {
 	Logs          Logs               `yaml:"logs"`
 	URLGenerator  URLGeneratorConfig `yaml:"url_generator"`
 	Proxy         proxy.Proxy        `yaml:"proxy"`
+	Profiles      []Profile          `yaml:"profiles,omitempty"`
 	Clients       []Client           `yaml:"clients"`
+	Accounts      []Account          `yaml:"accounts,omitempty"`
+	DVR           DVR                `yaml:"dvr,omitempty"`
 	Playlists     []Playlist         `yaml:"playlists"`
 	EPGs          []EPG              `yaml:"epgs"`
 	ChannelRules  channel.Rules      `yaml:"channel_rules,omitempty"`
@@ -45,13 +48,41 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("proxy configuration validation failed: %w", err)
 	}
 
+	profileNames := make(map[string]bool)
+
+	for i, profile := range c.Profiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("profile[%d] validation failed: %w", i, err)
+		}
+		if profileNames[profile.Name] {
+			return fmt.Errorf("duplicate profile name: %s", profile.Name)
+		}
+		profileNames[profile.Name] = true
+	}
+
+	accounts := make(map[string]Account)
+
+	for i, account := range c.Accounts {
+		if err := account.Validate(); err != nil {
+			return fmt.Errorf("account[%d] validation failed: %w", i, err)
+		}
+		if _, ok := accounts[account.Name]; ok {
+			return fmt.Errorf("duplicate account name: %s", account.Name)
+		}
+		accounts[account.Name] = account
+	}
+
 	playlistNames := make(map[string]bool)
 	epgNames := make(map[string]bool)
 
 	for i, pl := range c.Playlists {
-		if err := pl.Validate(); err != nil {
+		if err := pl.Validate(accounts); err != nil {
 			return fmt.Errorf("playlist[%d] validation failed: %w", i, err)
 		}
+		if pl.OnSourceError == OnSourceErrorLastGood && c.Server.DataDir == "" {
+			return fmt.Errorf("playlist[%d] validation failed: on_source_error %s requires server.data_dir",
+				i, OnSourceErrorLastGood)
+		}
 		if pl.Name != "" {
 			if playlistNames[pl.Name] {
 				return fmt.Errorf("duplicate playlist name: %s", pl.Name)
@@ -76,7 +107,7 @@ func (c *Config) Validate() error {
 	clientSecrets := make(map[string][]string)
 
 	for i, client := range c.Clients {
-		if err := client.Validate(playlistNames, epgNames); err != nil {
+		if err := client.Validate(playlistNames, epgNames, profileNames); err != nil {
 			return fmt.Errorf("client[%d] validation failed: %w", i, err)
 		}
 
@@ -93,6 +124,10 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if err := c.DVR.Validate(clientNames); err != nil {
+		return fmt.Errorf("dvr configuration validation failed: %w", err)
+	}
+
 	for i, rule := range c.ChannelRules {
 		if err := rule.Validate(); err != nil {
 			return fmt.Errorf("channel_rules[%d] validation failed: %w", i, err)
@@ -127,6 +162,9 @@ func (c *Config) validateChannelRuleReferences(rule *channel.Rule, clientNames,
 	if rule.MarkHidden != nil && rule.MarkHidden.Condition != nil {
 		return c.validateConditionReferences(*rule.MarkHidden.Condition, clientNames, playlistNames)
 	}
+	if rule.PinStream != nil && rule.PinStream.Condition != nil {
+		return c.validateConditionReferences(*rule.PinStream.Condition, clientNames, playlistNames)
+	}
 	return nil
 }
 
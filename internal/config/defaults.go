@@ -15,8 +15,9 @@ func DefaultConfig() *Config {
 
 	return &Config{
 		Server: ServerConfig{
-			ListenAddr: ":8080",
-			PublicURL:  common.URL(*publicUrl),
+			ListenAddr:     ":8080",
+			PublicURL:      common.URL(*publicUrl),
+			ListingRefresh: common.Duration(15 * time.Minute),
 		},
 		Logs: Logs{
 			"info",
@@ -40,6 +41,7 @@ func DefaultConfig() *Config {
 				Command: common.StringOrArr{
 					"ffmpeg",
 					"-v", "{{ .ffmpeg_log_level }}",
+					"-live_start_index", "{{ .live_start_index }}",
 					"-i", "{{ .input }}",
 					"-c", "copy",
 					"-f", "mpegts",
@@ -58,7 +60,7 @@ func DefaultConfig() *Config {
 					"-f", "hls",
 					"-hls_time", "{{ .segment_duration }}",
 					"-hls_list_size", "{{ .max_segments }}",
-					"-hls_flags", "delete_segments+append_list+independent_segments",
+					"-hls_flags", "delete_segments+append_list+independent_segments{{ if .restarted }}+discont_start{{ end }}",
 					"-hls_segment_filename", "{{ .segment_path }}",
 					"{{ .playlist_path }}",
 				},
@@ -67,8 +69,16 @@ func DefaultConfig() *Config {
 					{Name: "segment_duration", Value: "2"},
 					{Name: "max_segments", Value: "15"},
 				},
-				InitSegments: intPtr(1),
-				ReadyTimeout: durationPtr(30 * time.Second),
+				InitSegments:   intPtr(1),
+				ReadyTimeout:   durationPtr(30 * time.Second),
+				HLSIdleTimeout: durationPtr(30 * time.Second),
+				Linger:         durationPtr(0),
+				StallSegments:  intPtr(5),
+				Timeshift:      durationPtr(0),
+			},
+			Queue: proxy.Queue{
+				Timeout:     durationPtr(3 * time.Second),
+				Placeholder: boolPtr(false),
 			},
 			Error: proxy.Error{
 				Handler: proxy.Handler{
@@ -107,6 +117,16 @@ func DefaultConfig() *Config {
 						{Name: "message", Value: "Unable to play stream\n\nPlease try again later or contact administrator"},
 					},
 				},
+				Queued: proxy.Handler{
+					TemplateVars: []common.NameValue{
+						{Name: "message", Value: "Waiting for a free slot\n\nPlayback will start automatically"},
+					},
+				},
+				Preempted: proxy.Handler{
+					TemplateVars: []common.NameValue{
+						{Name: "message", Value: "Stream stopped\n\nThe slot was taken by a higher priority client"},
+					},
+				},
 			},
 		},
 	}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+	"regexp"
+	"time"
+)
+
+type DVR struct {
+	Dir        string          `yaml:"dir"`
+	Retention  common.Duration `yaml:"retention"`
+	Recordings []DVRRecording  `yaml:"recordings,omitempty"`
+}
+
+type DVRRecording struct {
+	Client  string    `yaml:"client"`
+	Channel string    `yaml:"channel"`
+	Title   string    `yaml:"title"`
+	Start   time.Time `yaml:"start"`
+	End     time.Time `yaml:"end"`
+}
+
+func (d *DVR) Validate(clientNames map[string]bool) error {
+	if d.Retention < 0 {
+		return fmt.Errorf("retention cannot be negative")
+	}
+
+	for i, rec := range d.Recordings {
+		if err := rec.Validate(); err != nil {
+			return fmt.Errorf("recordings[%d]: %w", i, err)
+		}
+		if !clientNames[rec.Client] {
+			return fmt.Errorf("recordings[%d]: unknown client: %s", i, rec.Client)
+		}
+	}
+
+	return nil
+}
+
+func (r *DVRRecording) Validate() error {
+	if r.Client == "" {
+		return fmt.Errorf("client is required")
+	}
+
+	if r.Title != "" {
+		if _, err := regexp.Compile(r.Title); err != nil {
+			return fmt.Errorf("invalid title pattern: %w", err)
+		}
+		if !r.Start.IsZero() || !r.End.IsZero() {
+			return fmt.Errorf("start and end cannot be used with title")
+		}
+		return nil
+	}
+
+	if r.Channel == "" {
+		return fmt.Errorf("channel is required without title")
+	}
+	if r.Start.IsZero() || r.End.IsZero() {
+		return fmt.Errorf("start and end are required without title")
+	}
+	if !r.End.After(r.Start) {
+		return fmt.Errorf("end must be after start")
+	}
+	return nil
+}
@@ -7,9 +7,9 @@ import (
 )
 
 type EPG struct {
-	Name    string             `yaml:"name"`
-	Sources common.StringOrArr `yaml:"sources"`
-	Proxy   proxy.Proxy        `yaml:"proxy,omitempty"`
+	Name    string         `yaml:"name"`
+	Sources common.Sources `yaml:"sources"`
+	Proxy   proxy.Proxy    `yaml:"proxy,omitempty"`
 }
 
 func (e *EPG) Validate() error {
@@ -20,8 +20,8 @@ func (e *EPG) Validate() error {
 		return fmt.Errorf("sources is required")
 	}
 	for i, source := range e.Sources {
-		if source == "" {
-			return fmt.Errorf("sources[%d] cannot be empty", i)
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("sources[%d]: %w", i, err)
 		}
 	}
 	if err := e.Proxy.ValidateOverride(); err != nil {
@@ -8,34 +8,13 @@ import (
 )
 
 func Load(path string) (*Config, error) {
-	info, err := os.Stat(path)
+	files, err := configFiles(path)
 	if err != nil {
 		return nil, err
 	}
 
 	c := DefaultConfig()
 
-	var files []string
-	if !info.IsDir() {
-		files = []string{path}
-	} else {
-		entries, err := os.ReadDir(path)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			ext := filepath.Ext(entry.Name())
-			if ext != ".yaml" && ext != ".yml" {
-				continue
-			}
-			files = append(files, filepath.Join(path, entry.Name()))
-		}
-	}
-
 	for _, file := range files {
 		f, err := os.Open(file)
 		if err != nil {
@@ -56,3 +35,33 @@ func Load(path string) (*Config, error) {
 
 	return c, nil
 }
+
+func configFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+
+	return files, nil
+}
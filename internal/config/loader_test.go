@@ -51,6 +51,179 @@ url_generator:
 			expectError:   true,
 			validate:      nil,
 		},
+		{
+			name: "profile without segmenter command",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+profiles:
+  - name: 720p
+    segmenter:
+      linger: 1m`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "last_good without data_dir",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+playlists:
+  - name: tv
+    sources: http://example.com/tv.m3u
+    on_source_error: last_good`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "copy profile",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+profiles:
+  - name: copy`,
+			expectError: false,
+			validate:    nil,
+		},
+		{
+			name: "playlists sharing an account",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+accounts:
+  - name: provider
+    concurrency: 2
+    xtream:
+      url: "http://provider.example.com"
+      username: "user"
+      password: "pass"
+    headers:
+      - name: User-Agent
+        value: majmun
+playlists:
+  - name: sports
+    account: provider
+  - name: movies
+    account: provider
+    sources:
+      - xtream:
+          url: "http://mirror.example.com"`,
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.Accounts) != 1 || cfg.Accounts[0].Concurrency != 2 {
+					t.Fatalf("expected one account with concurrency 2, got %+v", cfg.Accounts)
+				}
+				account := cfg.Accounts[0]
+
+				sports := account.Apply(cfg.Playlists[0])
+				if len(sports.Sources) != 1 || sports.Sources[0].Xtream == nil ||
+					sports.Sources[0].Xtream.URL != "http://provider.example.com" {
+					t.Errorf("expected sports to use account xtream source, got %+v", sports.Sources)
+				}
+				if len(sports.Proxy.HTTPClient.Headers) != 1 || sports.Proxy.HTTPClient.Headers[0].Value != "majmun" {
+					t.Errorf("expected sports to inherit account headers, got %+v", sports.Proxy.HTTPClient.Headers)
+				}
+
+				movies := account.Apply(cfg.Playlists[1])
+				xtream := movies.Sources[0].Xtream
+				if xtream.URL != "http://mirror.example.com" || xtream.Username != "user" || xtream.Password != "pass" {
+					t.Errorf("expected movies to keep its url and inherit credentials, got %+v", xtream)
+				}
+				if cfg.Playlists[1].Sources[0].Xtream.Username != "" {
+					t.Errorf("expected playlist config to stay unmodified")
+				}
+			},
+		},
+		{
+			name: "playlist with unknown account",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+playlists:
+  - name: sports
+    account: missing`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "account without credentials and playlist without sources",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+accounts:
+  - name: provider
+    concurrency: 2
+playlists:
+  - name: sports
+    account: provider`,
+			expectError: true,
+			validate:    nil,
+		},
+		{
+			name: "playlist with mirror accounts",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+accounts:
+  - name: first
+    concurrency: 1
+    xtream:
+      url: "http://provider.example.com"
+      username: "alice"
+      password: "one"
+  - name: second
+    concurrency: 1
+    xtream:
+      url: "http://provider.example.com"
+      username: "bob"
+      password: "two"
+playlists:
+  - name: provider
+    account: first
+    balance: least_used
+    mirrors:
+      - account: second`,
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				pl := cfg.Playlists[0]
+				if pl.Balance != BalanceLeastUsed || len(pl.Mirrors) != 1 || pl.Mirrors[0].Account != "second" {
+					t.Errorf("unexpected mirrors: %s %+v", pl.Balance, pl.Mirrors)
+				}
+			},
+		},
+		{
+			name: "mirror without credentials needs rewrite",
+			configContent: `server:
+  listen_addr: ":8080"
+  public_url: "http://example.com"
+url_generator:
+  secret: "test-secret"
+accounts:
+  - name: first
+  - name: second
+playlists:
+  - name: provider
+    account: first
+    sources: "http://provider.example.com/first.m3u"
+    mirrors:
+      - account: second`,
+			expectError: true,
+			validate:    nil,
+		},
 		{
 			name: "directory with multiple files",
 			configContent: `server:
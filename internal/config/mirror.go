@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceLeastUsed  = "least_used"
+)
+
+type Mirror struct {
+	Account string             `yaml:"account"`
+	Rewrite []common.NameValue `yaml:"rewrite,omitempty"`
+}
+
+func (m *Mirror) Validate(primary Account, accounts map[string]Account) error {
+	if m.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	account, ok := accounts[m.Account]
+	if !ok {
+		return fmt.Errorf("unknown account: %s", m.Account)
+	}
+	if account.Name == primary.Name {
+		return fmt.Errorf("account %s is the playlist account", m.Account)
+	}
+
+	for i, rewrite := range m.Rewrite {
+		if rewrite.Name == "" {
+			return fmt.Errorf("rewrite[%d]: name is required", i)
+		}
+	}
+	if len(m.Rewrite) == 0 && (!hasXtreamCredentials(primary) || !hasXtreamCredentials(account)) {
+		return fmt.Errorf("rewrite is required unless both accounts have xtream credentials")
+	}
+	return nil
+}
+
+func hasXtreamCredentials(a Account) bool {
+	return a.Xtream != nil && a.Xtream.URL != "" && a.Xtream.Username != "" && a.Xtream.Password != ""
+}
@@ -6,25 +6,72 @@ import (
 	"majmun/internal/config/proxy"
 )
 
+const (
+	OnSourceErrorFail     = "fail"
+	OnSourceErrorSkip     = "skip"
+	OnSourceErrorLastGood = "last_good"
+)
+
 type Playlist struct {
-	Name    string             `yaml:"name"`
-	Sources common.StringOrArr `yaml:"sources"`
-	Proxy   proxy.Proxy        `yaml:"proxy,omitempty"`
+	Name          string         `yaml:"name"`
+	Account       string         `yaml:"account,omitempty"`
+	Mirrors       []Mirror       `yaml:"mirrors,omitempty"`
+	Balance       string         `yaml:"balance,omitempty"`
+	Sources       common.Sources `yaml:"sources"`
+	OnSourceError string         `yaml:"on_source_error,omitempty"`
+	Proxy         proxy.Proxy    `yaml:"proxy,omitempty"`
 }
 
-func (p *Playlist) Validate() error {
+func (p *Playlist) Validate(accounts map[string]Account) error {
 	if p.Name == "" {
 		return fmt.Errorf("name is required")
 	}
-	if len(p.Sources) == 0 {
+
+	sources := p.Sources
+	if p.Account != "" {
+		account, ok := accounts[p.Account]
+		if !ok {
+			return fmt.Errorf("unknown account: %s", p.Account)
+		}
+		sources = account.Apply(*p).Sources
+	}
+
+	if len(sources) == 0 {
 		return fmt.Errorf("sources is required")
 	}
-	for i, source := range p.Sources {
-		if source == "" {
-			return fmt.Errorf("sources[%d] cannot be empty", i)
+	for i, source := range sources {
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("sources[%d]: %w", i, err)
 		}
 	}
 
+	if len(p.Mirrors) > 0 && p.Account == "" {
+		return fmt.Errorf("mirrors require an account")
+	}
+	seen := make(map[string]bool)
+	for i, mirror := range p.Mirrors {
+		if err := mirror.Validate(accounts[p.Account], accounts); err != nil {
+			return fmt.Errorf("mirrors[%d]: %w", i, err)
+		}
+		if seen[mirror.Account] {
+			return fmt.Errorf("mirrors[%d]: duplicate account: %s", i, mirror.Account)
+		}
+		seen[mirror.Account] = true
+	}
+
+	switch p.Balance {
+	case "", BalanceRoundRobin, BalanceLeastUsed:
+	default:
+		return fmt.Errorf("balance must be one of %s, %s", BalanceRoundRobin, BalanceLeastUsed)
+	}
+
+	switch p.OnSourceError {
+	case "", OnSourceErrorFail, OnSourceErrorSkip, OnSourceErrorLastGood:
+	default:
+		return fmt.Errorf("on_source_error must be one of %s, %s, %s",
+			OnSourceErrorFail, OnSourceErrorSkip, OnSourceErrorLastGood)
+	}
+
 	if err := p.Proxy.ValidateOverride(); err != nil {
 		return fmt.Errorf("proxy: %w", err)
 	}
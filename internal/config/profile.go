@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+	"majmun/internal/config/proxy"
+	"reflect"
+)
+
+type Profile struct {
+	Name      string          `yaml:"name"`
+	Segmenter proxy.Segmenter `yaml:"segmenter,omitempty"`
+}
+
+func (p *Profile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(p.Segmenter.Command) == 0 && !reflect.DeepEqual(p.Segmenter, proxy.Segmenter{}) {
+		return fmt.Errorf("segmenter: command is required when other segmenter fields are set")
+	}
+	if err := p.Segmenter.Validate(); err != nil {
+		return fmt.Errorf("segmenter: %w", err)
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ type Error struct {
 	UpstreamError     Handler `yaml:"upstream_error"`
 	RateLimitExceeded Handler `yaml:"rate_limit_exceeded"`
 	LinkExpired       Handler `yaml:"link_expired"`
+	Queued            Handler `yaml:"queued"`
+	Preempted         Handler `yaml:"preempted"`
 }
 
 func (e *Error) Validate() error {
@@ -22,5 +24,11 @@ func (e *Error) Validate() error {
 	if err := e.LinkExpired.Validate(); err != nil {
 		return fmt.Errorf("link_expired: %w", err)
 	}
+	if err := e.Queued.Validate(); err != nil {
+		return fmt.Errorf("queued: %w", err)
+	}
+	if err := e.Preempted.Validate(); err != nil {
+		return fmt.Errorf("preempted: %w", err)
+	}
 	return nil
 }
@@ -8,18 +8,31 @@ import (
 )
 
 type Proxy struct {
-	Enabled           *bool             `yaml:"enabled"`
-	ConcurrentStreams int64             `yaml:"concurrency"`
-	HTTPClient        common.HTTPClient `yaml:"http_client,omitempty"`
-	Stream            Handler           `yaml:"stream,omitempty"`
-	Segmenter         Segmenter         `yaml:"segmenter,omitempty"`
-	Error             Error             `yaml:"error,omitempty"`
+	Enabled             *bool             `yaml:"enabled"`
+	ConcurrentStreams   int64             `yaml:"concurrency"`
+	UpstreamConcurrency int64             `yaml:"upstream_concurrency,omitempty"`
+	ViewerConcurrency   int64             `yaml:"viewer_concurrency,omitempty"`
+	Queue               Queue             `yaml:"queue,omitempty"`
+	HLS                 *bool             `yaml:"hls"`
+	HTTPClient          common.HTTPClient `yaml:"http_client,omitempty"`
+	Stream              Handler           `yaml:"stream,omitempty"`
+	Segmenter           Segmenter         `yaml:"segmenter,omitempty"`
+	Error               Error             `yaml:"error,omitempty"`
 }
 
 func (p *Proxy) ValidateGlobal() error {
 	if p.ConcurrentStreams < 0 {
 		return fmt.Errorf("concurrency cannot be negative")
 	}
+	if p.UpstreamConcurrency < 0 {
+		return fmt.Errorf("upstream_concurrency cannot be negative")
+	}
+	if p.ViewerConcurrency < 0 {
+		return fmt.Errorf("viewer_concurrency cannot be negative")
+	}
+	if err := p.Queue.Validate(); err != nil {
+		return fmt.Errorf("queue: %w", err)
+	}
 	if err := p.Stream.Validate(); err != nil {
 		return fmt.Errorf("stream: %w", err)
 	}
@@ -39,6 +52,15 @@ func (p *Proxy) ValidateOverride() error {
 	if p.ConcurrentStreams < 0 {
 		return fmt.Errorf("concurrency cannot be negative")
 	}
+	if p.UpstreamConcurrency < 0 {
+		return fmt.Errorf("upstream_concurrency cannot be negative")
+	}
+	if p.ViewerConcurrency < 0 {
+		return fmt.Errorf("viewer_concurrency cannot be negative")
+	}
+	if err := p.Queue.Validate(); err != nil {
+		return fmt.Errorf("queue: %w", err)
+	}
 	if err := p.Stream.Validate(); err != nil {
 		return fmt.Errorf("stream: %w", err)
 	}
@@ -74,6 +96,8 @@ func (p *Proxy) UnmarshalYAML(value *yaml.Node) error {
 	mergeHandlerVars(&prev.Error.UpstreamError, &p.Error.UpstreamError)
 	mergeHandlerVars(&prev.Error.RateLimitExceeded, &p.Error.RateLimitExceeded)
 	mergeHandlerVars(&prev.Error.LinkExpired, &p.Error.LinkExpired)
+	mergeHandlerVars(&prev.Error.Queued, &p.Error.Queued)
+	mergeHandlerVars(&prev.Error.Preempted, &p.Error.Preempted)
 
 	return nil
 }
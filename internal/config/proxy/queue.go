@@ -0,0 +1,18 @@
+package proxy
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+type Queue struct {
+	Timeout     *common.Duration `yaml:"timeout,omitempty"`
+	Placeholder *bool            `yaml:"placeholder,omitempty"`
+}
+
+func (q *Queue) Validate() error {
+	if q.Timeout != nil && *q.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 var reservedSegmenterVars = []string{
 	"segment_path",
 	"playlist_path",
+	"restarted",
 }
 
 type Segmenter struct {
@@ -16,8 +17,12 @@ type Segmenter struct {
 	TemplateVars []common.NameValue `yaml:"template_variables,omitempty"`
 	EnvVars      []common.NameValue `yaml:"env_variables,omitempty"`
 
-	InitSegments *int             `yaml:"init_segments,omitempty"`
-	ReadyTimeout *common.Duration `yaml:"ready_timeout,omitempty"`
+	InitSegments   *int             `yaml:"init_segments,omitempty"`
+	ReadyTimeout   *common.Duration `yaml:"ready_timeout,omitempty"`
+	HLSIdleTimeout *common.Duration `yaml:"hls_idle_timeout,omitempty"`
+	Linger         *common.Duration `yaml:"linger,omitempty"`
+	StallSegments  *int             `yaml:"stall_segments,omitempty"`
+	Timeshift      *common.Duration `yaml:"timeshift,omitempty"`
 }
 
 func mergeSegmenterVars(base, override *Segmenter) {
@@ -42,5 +47,17 @@ func (s *Segmenter) Validate() error {
 	if s.InitSegments != nil && *s.InitSegments < 1 {
 		return fmt.Errorf("init_segments must be at least 1")
 	}
+	if s.HLSIdleTimeout != nil && *s.HLSIdleTimeout <= 0 {
+		return fmt.Errorf("hls_idle_timeout must be positive")
+	}
+	if s.Linger != nil && *s.Linger < 0 {
+		return fmt.Errorf("linger cannot be negative")
+	}
+	if s.StallSegments != nil && *s.StallSegments < 0 {
+		return fmt.Errorf("stall_segments cannot be negative")
+	}
+	if s.Timeshift != nil && *s.Timeshift < 0 {
+		return fmt.Errorf("timeshift cannot be negative")
+	}
 	return nil
 }
@@ -32,6 +32,7 @@ type Rule struct {
 	RemoveField   *RemoveFieldRule   `yaml:"remove_field,omitempty"`
 	RemoveChannel *RemoveChannelRule `yaml:"remove_channel,omitempty"`
 	MarkHidden    *MarkHiddenRule    `yaml:"mark_hidden,omitempty"`
+	PinStream     *PinStreamRule     `yaml:"pin_stream,omitempty"`
 }
 
 func (r *Rule) UnmarshalYAML(value *yaml.Node) error {
@@ -53,6 +54,8 @@ func (r *Rule) UnmarshalYAML(value *yaml.Node) error {
 		rule.Validate = rule.RemoveChannel.Validate
 	case rule.MarkHidden != nil:
 		rule.Validate = rule.MarkHidden.Validate
+	case rule.PinStream != nil:
+		rule.Validate = rule.PinStream.Validate
 	default:
 		return fmt.Errorf("unrecognized rule type")
 	}
@@ -0,0 +1,20 @@
+package channel
+
+import (
+	"fmt"
+	"majmun/internal/config/common"
+)
+
+type PinStreamRule struct {
+	Condition *common.Condition `yaml:"condition,omitempty"`
+}
+
+func (m *PinStreamRule) Validate() error {
+	if m.Condition == nil {
+		return fmt.Errorf("pin_stream: condition is required")
+	}
+	if err := m.Condition.Validate(); err != nil {
+		return fmt.Errorf("pin_stream: %w", err)
+	}
+	return nil
+}
@@ -6,9 +6,13 @@ import (
 )
 
 type ServerConfig struct {
-	ListenAddr  string     `yaml:"listen_addr"`
-	MetricsAddr string     `yaml:"metrics_addr"`
-	PublicURL   common.URL `yaml:"public_url"`
+	ListenAddr     string          `yaml:"listen_addr"`
+	MetricsAddr    string          `yaml:"metrics_addr"`
+	AdminAddr      string          `yaml:"admin_addr"`
+	AdminToken     string          `yaml:"admin_token"`
+	PublicURL      common.URL      `yaml:"public_url"`
+	DataDir        string          `yaml:"data_dir"`
+	ListingRefresh common.Duration `yaml:"listing_refresh"`
 }
 
 func (s *ServerConfig) Validate() error {
@@ -18,5 +22,11 @@ func (s *ServerConfig) Validate() error {
 	if s.PublicURL.String() == "" {
 		return fmt.Errorf("public_url is required")
 	}
+	if s.AdminAddr != "" && s.AdminToken == "" {
+		return fmt.Errorf("admin_token is required when admin_addr is set")
+	}
+	if s.ListingRefresh <= 0 {
+		return fmt.Errorf("listing_refresh must be positive")
+	}
 	return nil
 }
@@ -3,17 +3,71 @@ package config
 import (
 	"fmt"
 	"majmun/internal/config/common"
+	"regexp"
 )
 
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 type URLGeneratorConfig struct {
-	Secret    string          `yaml:"secret"`
-	StreamTTL common.Duration `yaml:"stream_ttl"`
-	FileTTL   common.Duration `yaml:"file_ttl"`
+	Secret       string          `yaml:"secret"`
+	Keys         []URLKey        `yaml:"keys,omitempty"`
+	ActiveKey    string          `yaml:"active_key,omitempty"`
+	StreamTTL    common.Duration `yaml:"stream_ttl"`
+	FileTTL      common.Duration `yaml:"file_ttl"`
+	StableWindow common.Duration `yaml:"stable_window"`
+}
+
+type URLKey struct {
+	ID      string `yaml:"id"`
+	Secret  string `yaml:"secret"`
+	Retired bool   `yaml:"retired,omitempty"`
 }
 
 func (u *URLGeneratorConfig) Validate() error {
-	if u.Secret == "" {
-		return fmt.Errorf("secret is required")
+	if u.Secret == "" && len(u.Keys) == 0 {
+		return fmt.Errorf("secret or keys is required")
+	}
+	if err := u.validateKeys(); err != nil {
+		return err
+	}
+	if u.StableWindow < 0 {
+		return fmt.Errorf("stable_window cannot be negative")
+	}
+	return nil
+}
+
+func (u *URLGeneratorConfig) validateKeys() error {
+	ids := make(map[string]bool, len(u.Keys)+1)
+	if u.Secret != "" {
+		ids[""] = true
+	}
+
+	activeFound := false
+	for i, key := range u.Keys {
+		if key.ID != "" && !keyIDPattern.MatchString(key.ID) {
+			return fmt.Errorf("keys[%d]: id must contain only letters, digits, '-' and '_'", i)
+		}
+		if key.Secret == "" {
+			return fmt.Errorf("keys[%d]: secret is required", i)
+		}
+		if ids[key.ID] {
+			if key.ID == "" {
+				return fmt.Errorf("keys[%d]: only one key without id is allowed, including secret", i)
+			}
+			return fmt.Errorf("duplicate key id: %s", key.ID)
+		}
+		ids[key.ID] = true
+
+		if key.ID == u.ActiveKey {
+			if key.Retired {
+				return fmt.Errorf("active_key %q cannot be retired", u.ActiveKey)
+			}
+			activeFound = true
+		}
+	}
+
+	if !activeFound && !(u.ActiveKey == "" && u.Secret != "") {
+		return fmt.Errorf("active_key %q is not defined", u.ActiveKey)
 	}
 	return nil
 }
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := fingerprint(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := fingerprint(path)
+			if err != nil || current == last {
+				continue
+			}
+			last = current
+			onChange()
+		}
+	}
+}
+
+func fingerprint(path string) (string, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(&b, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return b.String(), nil
+}
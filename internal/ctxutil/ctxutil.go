@@ -21,6 +21,8 @@ const (
 	streamIDKey      contextKey = "stream_id"
 	channelNameKey   contextKey = "channel_name"
 	semaphoreNameKey contextKey = "semaphore_name"
+	priorityKey      contextKey = "priority"
+	onQueuedKey      contextKey = "on_queued"
 )
 
 func WithRequestID(ctx context.Context) context.Context {
@@ -33,6 +35,9 @@ func WithClient(ctx context.Context, client any) context.Context {
 	if namer, ok := client.(interface{ Name() string }); ok {
 		ctx = context.WithValue(ctx, clientNameKey, namer.Name())
 	}
+	if prioritizer, ok := client.(interface{ Priority() int }); ok {
+		ctx = context.WithValue(ctx, priorityKey, prioritizer.Priority())
+	}
 	return context.WithValue(ctx, clientKey, client)
 }
 
@@ -56,6 +61,10 @@ func WithSemaphoreName(ctx context.Context, name string) context.Context {
 	return context.WithValue(ctx, semaphoreNameKey, name)
 }
 
+func WithOnQueued(ctx context.Context, onQueued func()) context.Context {
+	return context.WithValue(ctx, onQueuedKey, onQueued)
+}
+
 func WithProvider(ctx context.Context, provider any) context.Context {
 	return context.WithValue(ctx, providerKey, provider)
 }
@@ -147,6 +156,20 @@ func SemaphoreName(ctx context.Context) string {
 	return ""
 }
 
+func Priority(ctx context.Context) int {
+	if v := ctx.Value(priorityKey); v != nil {
+		return v.(int)
+	}
+	return 0
+}
+
+func OnQueued(ctx context.Context) func() {
+	if v := ctx.Value(onQueuedKey); v != nil {
+		return v.(func())
+	}
+	return nil
+}
+
 func LogFields(ctx context.Context) []any {
 	fields := make([]any, 0, 16)
 
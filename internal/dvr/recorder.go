@@ -0,0 +1,448 @@
+package dvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"majmun/internal/config"
+	"majmun/internal/logging"
+	"majmun/internal/parser/xmltv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	minRetryBackoff     = time.Second
+	maxRetryBackoff     = time.Minute
+	maintenanceInterval = time.Minute
+)
+
+var (
+	ErrInvalidRange = errors.New("recording must end after its start and in the future")
+	ErrNotFound     = errors.New("recording not found")
+
+	errStreamEnded = errors.New("stream ended")
+	errMissed      = errors.New("recording was missed")
+	errNoData      = errors.New("no data recorded")
+)
+
+type OpenFunc func(ctx context.Context, rec Recording) (io.ReadCloser, error)
+
+type Guide struct {
+	Channels   map[string]string
+	Programmes []xmltv.Programme
+}
+
+type rule struct {
+	clientName string
+	channel    string
+	title      *regexp.Regexp
+}
+
+type Recorder struct {
+	dir       string
+	store     *store
+	open      OpenFunc
+	retention atomic.Int64
+	rules     atomic.Pointer[[]rule]
+	active    map[string]context.CancelFunc
+	activeMu  sync.Mutex
+	wake      chan struct{}
+}
+
+func New(dir string, open OpenFunc) (*Recorder, error) {
+	st, err := newStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		dir:    dir,
+		store:  st,
+		open:   open,
+		active: make(map[string]context.CancelFunc),
+		wake:   make(chan struct{}, 1),
+	}
+	r.rules.Store(&[]rule{})
+
+	for _, rec := range st.list() {
+		if rec.Status == StatusRecording {
+			rec.Status = StatusScheduled
+			if err := st.put(rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+func (r *Recorder) Configure(cfg config.DVR) error {
+	rules := make([]rule, 0, len(cfg.Recordings))
+
+	for _, rc := range cfg.Recordings {
+		if rc.Title != "" {
+			title, err := regexp.Compile(rc.Title)
+			if err != nil {
+				return fmt.Errorf("invalid title pattern: %w", err)
+			}
+			rules = append(rules, rule{clientName: rc.Client, channel: rc.Channel, title: title})
+			continue
+		}
+
+		_, err := r.Schedule(Recording{ClientName: rc.Client, Channel: rc.Channel, Start: rc.Start, End: rc.End})
+		if err != nil && !errors.Is(err, ErrInvalidRange) {
+			return err
+		}
+	}
+
+	r.retention.Store(int64(cfg.Retention))
+	r.rules.Store(&rules)
+	return nil
+}
+
+func (r *Recorder) HasRules(clientName string) bool {
+	for _, rl := range *r.rules.Load() {
+		if rl.clientName == clientName {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recorder) ApplyRules(clientName string, guide Guide) error {
+	for _, rl := range *r.rules.Load() {
+		if rl.clientName != clientName {
+			continue
+		}
+		if _, err := r.ScheduleMatching(clientName, rl.channel, rl.title, guide); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) Schedule(rec Recording) (Recording, error) {
+	if !rec.End.After(rec.Start) || !rec.End.After(time.Now()) {
+		return Recording{}, ErrInvalidRange
+	}
+
+	rec.ID = recordingID(rec.ClientName, rec.Channel, rec.Start)
+	rec.Start, rec.End = rec.Start.UTC(), rec.End.UTC()
+	rec.Status = StatusScheduled
+	rec.Size, rec.Error = 0, ""
+
+	added, err := r.store.add(rec)
+	if err != nil {
+		return Recording{}, err
+	}
+	if !added {
+		existing, _ := r.store.get(rec.ID)
+		return existing, nil
+	}
+
+	r.notify()
+	return rec, nil
+}
+
+func (r *Recorder) ScheduleMatching(
+	clientName, channel string, title *regexp.Regexp, guide Guide) ([]Recording, error) {
+	result := make([]Recording, 0)
+
+	for _, p := range guide.Programmes {
+		if p.Start == nil || p.Stop == nil {
+			continue
+		}
+
+		name, ok := guide.Channels[p.Channel]
+		if !ok || (channel != "" && name != channel) {
+			continue
+		}
+
+		matched, ok := matchTitle(p, title)
+		if !ok {
+			continue
+		}
+
+		rec, err := r.Schedule(Recording{
+			ClientName: clientName,
+			Channel:    name,
+			Title:      matched,
+			Start:      p.Start.Time,
+			End:        p.Stop.Time,
+		})
+		if errors.Is(err, ErrInvalidRange) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, rec)
+	}
+
+	return result, nil
+}
+
+func (r *Recorder) Recordings() []Recording {
+	return r.store.list()
+}
+
+func (r *Recorder) Recording(id string) (Recording, bool) {
+	return r.store.get(id)
+}
+
+func (r *Recorder) FilePath(rec Recording) string {
+	return filepath.Join(r.dir, rec.FileName())
+}
+
+func (r *Recorder) Delete(id string) (Recording, error) {
+	rec, ok := r.store.get(id)
+	if !ok {
+		return Recording{}, ErrNotFound
+	}
+
+	switch rec.Status {
+	case StatusScheduled:
+		rec.Status = StatusCancelled
+		return rec, r.store.put(rec)
+	case StatusRecording:
+		rec.Status = StatusCancelled
+		if err := r.store.put(rec); err != nil {
+			return Recording{}, err
+		}
+		r.stop(id)
+		return rec, nil
+	}
+
+	if err := os.Remove(r.FilePath(rec)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Recording{}, fmt.Errorf("failed to remove recording file: %w", err)
+	}
+	return rec, r.store.remove(id)
+}
+
+func (r *Recorder) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		next := r.tick(ctx, &wg, time.Now())
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (r *Recorder) tick(ctx context.Context, wg *sync.WaitGroup, now time.Time) time.Time {
+	next := now.Add(maintenanceInterval)
+	retention := time.Duration(r.retention.Load())
+
+	for _, rec := range r.store.list() {
+		switch rec.Status {
+		case StatusScheduled:
+			if rec.Start.After(now) {
+				if rec.Start.Before(next) {
+					next = rec.Start
+				}
+				continue
+			}
+			if !rec.End.After(now) {
+				r.finish(ctx, rec, errMissed)
+				continue
+			}
+			r.start(ctx, wg, rec)
+
+		case StatusCancelled:
+			if !rec.End.After(now) {
+				r.prune(ctx, rec)
+			}
+
+		case StatusCompleted, StatusFailed:
+			if retention > 0 && rec.End.Add(retention).Before(now) {
+				r.prune(ctx, rec)
+			}
+		}
+	}
+
+	return next
+}
+
+func (r *Recorder) start(ctx context.Context, wg *sync.WaitGroup, rec Recording) {
+	recCtx, cancel := context.WithDeadline(ctx, rec.End)
+
+	r.activeMu.Lock()
+	r.active[rec.ID] = cancel
+	r.activeMu.Unlock()
+
+	rec.Status = StatusRecording
+	rec.Error = ""
+	if err := r.store.put(rec); err != nil {
+		logging.Error(ctx, err, "failed to update recording", "id", rec.ID)
+	}
+
+	logging.Info(ctx, "recording started", "id", rec.ID, "client", rec.ClientName, "channel", rec.Channel)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			r.activeMu.Lock()
+			delete(r.active, rec.ID)
+			r.activeMu.Unlock()
+			cancel()
+		}()
+		r.record(ctx, recCtx, rec)
+	}()
+}
+
+func (r *Recorder) record(ctx, recCtx context.Context, rec Recording) {
+	f, err := os.OpenFile(r.FilePath(rec), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		r.finish(ctx, rec, fmt.Errorf("failed to create recording file: %w", err))
+		return
+	}
+
+	copyErr := r.copyStream(recCtx, rec, f)
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	if ctx.Err() != nil && !r.cancelled(rec.ID) {
+		rec.Size = fileSize(r.FilePath(rec))
+		if err := r.store.put(rec); err != nil {
+			logging.Error(ctx, err, "failed to update recording", "id", rec.ID)
+		}
+		return
+	}
+
+	r.finish(ctx, rec, copyErr)
+}
+
+func (r *Recorder) copyStream(ctx context.Context, rec Recording, w io.Writer) error {
+	var lastErr error
+	backoff := minRetryBackoff
+
+	for ctx.Err() == nil {
+		reader, err := r.open(ctx, rec)
+		if err == nil {
+			var written int64
+			written, err = io.Copy(w, reader)
+			_ = reader.Close()
+
+			if written > 0 {
+				backoff = minRetryBackoff
+			}
+			if err == nil {
+				err = errStreamEnded
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		lastErr = err
+		logging.Error(ctx, err, "recording interrupted, retrying", "id", rec.ID, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+
+	return lastErr
+}
+
+func (r *Recorder) finish(ctx context.Context, rec Recording, err error) {
+	if r.cancelled(rec.ID) {
+		if err := os.Remove(r.FilePath(rec)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logging.Error(ctx, err, "failed to remove recording file", "id", rec.ID)
+		}
+		logging.Info(ctx, "recording cancelled", "id", rec.ID)
+		return
+	}
+
+	rec.Size = fileSize(r.FilePath(rec))
+	rec.Status = StatusCompleted
+	rec.Error = ""
+
+	if rec.Size == 0 {
+		if err == nil {
+			err = errNoData
+		}
+		rec.Status = StatusFailed
+		rec.Error = err.Error()
+		_ = os.Remove(r.FilePath(rec))
+	}
+
+	if err := r.store.put(rec); err != nil {
+		logging.Error(ctx, err, "failed to update recording", "id", rec.ID)
+	}
+	logging.Info(ctx, "recording finished", "id", rec.ID, "status", rec.Status, "size", rec.Size)
+}
+
+func (r *Recorder) prune(ctx context.Context, rec Recording) {
+	if err := os.Remove(r.FilePath(rec)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Error(ctx, err, "failed to remove recording file", "id", rec.ID)
+		return
+	}
+	if err := r.store.remove(rec.ID); err != nil {
+		logging.Error(ctx, err, "failed to remove recording", "id", rec.ID)
+		return
+	}
+	logging.Debug(ctx, "recording removed", "id", rec.ID, "status", rec.Status)
+}
+
+// cancelled reports whether the recording was deleted while it was running.
+func (r *Recorder) cancelled(id string) bool {
+	rec, ok := r.store.get(id)
+	return ok && rec.Status == StatusCancelled
+}
+
+func (r *Recorder) stop(id string) {
+	r.activeMu.Lock()
+	defer r.activeMu.Unlock()
+
+	if cancel, ok := r.active[id]; ok {
+		cancel()
+	}
+}
+
+func (r *Recorder) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func matchTitle(p xmltv.Programme, title *regexp.Regexp) (string, bool) {
+	for _, t := range p.Titles {
+		if title.MatchString(t.Value) {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+func fileSize(path string) int64 {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
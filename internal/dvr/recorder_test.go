@@ -0,0 +1,200 @@
+package dvr
+
+import (
+	"context"
+	"io"
+	"majmun/internal/parser/xmltv"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRecorder(t *testing.T, open OpenFunc) *Recorder {
+	r, err := New(t.TempDir(), open)
+	require.NoError(t, err)
+	return r
+}
+
+func TestRecorderSchedule(t *testing.T) {
+	r := newTestRecorder(t, nil)
+	now := time.Now()
+
+	rec, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now, End: now.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, StatusScheduled, rec.Status)
+	assert.NotEmpty(t, rec.ID)
+
+	again, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now, End: now.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, rec.ID, again.ID)
+	assert.Len(t, r.Recordings(), 1)
+
+	_, err = r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)})
+	assert.ErrorIs(t, err, ErrInvalidRange)
+
+	reopened, err := New(r.Dir(), nil)
+	require.NoError(t, err)
+	stored, ok := reopened.Recording(rec.ID)
+	require.True(t, ok)
+	assert.Equal(t, "News", stored.Channel)
+}
+
+func TestRecorderScheduleMatching(t *testing.T) {
+	r := newTestRecorder(t, nil)
+	now := time.Now().Truncate(time.Second)
+
+	programme := func(channel, title string, start time.Time) xmltv.Programme {
+		return xmltv.Programme{
+			Channel: channel,
+			Titles:  []xmltv.CommonElement{{Value: title}},
+			Start:   &xmltv.Time{Time: start},
+			Stop:    &xmltv.Time{Time: start.Add(30 * time.Minute)},
+		}
+	}
+
+	guide := Guide{
+		Channels: map[string]string{"news.id": "News", "sports.id": "Sports"},
+		Programmes: []xmltv.Programme{
+			programme("news.id", "Evening News", now.Add(time.Hour)),
+			programme("news.id", "Weather", now.Add(2*time.Hour)),
+			programme("sports.id", "Evening News Recap", now.Add(3*time.Hour)),
+			programme("news.id", "Evening News", now.Add(-time.Hour)),
+			programme("unknown.id", "Evening News", now.Add(time.Hour)),
+		},
+	}
+
+	recs, err := r.ScheduleMatching("alice", "News", regexp.MustCompile("^Evening News"), guide)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	assert.Equal(t, "News", recs[0].Channel)
+	assert.Equal(t, "Evening News", recs[0].Title)
+	assert.True(t, recs[0].Start.Equal(now.Add(time.Hour)))
+
+	recs, err = r.ScheduleMatching("alice", "", regexp.MustCompile("Evening News"), guide)
+	require.NoError(t, err)
+	assert.Len(t, recs, 2)
+	assert.Len(t, r.Recordings(), 2)
+}
+
+func TestRecorderRecords(t *testing.T) {
+	r := newTestRecorder(t, func(ctx context.Context, rec Recording) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("segment")), nil
+	})
+
+	now := time.Now()
+	rec, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now, End: now.Add(300 * time.Millisecond)})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	r.tick(context.Background(), &wg, time.Now())
+	wg.Wait()
+
+	done, ok := r.Recording(rec.ID)
+	require.True(t, ok)
+	assert.Equal(t, StatusCompleted, done.Status)
+	assert.Equal(t, int64(len("segment")), done.Size)
+
+	data, err := os.ReadFile(r.FilePath(done))
+	require.NoError(t, err)
+	assert.Equal(t, "segment", string(data))
+}
+
+func TestRecorderRetention(t *testing.T) {
+	r := newTestRecorder(t, nil)
+	now := time.Now()
+
+	rec, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now, End: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	rec.Status = StatusCompleted
+	require.NoError(t, r.store.put(rec))
+	require.NoError(t, os.WriteFile(r.FilePath(rec), []byte("data"), 0644))
+
+	r.retention.Store(int64(24 * time.Hour))
+
+	var wg sync.WaitGroup
+	r.tick(context.Background(), &wg, now.Add(2*time.Hour))
+	_, ok := r.Recording(rec.ID)
+	assert.True(t, ok)
+
+	r.tick(context.Background(), &wg, now.Add(26*time.Hour))
+	_, ok = r.Recording(rec.ID)
+	assert.False(t, ok)
+	_, err = os.Stat(r.FilePath(rec))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRecorderDelete(t *testing.T) {
+	r := newTestRecorder(t, nil)
+	now := time.Now()
+
+	rec, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)})
+	require.NoError(t, err)
+
+	cancelled, err := r.Delete(rec.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, cancelled.Status)
+
+	again, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, again.Status)
+
+	_, err = r.Delete(rec.ID)
+	require.NoError(t, err)
+	_, ok := r.Recording(rec.ID)
+	assert.False(t, ok)
+
+	_, err = r.Delete(rec.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRecorderDeleteWhileRecording(t *testing.T) {
+	opened := make(chan struct{})
+	r := newTestRecorder(t, func(ctx context.Context, rec Recording) (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			_, _ = pw.Write([]byte("segment"))
+			select {
+			case opened <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+			_ = pw.CloseWithError(ctx.Err())
+		}()
+		return pr, nil
+	})
+
+	now := time.Now()
+	rec, err := r.Schedule(Recording{ClientName: "alice", Channel: "News", Start: now, End: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	r.tick(context.Background(), &wg, time.Now())
+
+	select {
+	case <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording did not start")
+	}
+
+	cancelled, err := r.Delete(rec.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, cancelled.Status)
+	wg.Wait()
+
+	stored, ok := r.Recording(rec.ID)
+	require.True(t, ok)
+	assert.Equal(t, StatusCancelled, stored.Status)
+	_, err = os.Stat(r.FilePath(rec))
+	assert.True(t, os.IsNotExist(err), "expected partial recording file to be removed")
+
+	for _, listed := range r.Recordings() {
+		assert.NotEqual(t, StatusCompleted, listed.Status)
+	}
+}
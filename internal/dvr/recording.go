@@ -0,0 +1,43 @@
+package dvr
+
+import (
+	"majmun/internal/listing"
+	"strconv"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusScheduled Status = "scheduled"
+	StatusRecording Status = "recording"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+const fileExtension = ".ts"
+
+type Recording struct {
+	ID         string    `json:"id"`
+	ClientName string    `json:"client_name"`
+	Channel    string    `json:"channel"`
+	Title      string    `json:"title,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Status     Status    `json:"status"`
+	Size       int64     `json:"size,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (r Recording) FileName() string {
+	return r.ID + fileExtension
+}
+
+func (r Recording) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+func recordingID(clientName, channel string, start time.Time) string {
+	return listing.GenerateHashID(clientName, "\n", channel, "\n", strconv.FormatInt(start.Unix(), 10))
+}
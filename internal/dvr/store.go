@@ -0,0 +1,143 @@
+package dvr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const storeFile = "recordings.json"
+
+type store struct {
+	path       string
+	recordings map[string]Recording
+	mu         sync.Mutex
+}
+
+func newStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %w", err)
+	}
+
+	s := &store{
+		path:       filepath.Join(dir, storeFile),
+		recordings: make(map[string]Recording),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recordings: %w", err)
+	}
+
+	var recordings []Recording
+	if err := json.Unmarshal(data, &recordings); err != nil {
+		return nil, fmt.Errorf("failed to parse recordings: %w", err)
+	}
+	for _, rec := range recordings {
+		s.recordings[rec.ID] = rec
+	}
+
+	return s, nil
+}
+
+func (s *store) list() []Recording {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+func (s *store) get(id string) (Recording, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.recordings[id]
+	return rec, ok
+}
+
+func (s *store) add(rec Recording) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.recordings[rec.ID]; exists {
+		return false, nil
+	}
+
+	s.recordings[rec.ID] = rec
+	if err := s.save(); err != nil {
+		delete(s.recordings, rec.ID)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *store) put(rec Recording) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.recordings[rec.ID]
+	s.recordings[rec.ID] = rec
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.recordings[rec.ID] = prev
+		} else {
+			delete(s.recordings, rec.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *store) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.recordings[id]
+	if !existed {
+		return nil
+	}
+	delete(s.recordings, id)
+
+	if err := s.save(); err != nil {
+		s.recordings[id] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *store) sorted() []Recording {
+	result := make([]Recording, 0, len(s.recordings))
+	for _, rec := range s.recordings {
+		result = append(result, rec)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start.Equal(result[j].Start) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result
+}
+
+func (s *store) save() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode recordings: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write recordings: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write recordings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"majmun/internal/listing"
+	"majmun/internal/listing/m3u8"
+	"majmun/internal/listing/m3u8/rules/channel"
+	"majmun/internal/listing/m3u8/rules/playlist"
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/listing/xmltv"
+	"majmun/internal/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Client interface {
+	Name() string
+	EPGLink() string
+	PlaylistProviders() []listing.Playlist
+	EPGProviders() []listing.EPG
+	ChannelProcessor() *channel.Processor
+	PlaylistProcessor() *playlist.Processor
+}
+
+type Content struct {
+	Data    []byte
+	ETag    string
+	ModTime time.Time
+
+	sum [sha256.Size]byte
+}
+
+type Listing struct {
+	Channels  []*store.Channel
+	Playlist  *Content
+	EPG       *Content
+	EPGGzip   *Content
+	UpdatedAt time.Time
+}
+
+type Cache struct {
+	client  Client
+	shared  *ContentPool
+	current atomic.Pointer[Listing]
+	closed  bool
+	mu      sync.Mutex
+}
+
+// New creates a listing cache for client. Rendered EPGs are shared through
+// pool with other caches, pool may be nil.
+func New(client Client, pool *ContentPool) *Cache {
+	return &Cache{client: client, shared: pool}
+}
+
+func (c *Cache) Client() Client {
+	return c.client
+}
+
+func (c *Cache) Get(ctx context.Context) (*Listing, error) {
+	if l := c.current.Load(); l != nil {
+		return l, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l := c.current.Load(); l != nil {
+		return l, nil
+	}
+	return c.refresh(ctx)
+}
+
+func (c *Cache) Refresh(ctx context.Context) (*Listing, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refresh(ctx)
+}
+
+// Close gives the shared EPG content of the cache back to the pool. The cache
+// still serves requests afterwards, but no longer shares what it builds.
+func (c *Cache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if l := c.current.Load(); l != nil {
+		c.shared.release(l.EPG, l.EPGGzip)
+	}
+	c.shared = nil
+}
+
+func (c *Cache) refresh(ctx context.Context) (*Listing, error) {
+	prev := c.current.Load()
+	l, err := build(ctx, c.client, prev, c.shared)
+	if err != nil {
+		return nil, err
+	}
+	c.current.Store(l)
+	if prev != nil {
+		c.shared.release(prev.EPG, prev.EPGGzip)
+	}
+	return l, nil
+}
+
+func build(ctx context.Context, client Client, prev *Listing, shared *ContentPool) (*Listing, error) {
+	streamer := m3u8.NewStreamer(
+		client.PlaylistProviders(),
+		client.EPGLink(),
+		client.ChannelProcessor(),
+		client.PlaylistProcessor(),
+	)
+
+	channels, err := streamer.Channels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("build channels: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m3u8.NewWriter(client.EPGLink()).WriteChannels(channels, &buf); err != nil {
+		return nil, fmt.Errorf("render playlist: %w", err)
+	}
+
+	l := &Listing{
+		Channels:  channels,
+		UpdatedAt: time.Now(),
+	}
+
+	var prevPlaylist, prevEPG, prevEPGGzip *Content
+	if prev != nil {
+		prevPlaylist, prevEPG, prevEPGGzip = prev.Playlist, prev.EPG, prev.EPGGzip
+	}
+
+	l.Playlist = newContent(buf.Bytes(), prevPlaylist)
+
+	epg, epgGzip, err := renderEPG(ctx, client, channels)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		logging.Error(ctx, err, "failed to build epg")
+		l.EPG, l.EPGGzip = shared.retain(prevEPG), shared.retain(prevEPGGzip)
+	} else {
+		l.EPG = shared.content(epg, prevEPG)
+		l.EPGGzip = shared.content(epgGzip, prevEPGGzip)
+	}
+
+	return l, nil
+}
+
+func renderEPG(ctx context.Context, client Client, channels []*store.Channel) ([]byte, []byte, error) {
+	var buf bytes.Buffer
+	streamer := xmltv.NewStreamer(client.EPGProviders(), m3u8.ChannelIDs(channels))
+	if _, err := streamer.WriteTo(ctx, &buf); err != nil {
+		return nil, nil, err
+	}
+
+	var gzBuf bytes.Buffer
+	gzWriter, _ := gzip.NewWriterLevel(&gzBuf, gzip.BestSpeed)
+	if _, err := gzWriter.Write(buf.Bytes()); err != nil {
+		return nil, nil, err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), gzBuf.Bytes(), nil
+}
+
+func newContent(data []byte, prev *Content) *Content {
+	sum := sha256.Sum256(data)
+	c := &Content{
+		Data:    data,
+		ETag:    `"` + hex.EncodeToString(sum[:8]) + `"`,
+		ModTime: time.Now(),
+		sum:     sum,
+	}
+	if prev != nil && prev.ETag == c.ETag {
+		c.ModTime = prev.ModTime
+	}
+	return c
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"majmun/internal/app"
+	"majmun/internal/config"
+	"majmun/internal/config/common"
+	"majmun/internal/config/proxy"
+	"majmun/internal/listing"
+	"majmun/internal/listing/m3u8/rules/channel"
+	"majmun/internal/listing/m3u8/rules/playlist"
+	"majmun/internal/urlgen"
+	"majmun/internal/utils"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const playlistURL = "http://example.com/playlist.m3u"
+
+type mockHTTPClient struct {
+	mock.Mock
+}
+
+func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	args := m.Called(req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func (m *mockHTTPClient) respond(body string) {
+	m.On("Do", mock.Anything).
+		Return(&http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader([]byte(body)))}, nil).Once()
+}
+
+type testClient struct {
+	playlists []listing.Playlist
+}
+
+func (c *testClient) Name() string                          { return "test" }
+func (c *testClient) EPGLink() string                       { return "http://localhost/epg.xml.gz" }
+func (c *testClient) PlaylistProviders() []listing.Playlist { return c.playlists }
+func (c *testClient) EPGProviders() []listing.EPG           { return nil }
+func (c *testClient) ChannelProcessor() *channel.Processor {
+	return channel.NewRulesProcessor("test", nil)
+}
+func (c *testClient) PlaylistProcessor() *playlist.Processor {
+	return playlist.NewRulesProcessor("test", nil)
+}
+
+func newTestClient(t *testing.T, httpClient listing.HTTPClient) *testClient {
+	t.Helper()
+
+	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour, 0)
+	require.NoError(t, err)
+
+	pl, err := app.NewPlaylistProvider(
+		"test-playlist",
+		generator,
+		[]common.Source{{URL: playlistURL}},
+		config.OnSourceErrorFail,
+		nil,
+		proxy.Proxy{},
+		nil,
+		utils.NewSemaphore(1),
+		httpClient,
+	)
+	require.NoError(t, err)
+
+	return &testClient{playlists: []listing.Playlist{pl}}
+}
+
+func testM3U(names ...string) string {
+	result := "#EXTM3U\n"
+	for _, name := range names {
+		result += fmt.Sprintf("#EXTINF:-1 tvg-id=%q, %s\nhttp://example.com/%s\n", name, name, name)
+	}
+	return result
+}
+
+func TestCache_GetBuildsOnce(t *testing.T) {
+	httpClient := new(mockHTTPClient)
+	httpClient.respond(testM3U("one", "two"))
+
+	c := New(newTestClient(t, httpClient), nil)
+
+	first, err := c.Get(context.Background())
+	require.NoError(t, err)
+
+	second, err := c.Get(context.Background())
+	require.NoError(t, err)
+
+	assert.Same(t, first, second)
+	assert.Len(t, first.Channels, 2)
+	assert.Contains(t, string(first.Playlist.Data), "http://example.com/two")
+	assert.NotEmpty(t, first.Playlist.ETag)
+	assert.Nil(t, first.EPG)
+
+	httpClient.AssertExpectations(t)
+}
+
+func TestCache_RefreshKeepsModTimeWhenUnchanged(t *testing.T) {
+	httpClient := new(mockHTTPClient)
+	httpClient.respond(testM3U("one"))
+	httpClient.respond(testM3U("one"))
+	httpClient.respond(testM3U("one", "two"))
+
+	c := New(newTestClient(t, httpClient), nil)
+
+	first, err := c.Get(context.Background())
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	unchanged, err := c.Refresh(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, first.Playlist.ETag, unchanged.Playlist.ETag)
+	assert.Equal(t, first.Playlist.ModTime, unchanged.Playlist.ModTime)
+
+	changed, err := c.Refresh(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Playlist.ETag, changed.Playlist.ETag)
+	assert.True(t, changed.Playlist.ModTime.After(first.Playlist.ModTime))
+
+	httpClient.AssertExpectations(t)
+}
+
+func TestCache_RefreshErrorKeepsPrevious(t *testing.T) {
+	httpClient := new(mockHTTPClient)
+	httpClient.respond(testM3U("one"))
+	httpClient.On("Do", mock.Anything).Return(nil, fmt.Errorf("connection failed")).Once()
+
+	c := New(newTestClient(t, httpClient), nil)
+
+	first, err := c.Get(context.Background())
+	require.NoError(t, err)
+
+	_, err = c.Refresh(context.Background())
+	require.Error(t, err)
+
+	current, err := c.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, first, current)
+
+	httpClient.AssertExpectations(t)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// ContentPool keeps a single copy of identical rendered content, so clients
+// with the same EPG sources and channels do not each hold the full guide.
+type ContentPool struct {
+	entries map[[sha256.Size]byte]*pooledContent
+	mu      sync.Mutex
+}
+
+type pooledContent struct {
+	content *Content
+	refs    int
+}
+
+func NewContentPool() *ContentPool {
+	return &ContentPool{entries: make(map[[sha256.Size]byte]*pooledContent)}
+}
+
+// content returns the pooled copy of data, or adds data to the pool. Every
+// returned content is released once the listing holding it is replaced.
+func (p *ContentPool) content(data []byte, prev *Content) *Content {
+	c := newContent(data, prev)
+	if p == nil {
+		return c
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries[c.sum]; ok {
+		e.refs++
+		return e.content
+	}
+
+	p.entries[c.sum] = &pooledContent{content: c, refs: 1}
+	return c
+}
+
+func (p *ContentPool) retain(c *Content) *Content {
+	if p == nil || c == nil {
+		return c
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries[c.sum]; ok && e.content == c {
+		e.refs++
+	}
+	return c
+}
+
+func (p *ContentPool) release(contents ...*Content) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range contents {
+		if c == nil {
+			continue
+		}
+		e, ok := p.entries[c.sum]
+		if !ok || e.content != c {
+			continue
+		}
+		if e.refs--; e.refs == 0 {
+			delete(p.entries, c.sum)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentPool_SharesIdenticalContent(t *testing.T) {
+	p := NewContentPool()
+
+	first := p.content([]byte("<tv></tv>"), nil)
+	second := p.content([]byte("<tv></tv>"), nil)
+	other := p.content([]byte("<tv><channel/></tv>"), nil)
+
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, other)
+	assert.Len(t, p.entries, 2)
+
+	p.release(first)
+	assert.Len(t, p.entries, 2, "expected content to stay pooled while referenced")
+
+	assert.Same(t, second, p.retain(second))
+	p.release(second, other)
+	assert.Len(t, p.entries, 1)
+
+	p.release(second)
+	assert.Empty(t, p.entries)
+}
+
+func TestContentPool_Nil(t *testing.T) {
+	var p *ContentPool
+
+	c := p.content([]byte("<tv></tv>"), nil)
+	assert.NotEmpty(t, c.ETag)
+	assert.Same(t, c, p.retain(c))
+	p.release(c)
+}
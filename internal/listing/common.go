@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"majmun/internal/logging"
 	"net/http"
 	"net/url"
 	"os"
@@ -29,7 +30,7 @@ func CreateReader(ctx context.Context, httpClient HTTPClient, resourceURL string
 
 		resp, err := httpClient.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, logging.RedactURLError(err)
 		}
 
 		return resp.Body, nil
@@ -0,0 +1,168 @@
+package hdhomerun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"strconv"
+	"strings"
+)
+
+const (
+	manufacturer    = "Silicondust"
+	modelNumber     = "HDTC-2US"
+	firmwareName    = "hdhomeruntc_atsc"
+	firmwareVersion = "20200101"
+	deviceType      = "urn:schemas-upnp-org:device:MediaServer:1"
+	lineupSource    = "Cable"
+)
+
+type Device struct {
+	Name       string
+	BaseURL    string
+	TunerCount int64
+}
+
+type Discover struct {
+	FriendlyName    string `json:"FriendlyName"`
+	Manufacturer    string `json:"Manufacturer"`
+	ModelNumber     string `json:"ModelNumber"`
+	FirmwareName    string `json:"FirmwareName"`
+	FirmwareVersion string `json:"FirmwareVersion"`
+	DeviceID        string `json:"DeviceID"`
+	DeviceAuth      string `json:"DeviceAuth"`
+	BaseURL         string `json:"BaseURL"`
+	LineupURL       string `json:"LineupURL"`
+	TunerCount      int64  `json:"TunerCount"`
+}
+
+type LineupStatus struct {
+	ScanInProgress int      `json:"ScanInProgress"`
+	ScanPossible   int      `json:"ScanPossible"`
+	Source         string   `json:"Source"`
+	SourceList     []string `json:"SourceList"`
+}
+
+type LineupItem struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+	URL         string `json:"URL"`
+}
+
+type deviceXML struct {
+	XMLName     xml.Name        `xml:"root"`
+	Xmlns       string          `xml:"xmlns,attr"`
+	SpecVersion specVersion     `xml:"specVersion"`
+	URLBase     string          `xml:"URLBase"`
+	Device      deviceXMLDevice `xml:"device"`
+}
+
+type specVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+
+type deviceXMLDevice struct {
+	DeviceType   string `xml:"deviceType"`
+	FriendlyName string `xml:"friendlyName"`
+	Manufacturer string `xml:"manufacturer"`
+	ModelName    string `xml:"modelName"`
+	ModelNumber  string `xml:"modelNumber"`
+	SerialNumber string `xml:"serialNumber"`
+	UDN          string `xml:"UDN"`
+}
+
+func (d Device) ID() string {
+	h := sha256.Sum256([]byte(d.Name))
+	return strings.ToUpper(hex.EncodeToString(h[:4]))
+}
+
+func (d Device) Discover() Discover {
+	return Discover{
+		FriendlyName:    d.Name,
+		Manufacturer:    manufacturer,
+		ModelNumber:     modelNumber,
+		FirmwareName:    firmwareName,
+		FirmwareVersion: firmwareVersion,
+		DeviceID:        d.ID(),
+		DeviceAuth:      d.ID(),
+		BaseURL:         d.BaseURL,
+		LineupURL:       d.BaseURL + "/lineup.json",
+		TunerCount:      d.TunerCount,
+	}
+}
+
+func (d Device) LineupStatus() LineupStatus {
+	return LineupStatus{
+		ScanInProgress: 0,
+		ScanPossible:   1,
+		Source:         lineupSource,
+		SourceList:     []string{lineupSource},
+	}
+}
+
+func (d Device) WriteDeviceXML(w io.Writer) error {
+	doc := deviceXML{
+		Xmlns:       "urn:schemas-upnp-org:device-1-0",
+		SpecVersion: specVersion{Major: 1, Minor: 0},
+		URLBase:     d.BaseURL,
+		Device: deviceXMLDevice{
+			DeviceType:   deviceType,
+			FriendlyName: d.Name,
+			Manufacturer: manufacturer,
+			ModelName:    modelNumber,
+			ModelNumber:  modelNumber,
+			SerialNumber: d.ID(),
+			UDN:          "uuid:" + d.ID(),
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("encode device xml: %w", err)
+	}
+	return nil
+}
+
+func Lineup(channels []*store.Channel) []LineupItem {
+	items := make([]LineupItem, 0, len(channels))
+	used := make(map[string]bool, len(channels))
+
+	for _, ch := range channels {
+		if ch.URI() == nil {
+			continue
+		}
+
+		number, ok := ch.GetAttr(m3u8.AttrTvgChno)
+		if !ok || number == "" || used[number] {
+			number = nextGuideNumber(used, len(items)+1)
+		}
+		used[number] = true
+
+		items = append(items, LineupItem{
+			GuideNumber: number,
+			GuideName:   ch.Name(),
+			URL:         ch.URI().String(),
+		})
+	}
+
+	return items
+}
+
+func nextGuideNumber(used map[string]bool, start int) string {
+	for n := start; ; n++ {
+		number := strconv.Itoa(n)
+		if !used[number] {
+			return number
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package hdhomerun
+
+import (
+	"bytes"
+	"encoding/xml"
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChannel(t *testing.T, name, rawURL string, attrs map[string]string) *store.Channel {
+	t.Helper()
+	track := &m3u8.Track{Name: name, Attrs: attrs}
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		track.URI = u
+	}
+	return store.NewChannel(track, nil)
+}
+
+func TestDevice_Discover(t *testing.T) {
+	d := Device{Name: "living-room", BaseURL: "http://localhost:8080/secret", TunerCount: 3}
+
+	discover := d.Discover()
+
+	assert.Equal(t, "living-room", discover.FriendlyName)
+	assert.Equal(t, "http://localhost:8080/secret", discover.BaseURL)
+	assert.Equal(t, "http://localhost:8080/secret/lineup.json", discover.LineupURL)
+	assert.Equal(t, int64(3), discover.TunerCount)
+	assert.Len(t, discover.DeviceID, 8)
+	assert.Equal(t, discover.DeviceID, Device{Name: "living-room"}.ID())
+	assert.NotEqual(t, discover.DeviceID, Device{Name: "bedroom"}.ID())
+}
+
+func TestLineup(t *testing.T) {
+	channels := []*store.Channel{
+		newChannel(t, "News", "http://proxy/token1/s.ts", map[string]string{m3u8.AttrTvgChno: "2"}),
+		newChannel(t, "Sports", "http://proxy/token2/s.ts", nil),
+		newChannel(t, "Broken", "", nil),
+		newChannel(t, "Movies", "http://proxy/token3/s.ts", map[string]string{m3u8.AttrTvgChno: "2"}),
+	}
+
+	items := Lineup(channels)
+
+	require.Len(t, items, 3)
+	assert.Equal(t, LineupItem{GuideNumber: "2", GuideName: "News", URL: "http://proxy/token1/s.ts"}, items[0])
+	assert.Equal(t, LineupItem{GuideNumber: "3", GuideName: "Sports", URL: "http://proxy/token2/s.ts"}, items[1])
+	assert.Equal(t, LineupItem{GuideNumber: "4", GuideName: "Movies", URL: "http://proxy/token3/s.ts"}, items[2])
+}
+
+func TestDevice_WriteDeviceXML(t *testing.T) {
+	d := Device{Name: "living-room", BaseURL: "http://localhost:8080/secret"}
+
+	var buf bytes.Buffer
+	require.NoError(t, d.WriteDeviceXML(&buf))
+
+	var doc deviceXML
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "http://localhost:8080/secret", doc.URLBase)
+	assert.Equal(t, "living-room", doc.Device.FriendlyName)
+	assert.Equal(t, "uuid:"+d.ID(), doc.Device.UDN)
+}
@@ -1,8 +1,10 @@
 package listing
 
 import (
+	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
 	"majmun/internal/config/rules/channel"
+	"majmun/internal/parser/m3u8"
 	"majmun/internal/urlgen"
 	"net/http"
 	"net/url"
@@ -23,17 +25,24 @@ type URLGenerator interface {
 
 type Playlist interface {
 	Name() string
-	Playlists() []string
+	Playlists() []common.Source
 	URLGenerator() *urlgen.Generator
 	HTTPClient() HTTPClient
 	Rules() []*channel.Rule
 	ProxyConfig() proxy.Proxy
 	IsProxied() bool
+	OnSourceError() string
+	Snapshots() SnapshotStore
+}
+
+type SnapshotStore interface {
+	Save(name, source string, tracks []*m3u8.Track) error
+	Load(name, source string) ([]*m3u8.Track, error)
 }
 
 type EPG interface {
 	Name() string
-	EPGs() []string
+	EPGs() []common.Source
 	URLGenerator() *urlgen.Generator
 	HTTPClient() HTTPClient
 	ProxyConfig() proxy.Proxy
@@ -4,16 +4,33 @@ import (
 	"context"
 	"io"
 
+	"majmun/internal/config/common"
 	"majmun/internal/listing"
 	"majmun/internal/parser/m3u8"
+	"majmun/internal/xtream"
 )
 
 type decoderWrapper struct {
 	*listing.BaseDecoder
 	subscription listing.Playlist
+	source       common.Source
+	sourceIndex  int
 }
 
-func newDecoderWrapper(subscription listing.Playlist, httpClient listing.HTTPClient, url string) *decoderWrapper {
+type trackDecoder struct {
+	tracks []*m3u8.Track
+}
+
+func (d *trackDecoder) Decode() (any, error) {
+	if len(d.tracks) == 0 {
+		return nil, io.EOF
+	}
+	track := d.tracks[0]
+	d.tracks = d.tracks[1:]
+	return track, nil
+}
+
+func newDecoderWrapper(subscription listing.Playlist, httpClient listing.HTTPClient, source common.Source, sourceIndex int) *decoderWrapper {
 	initFunc := func(ctx context.Context, url string) (listing.Decoder, io.ReadCloser, error) {
 		reader, err := listing.CreateReader(ctx, httpClient, url)
 		if err != nil {
@@ -23,8 +40,21 @@ func newDecoderWrapper(subscription listing.Playlist, httpClient listing.HTTPCli
 		return decoder, reader, nil
 	}
 
+	if source.Xtream != nil {
+		client := xtream.NewClient(source.Xtream.URL, source.Xtream.Username, source.Xtream.Password, httpClient)
+		initFunc = func(ctx context.Context, _ string) (listing.Decoder, io.ReadCloser, error) {
+			tracks, err := client.LiveTracks(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &trackDecoder{tracks: tracks}, nil, nil
+		}
+	}
+
 	return &decoderWrapper{
-		BaseDecoder:  listing.NewLazyBaseDecoder(url, initFunc),
+		BaseDecoder:  listing.NewLazyBaseDecoder(source.String(), initFunc),
 		subscription: subscription,
+		source:       source,
+		sourceIndex:  sourceIndex,
 	}
 }
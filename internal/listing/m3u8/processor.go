@@ -3,6 +3,7 @@ package m3u8
 import (
 	"context"
 	"fmt"
+	"majmun/internal/catchup"
 	"majmun/internal/listing/m3u8/rules/channel"
 	"majmun/internal/listing/m3u8/rules/playlist"
 	"majmun/internal/listing/m3u8/store"
@@ -62,7 +63,8 @@ func (p *Processor) Process(
 	}
 
 	for ch := range p.channelStreams {
-		if ch.Playlist().IsProxied() && len(p.channelStreams[ch]) > 0 {
+		ch.SetStreams(p.channelStreams[ch])
+		if ch.Playlist().IsProxied() {
 			p.updateChannelURI(ch)
 		}
 	}
@@ -88,13 +90,18 @@ func (p *Processor) trackChannel(ch *store.Channel) {
 }
 
 func (p *Processor) createStream(ch *store.Channel) urlgen.Stream {
+	streamURL := ch.URI().String()
+	catchupType, _ := ch.GetAttr(m3u8.AttrCatchup)
+	catchupSource, _ := ch.GetAttr(m3u8.AttrCatchupSource)
+
 	return urlgen.Stream{
 		ProviderInfo: urlgen.ProviderInfo{
 			ProviderType: urlgen.ProviderTypePlaylist,
 			ProviderName: ch.Playlist().Name(),
 		},
-		URL:    ch.URI().String(),
-		Hidden: ch.IsHidden(),
+		URL:     streamURL,
+		Hidden:  ch.IsHidden(),
+		Catchup: catchup.Template(catchupType, catchupSource, streamURL),
 	}
 }
 
@@ -129,6 +136,9 @@ func (p *Processor) proxyChannelAttributes(ch *store.Channel) error {
 	}
 
 	for key, value := range ch.Attrs() {
+		if key == m3u8.AttrCatchupSource {
+			continue
+		}
 		if isURL(value) {
 			u, err := urlGen.CreateFileURL(providerInfo, value)
 			if err != nil {
@@ -141,15 +151,35 @@ func (p *Processor) proxyChannelAttributes(ch *store.Channel) error {
 	return nil
 }
 
+// updateChannelURI points the channel at its proxied streams. The upstream
+// catchup-source may carry provider credentials, so it is dropped unless a
+// proxied one replaces it.
 func (p *Processor) updateChannelURI(ch *store.Channel) {
+	ch.DeleteAttr(m3u8.AttrCatchupSource)
+
 	streams := p.channelStreams[ch]
 	if len(streams) == 0 {
 		return
 	}
 
 	urlGen := ch.Playlist().URLGenerator()
-	if u, err := urlGen.CreateStreamURL(ch.Name(), streams); err == nil {
-		ch.SetURI(u)
+	createURL := urlGen.CreateStreamURL
+	if hls := ch.Playlist().ProxyConfig().HLS; hls != nil && *hls {
+		createURL = urlGen.CreateHLSStreamURL
+	}
+
+	u, err := createURL(ch.Name(), streams)
+	if err != nil {
+		return
+	}
+	ch.SetURI(u)
+
+	for _, stream := range streams {
+		if stream.Catchup != "" {
+			ch.SetAttr(m3u8.AttrCatchup, catchup.TypeDefault)
+			ch.SetAttr(m3u8.AttrCatchupSource, u.String()+"?"+catchup.SourceQuery)
+			return
+		}
 	}
 }
 
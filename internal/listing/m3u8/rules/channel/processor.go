@@ -47,6 +47,8 @@ func (p *Processor) processChannelRule(ch *store.Channel, rule *channel.Rule) er
 		p.processRemoveChannel(ch, rule.RemoveChannel)
 	case rule.MarkHidden != nil:
 		p.processMarkHidden(ch, rule.MarkHidden)
+	case rule.PinStream != nil:
+		p.processPinStream(ch, rule.PinStream)
 	}
 	return nil
 }
@@ -136,6 +138,13 @@ func (p *Processor) processMarkHidden(ch *store.Channel, rule *channel.MarkHidde
 	ch.MarkHidden()
 }
 
+func (p *Processor) processPinStream(ch *store.Channel, rule *channel.PinStreamRule) {
+	if rule.Condition != nil && !p.matchesCondition(ch, *rule.Condition) {
+		return
+	}
+	ch.MarkPinned()
+}
+
 func (p *Processor) matchesCondition(ch *store.Channel, condition common.Condition) bool {
 	if ch.IsRemoved() {
 		return false
@@ -21,11 +21,13 @@ type mockPlaylist struct {
 	name string
 }
 
-func (m mockPlaylist) Name() string                    { return m.name }
-func (m mockPlaylist) Playlists() []string             { return nil }
-func (m mockPlaylist) URLGenerator() *urlgen.Generator { return nil }
-func (m mockPlaylist) Rules() []*channel.Rule          { return nil }
-func (m mockPlaylist) ProxyConfig() proxy.Proxy        { return proxy.Proxy{} }
+func (m mockPlaylist) Name() string                     { return m.name }
+func (m mockPlaylist) Playlists() []common.Source       { return nil }
+func (m mockPlaylist) OnSourceError() string            { return "" }
+func (m mockPlaylist) Snapshots() listing.SnapshotStore { return nil }
+func (m mockPlaylist) URLGenerator() *urlgen.Generator  { return nil }
+func (m mockPlaylist) Rules() []*channel.Rule           { return nil }
+func (m mockPlaylist) ProxyConfig() proxy.Proxy         { return proxy.Proxy{} }
 
 func (m mockPlaylist) HTTPClient() listing.HTTPClient { return nil }
 
@@ -222,3 +224,24 @@ func TestEvaluateFieldConditionEdgeCases(t *testing.T) {
 		t.Error("expected false for non-existent tag")
 	}
 }
+
+func TestPinStream(t *testing.T) {
+	playlist := mockPlaylist{name: "pl1"}
+	uri, _ := url.Parse("http://example.com/stream")
+	processor := NewRulesProcessor("client1", nil)
+
+	rule := &channel.PinStreamRule{Condition: &common.Condition{Playlists: common.StringOrArr{"pl1"}}}
+
+	ch := store.NewChannel(&m3u8.Track{Name: "News", URI: uri}, playlist)
+	processor.processPinStream(ch, rule)
+	if !ch.IsPinned() {
+		t.Error("expected matching channel to be pinned")
+	}
+
+	rule.Condition.Playlists = common.StringOrArr{"pl2"}
+	ch = store.NewChannel(&m3u8.Track{Name: "News", URI: uri}, playlist)
+	processor.processPinStream(ch, rule)
+	if ch.IsPinned() {
+		t.Error("expected non-matching channel not to be pinned")
+	}
+}
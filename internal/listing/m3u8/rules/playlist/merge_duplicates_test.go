@@ -20,10 +20,12 @@ type mockPlaylist struct {
 	name string
 }
 
-func (m mockPlaylist) Name() string                    { return m.name }
-func (m mockPlaylist) Playlists() []string             { return nil }
-func (m mockPlaylist) URLGenerator() *urlgen.Generator { return nil }
-func (m mockPlaylist) Rules() []*channel.Rule          { return nil }
+func (m mockPlaylist) Name() string                     { return m.name }
+func (m mockPlaylist) Playlists() []common.Source       { return nil }
+func (m mockPlaylist) OnSourceError() string            { return "" }
+func (m mockPlaylist) Snapshots() listing.SnapshotStore { return nil }
+func (m mockPlaylist) URLGenerator() *urlgen.Generator  { return nil }
+func (m mockPlaylist) Rules() []*channel.Rule           { return nil }
 
 func (m mockPlaylist) HTTPClient() listing.HTTPClient { return nil }
 
@@ -16,8 +16,10 @@ type URLGenerator interface {
 type Channel struct {
 	track    *m3u8.Track
 	playlist listing.Playlist
+	streams  []urlgen.Stream
 	hidden   bool
 	removed  bool
+	pinned   bool
 	priority int
 }
 
@@ -75,6 +77,22 @@ func (c *Channel) MarkRemoved() {
 	c.removed = true
 }
 
+func (c *Channel) IsPinned() bool {
+	return c.pinned
+}
+
+func (c *Channel) MarkPinned() {
+	c.pinned = true
+}
+
+func (c *Channel) Streams() []urlgen.Stream {
+	return c.streams
+}
+
+func (c *Channel) SetStreams(streams []urlgen.Stream) {
+	c.streams = streams
+}
+
 func (c *Channel) Priority() int {
 	return c.priority
 }
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"majmun/internal/config"
 	"majmun/internal/listing"
 	"majmun/internal/listing/m3u8/rules/channel"
 	"majmun/internal/listing/m3u8/rules/playlist"
 	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
 	"majmun/internal/parser/m3u8"
 )
 
@@ -28,7 +31,7 @@ func NewStreamer(subs []listing.Playlist, epgLink string, channelProcessor *chan
 }
 
 func (s *Streamer) WriteTo(ctx context.Context, w io.Writer) (int64, error) {
-	channels, err := s.getChannels(ctx)
+	channels, err := s.Channels(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -38,22 +41,25 @@ func (s *Streamer) WriteTo(ctx context.Context, w io.Writer) (int64, error) {
 }
 
 func (s *Streamer) GetAllChannels(ctx context.Context) (map[string]string, error) {
-	channels, err := s.getChannels(ctx)
+	channels, err := s.Channels(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	return ChannelIDs(channels), nil
+}
+
+func ChannelIDs(channels []*store.Channel) map[string]string {
 	channelMap := make(map[string]string)
 	for _, ch := range channels {
 		if tvgID, exists := ch.GetAttr("tvg-id"); exists {
 			channelMap[tvgID] = ch.Name()
 		}
 	}
-
-	return channelMap, nil
+	return channelMap
 }
 
-func (s *Streamer) getChannels(ctx context.Context) ([]*store.Channel, error) {
+func (s *Streamer) Channels(ctx context.Context) ([]*store.Channel, error) {
 	st, err := s.fetchPlaylists(ctx)
 	if err != nil {
 		return nil, err
@@ -69,8 +75,8 @@ func (s *Streamer) fetchPlaylists(ctx context.Context) (*store.Store, error) {
 
 	var decoders []*decoderWrapper
 	for _, sub := range s.subscriptions {
-		for _, url := range sub.Playlists() {
-			decoders = append(decoders, newDecoderWrapper(sub, sub.HTTPClient(), url))
+		for i, source := range sub.Playlists() {
+			decoders = append(decoders, newDecoderWrapper(sub, sub.HTTPClient(), source, i))
 		}
 	}
 
@@ -82,45 +88,107 @@ func (s *Streamer) fetchPlaylists(ctx context.Context) (*store.Store, error) {
 		}
 	}()
 
-	for _, decoder := range decoders {
-		err := decoder.StartBuffering(ctx)
+	startErrs := make([]error, len(decoders))
+	for i, decoder := range decoders {
+		startErrs[i] = decoder.StartBuffering(ctx)
+	}
+
+	var lastErr error
+	for i, decoder := range decoders {
+		var items []*m3u8.Track
+		err := startErrs[i]
+		if err == nil {
+			items, err = s.readTracks(ctx, decoder)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
 		if err != nil {
-			return nil, err
+			lastErr = err
+			items, err = s.handleSourceError(ctx, decoder, err)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			s.saveSnapshot(ctx, decoder, items)
 		}
-	}
 
-	for _, decoder := range decoders {
-		if err := s.processTracks(ctx, decoder, st); err != nil {
-			return nil, err
+		for _, track := range items {
+			st.Add(store.NewChannel(track, decoder.subscription))
 		}
 	}
 
 	if st.Len() == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("no channels found in subscriptions: %w", lastErr)
+		}
 		return nil, fmt.Errorf("no channels found in subscriptions")
 	}
 
 	return st, nil
 }
 
-func (s *Streamer) processTracks(ctx context.Context, decoder *decoderWrapper, st *store.Store) error {
+func (s *Streamer) readTracks(ctx context.Context, decoder *decoderWrapper) ([]*m3u8.Track, error) {
 	decoder.StopBuffer()
 
+	var tracks []*m3u8.Track
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 			item, err := decoder.NextItem()
 			if err == io.EOF {
-				return nil
+				return tracks, nil
 			}
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if track, ok := item.(*m3u8.Track); ok {
-				ch := store.NewChannel(track, decoder.subscription)
-				st.Add(ch)
+				tracks = append(tracks, track)
 			}
 		}
 	}
 }
+
+func (s *Streamer) handleSourceError(ctx context.Context, decoder *decoderWrapper, err error) ([]*m3u8.Track, error) {
+	sub := decoder.subscription
+	logArgs := []any{"playlist", sub.Name(), "source_index", decoder.sourceIndex}
+
+	switch sub.OnSourceError() {
+	case config.OnSourceErrorSkip:
+		logging.Error(ctx, err, "playlist source failed, skipping", logArgs...)
+		metrics.SetPlaylistSourceDegraded(sub.Name(), decoder.sourceIndex, config.OnSourceErrorSkip)
+		return nil, nil
+
+	case config.OnSourceErrorLastGood:
+		if sub.Snapshots() != nil {
+			tracks, loadErr := sub.Snapshots().Load(sub.Name(), decoder.source.ID())
+			if loadErr == nil {
+				logging.Error(ctx, err, "playlist source failed, serving last good snapshot", logArgs...)
+				metrics.SetPlaylistSourceDegraded(sub.Name(), decoder.sourceIndex, config.OnSourceErrorLastGood)
+				return tracks, nil
+			}
+		}
+		logging.Error(ctx, err, "playlist source failed and has no snapshot, skipping", logArgs...)
+		metrics.SetPlaylistSourceDegraded(sub.Name(), decoder.sourceIndex, config.OnSourceErrorSkip)
+		return nil, nil
+
+	default:
+		return nil, err
+	}
+}
+
+func (s *Streamer) saveSnapshot(ctx context.Context, decoder *decoderWrapper, tracks []*m3u8.Track) {
+	sub := decoder.subscription
+	metrics.SetPlaylistSourceDegraded(sub.Name(), decoder.sourceIndex, "")
+
+	if sub.Snapshots() == nil || sub.OnSourceError() != config.OnSourceErrorLastGood || len(tracks) == 0 {
+		return
+	}
+
+	if err := sub.Snapshots().Save(sub.Name(), decoder.source.ID(), tracks); err != nil {
+		logging.Error(ctx, err, "failed to save playlist snapshot", "playlist", sub.Name(), "source_index", decoder.sourceIndex)
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"io"
 	"majmun/internal/app"
+	"majmun/internal/config"
+	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
 	"majmun/internal/listing"
 	"majmun/internal/listing/m3u8/rules/channel"
 	"majmun/internal/listing/m3u8/rules/playlist"
+	"majmun/internal/listing/snapshot"
 	"majmun/internal/urlgen"
+	"majmun/internal/utils"
 	"net/http"
 	"strings"
 	"testing"
@@ -19,7 +23,6 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
-	"golang.org/x/sync/semaphore"
 )
 
 func createStreamer(subscriptions []listing.Playlist, epgLink string) *Streamer {
@@ -46,15 +49,27 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 }
 
 func createTestSubscription(name string, playlists []string, httpClient listing.HTTPClient) (*app.Playlist, error) {
-	sem := semaphore.NewWeighted(1)
-	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour)
+	return createTestSubscriptionWithPolicy(name, playlists, httpClient, config.OnSourceErrorFail, nil)
+}
+
+func createTestSubscriptionWithPolicy(
+	name string, playlists []string, httpClient listing.HTTPClient,
+	onSourceError string, snapshots listing.SnapshotStore) (*app.Playlist, error) {
+	sem := utils.NewSemaphore(1)
+	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour, 0)
 	if err != nil {
 		return nil, err
 	}
+	sources := make([]common.Source, 0, len(playlists))
+	for _, p := range playlists {
+		sources = append(sources, common.Source{URL: p})
+	}
 	return app.NewPlaylistProvider(
 		name,
 		generator,
-		playlists,
+		sources,
+		onSourceError,
+		snapshots,
 		proxy.Proxy{},
 		nil,
 		sem,
@@ -325,3 +340,142 @@ http://example.com/music1`
 
 	httpClient.AssertExpectations(t)
 }
+
+func TestStreamerSkipsFailedSource(t *testing.T) {
+	ctx := context.Background()
+	httpClient := new(MockHTTPClient)
+
+	sampleM3U := `#EXTM3U
+#EXTINF:-1 tvg-id="news1" group-title="News", News Channel 1
+http://example.com/news1`
+
+	httpClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "GET" && req.URL.String() == "http://example.com/playlist1.m3u"
+	})).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader([]byte(sampleM3U)))}, nil)
+
+	httpClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "GET" && req.URL.String() == "http://example.com/playlist2.m3u"
+	})).Return(nil, fmt.Errorf("connection failed"))
+
+	sub, err := createTestSubscriptionWithPolicy(
+		"test-subscription",
+		[]string{
+			"http://example.com/playlist1.m3u",
+			"http://example.com/playlist2.m3u",
+		},
+		httpClient,
+		config.OnSourceErrorSkip,
+		nil,
+	)
+	require.NoError(t, err)
+
+	streamer := createStreamer([]listing.Playlist{sub}, "http://example.com/epg.xml")
+
+	buffer := &bytes.Buffer{}
+
+	_, err = streamer.WriteTo(ctx, buffer)
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "News Channel 1")
+
+	httpClient.AssertExpectations(t)
+}
+
+func TestStreamerServesLastGoodSnapshot(t *testing.T) {
+	ctx := context.Background()
+	httpClient := new(MockHTTPClient)
+
+	sampleM3U := `#EXTM3U
+#EXTINF:-1 tvg-id="news1" group-title="News", News Channel 1
+http://example.com/news1`
+
+	isPlaylist := mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "GET" && req.URL.String() == "http://example.com/playlist.m3u"
+	})
+	httpClient.On("Do", isPlaylist).
+		Return(&http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader([]byte(sampleM3U)))}, nil).Once()
+	httpClient.On("Do", isPlaylist).Return(nil, fmt.Errorf("connection failed")).Once()
+
+	snapshots, err := snapshot.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	sub, err := createTestSubscriptionWithPolicy(
+		"test-subscription",
+		[]string{"http://example.com/playlist.m3u"},
+		httpClient,
+		config.OnSourceErrorLastGood,
+		snapshots,
+	)
+	require.NoError(t, err)
+
+	streamer := createStreamer([]listing.Playlist{sub}, "http://example.com/epg.xml")
+
+	_, err = streamer.WriteTo(ctx, &bytes.Buffer{})
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+
+	_, err = streamer.WriteTo(ctx, buffer)
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "News Channel 1")
+
+	httpClient.AssertExpectations(t)
+}
+
+func TestStreamerProxiesCatchup(t *testing.T) {
+	ctx := context.Background()
+	httpClient := new(MockHTTPClient)
+
+	sampleM3U := `#EXTM3U
+#EXTINF:-1 tvg-id="news1" catchup="append" catchup-days="3" catchup-source="?utc={utc}&lutc={lutc}", News Channel 1
+http://example.com/news1
+#EXTINF:-1 tvg-id="sports1", Sports Channel 1
+http://example.com/sports1
+#EXTINF:-1 tvg-id="movies1" catchup-source="archive.php?username=user&password=pass", Movies Channel 1
+http://example.com/movies1`
+
+	httpClient.On("Do", mock.Anything).
+		Return(&http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader([]byte(sampleM3U)))}, nil)
+
+	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour, 0)
+	require.NoError(t, err)
+
+	enabled := true
+	sub, err := app.NewPlaylistProvider(
+		"test-subscription",
+		generator,
+		[]common.Source{{URL: "http://example.com/playlist.m3u"}},
+		config.OnSourceErrorFail,
+		nil,
+		proxy.Proxy{Enabled: &enabled},
+		nil,
+		utils.NewSemaphore(1),
+		httpClient,
+	)
+	require.NoError(t, err)
+
+	streamer := createStreamer([]listing.Playlist{sub}, "")
+
+	channels, err := streamer.Channels(ctx)
+	require.NoError(t, err)
+	require.Len(t, channels, 3)
+
+	for _, ch := range channels {
+		catchupSource, hasCatchup := ch.GetAttr("catchup-source")
+
+		switch ch.Name() {
+		case "News Channel 1":
+			require.True(t, hasCatchup)
+			catchupType, _ := ch.GetAttr("catchup")
+			catchupDays, _ := ch.GetAttr("catchup-days")
+			assert.Equal(t, "default", catchupType)
+			assert.Equal(t, "3", catchupDays)
+			assert.Equal(t, ch.URI().String()+"?utc={utc}&utcend={utcend}", catchupSource)
+			assert.Equal(t, "http://example.com/news1?utc={utc}&lutc={lutc}", ch.Streams()[0].Catchup)
+		case "Sports Channel 1", "Movies Channel 1":
+			assert.False(t, hasCatchup, "unexpected catchup-source %q", catchupSource)
+			assert.Empty(t, ch.Streams()[0].Catchup)
+		default:
+			t.Fatalf("unexpected channel %q", ch.Name())
+		}
+	}
+}
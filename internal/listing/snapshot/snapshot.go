@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"majmun/internal/listing"
+	"majmun/internal/parser/m3u8"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const fileExtension = ".m3u8"
+
+type Store struct {
+	dir    string
+	hashes map[string][sha256.Size]byte
+	mu     sync.Mutex
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	return &Store{
+		dir:    path,
+		hashes: make(map[string][sha256.Size]byte),
+	}, nil
+}
+
+func (s *Store) Save(name, source string, tracks []*m3u8.Track) error {
+	var buf bytes.Buffer
+	encoder := m3u8.NewEncoder(&buf, nil)
+	for _, track := range tracks {
+		if err := encoder.Encode(track); err != nil {
+			return fmt.Errorf("failed to encode snapshot: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	key := snapshotKey(name, source)
+	hash := sha256.Sum256(buf.Bytes())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hashes[key] == hash {
+		return nil
+	}
+
+	path := s.path(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	s.hashes[key] = hash
+	return nil
+}
+
+func (s *Store) Load(name, source string) ([]*m3u8.Track, error) {
+	f, err := os.Open(s.path(snapshotKey(name, source)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var tracks []*m3u8.Track
+	decoder := m3u8.NewDecoder(f)
+	for {
+		item, err := decoder.Decode()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		if track, ok := item.(*m3u8.Track); ok {
+			tracks = append(tracks, track)
+		}
+	}
+
+	return tracks, nil
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key+fileExtension)
+}
+
+func snapshotKey(name, source string) string {
+	return listing.GenerateHashID(name, "\n", source)
+}
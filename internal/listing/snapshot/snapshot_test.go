@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	st, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	uri, _ := url.Parse("http://example.com/1.ts")
+	tracks := []*m3u8.Track{
+		{Name: "Channel 1", Length: -1, URI: uri, Attrs: map[string]string{m3u8.AttrTvgID: "ch1"}},
+	}
+
+	require.NoError(t, st.Save("pl", "http://example.com/pl.m3u", tracks))
+
+	loaded, err := st.Load("pl", "http://example.com/pl.m3u")
+	require.NoError(t, err)
+	require.Len(t, loaded, 1)
+	assert.Equal(t, "Channel 1", loaded[0].Name)
+	assert.Equal(t, "http://example.com/1.ts", loaded[0].URI.String())
+	assert.Equal(t, "ch1", loaded[0].Attrs[m3u8.AttrTvgID])
+}
+
+func TestStore_LoadMissing(t *testing.T) {
+	st, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = st.Load("pl", "http://example.com/pl.m3u")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStore_SaveUnchangedSkipsWrite(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewStore(dir)
+	require.NoError(t, err)
+
+	uri, _ := url.Parse("http://example.com/1.ts")
+	tracks := []*m3u8.Track{{Name: "Channel 1", Length: -1, URI: uri}}
+
+	require.NoError(t, st.Save("pl", "src", tracks))
+	require.NoError(t, os.Remove(st.path(snapshotKey("pl", "src"))))
+	require.NoError(t, st.Save("pl", "src", tracks))
+
+	_, err = st.Load("pl", "src")
+	assert.True(t, os.IsNotExist(err), "expected unchanged snapshot not to be rewritten")
+}
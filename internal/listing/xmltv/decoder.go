@@ -3,8 +3,10 @@ package xmltv
 import (
 	"context"
 	"io"
+	"majmun/internal/config/common"
 	"majmun/internal/listing"
 	"majmun/internal/parser/xmltv"
+	"majmun/internal/xtream"
 )
 
 type decoderWrapper struct {
@@ -13,7 +15,12 @@ type decoderWrapper struct {
 	sourceURL    string
 }
 
-func newDecoderWrapper(subscription listing.EPG, httpClient listing.HTTPClient, url string) *decoderWrapper {
+func newDecoderWrapper(subscription listing.EPG, httpClient listing.HTTPClient, source common.Source) *decoderWrapper {
+	url := source.URL
+	if source.Xtream != nil {
+		url = xtream.NewClient(source.Xtream.URL, source.Xtream.Username, source.Xtream.Password, httpClient).XMLTVURL()
+	}
+
 	initializer := func(ctx context.Context, url string) (listing.Decoder, io.ReadCloser, error) {
 		reader, err := listing.CreateReader(ctx, httpClient, url)
 		if err != nil {
@@ -57,8 +57,8 @@ func (s *Streamer) WriteTo(ctx context.Context, w io.Writer) (int64, error) {
 
 	var decoders []*decoderWrapper
 	for _, sub := range s.subscriptions {
-		for _, url := range sub.EPGs() {
-			decoders = append(decoders, newDecoderWrapper(sub, sub.HTTPClient(), url))
+		for _, source := range sub.EPGs() {
+			decoders = append(decoders, newDecoderWrapper(sub, sub.HTTPClient(), source))
 		}
 	}
 	defer func() {
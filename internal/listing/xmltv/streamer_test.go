@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"majmun/internal/app"
+	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
 	"majmun/internal/listing"
 	"majmun/internal/urlgen"
@@ -36,14 +37,18 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 }
 
 func createTestProvider(name string, epgs []string, httpClient listing.HTTPClient) (*app.EPG, error) {
-	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour)
+	generator, err := urlgen.NewGenerator("http://localhost", "secret", time.Hour, time.Hour, 0)
 	if err != nil {
 		return nil, err
 	}
+	sources := make([]common.Source, 0, len(epgs))
+	for _, e := range epgs {
+		sources = append(sources, common.Source{URL: e})
+	}
 	return app.NewEPGProvider(
 		name,
 		generator,
-		epgs,
+		sources,
 		proxy.Proxy{},
 		httpClient,
 	)
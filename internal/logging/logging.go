@@ -128,6 +128,28 @@ func SanitizeURL(urlString string) string {
 	return sanitizePath(urlString)
 }
 
+// RedactURL strips the user info and query of urlString, where providers
+// usually carry credentials.
+func RedactURL(urlString string) string {
+	parsedURL, err := url.Parse(urlString)
+	if err != nil {
+		return "[redacted]"
+	}
+	parsedURL.User = nil
+	parsedURL.RawQuery = ""
+	parsedURL.Fragment = ""
+	return parsedURL.String()
+}
+
+// RedactURLError redacts the request URL of a *url.Error wrapped in err.
+func RedactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	return err
+}
+
 func sanitizePath(path string) string {
 	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
 	if len(parts) <= 1 {
@@ -3,6 +3,8 @@ package metrics
 import (
 	"context"
 	"majmun/internal/ctxutil"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -18,13 +20,51 @@ const (
 	RequestTypePlaylist = "playlist"
 	RequestTypeEPG      = "epg"
 	RequestTypeFile     = "file"
+	RequestTypeLineup   = "lineup"
 )
 
 const (
-	FailureReasonGlobalLimit   = "global_limit"
-	FailureReasonPlaylistLimit = "playlist_limit"
-	FailureReasonClientLimit   = "client_limit"
-	FailureReasonUpstreamError = "upstream_error"
+	ReloadStatusSuccess = "success"
+	ReloadStatusFailure = "failure"
+)
+
+const (
+	RestartReasonStall    = "stall"
+	RestartReasonFailover = "failover"
+)
+
+const (
+	FailureReasonGlobalLimit           = "global_limit"
+	FailureReasonPlaylistLimit         = "playlist_limit"
+	FailureReasonClientLimit           = "client_limit"
+	FailureReasonGlobalUpstreamLimit   = "global_upstream_limit"
+	FailureReasonPlaylistUpstreamLimit = "playlist_upstream_limit"
+	FailureReasonClientUpstreamLimit   = "client_upstream_limit"
+	FailureReasonGlobalViewerLimit     = "global_viewer_limit"
+	FailureReasonPlaylistViewerLimit   = "playlist_viewer_limit"
+	FailureReasonClientViewerLimit     = "client_viewer_limit"
+	FailureReasonAccountLimit          = "account_limit"
+	FailureReasonUpstreamError         = "upstream_error"
+	FailureReasonPreempted             = "preempted"
+)
+
+const (
+	LimitConcurrency         = "concurrency"
+	LimitUpstreamConcurrency = "upstream_concurrency"
+	LimitViewerConcurrency   = "viewer_concurrency"
+)
+
+const (
+	LimitScopeGlobal   = "global"
+	LimitScopePlaylist = "playlist"
+	LimitScopeClient   = "client"
+	LimitScopeAccount  = "account"
+)
+
+const (
+	QueueOutcomeAcquired = "acquired"
+	QueueOutcomeTimeout  = "timeout"
+	QueueOutcomeCanceled = "canceled"
 )
 
 var (
@@ -60,6 +100,22 @@ var (
 		[]string{"client_name", "playlist_name", "channel_name", "reason"},
 	)
 
+	playlistSourceDegraded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_playlist_source_degraded",
+			Help: "Whether a playlist source failed and is served from a snapshot or skipped",
+		},
+		[]string{"playlist_name", "source_index", "action"},
+	)
+
+	segmenterRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_segmenter_restarts_total",
+			Help: "Total number of segmenter restarts by reason",
+		},
+		[]string{"playlist_name", "channel_name", "reason"},
+	)
+
 	listingRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "iptv_listing_requests_total",
@@ -75,6 +131,47 @@ var (
 		},
 		[]string{"client_name", "request_type", "cache_status"},
 	)
+
+	concurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_concurrency_limit",
+			Help: "Configured concurrency limits",
+		},
+		[]string{"limit", "scope", "name"},
+	)
+
+	concurrencyUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_concurrency_used",
+			Help: "Currently used slots of concurrency limits",
+		},
+		[]string{"limit", "scope", "name"},
+	)
+
+	queueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptv_queue_depth",
+			Help: "Requests currently waiting for a free slot",
+		},
+		[]string{"client_name", "reason"},
+	)
+
+	queueWaitSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iptv_queue_wait_seconds",
+			Help:    "Time requests spent waiting for a free slot",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"client_name", "reason", "outcome"},
+	)
+
+	configReloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptv_config_reloads_total",
+			Help: "Total number of configuration reloads by status",
+		},
+		[]string{"status"},
+	)
 )
 
 func IncPlaylistStreamsActive(ctx context.Context) {
@@ -93,8 +190,8 @@ func DecPlaylistStreamsActive(ctx context.Context) {
 	playlistStreamsActive.WithLabelValues(subscriptionName).Dec()
 }
 
-func SetPlaylistStreamsActive(playlistName string, value float64) {
-	playlistStreamsActive.WithLabelValues(playlistName).Set(value)
+func InitPlaylistStreamsActive(playlistName string) {
+	playlistStreamsActive.WithLabelValues(playlistName).Add(0)
 }
 
 func IncClientStreamsActive(ctx context.Context) {
@@ -139,6 +236,26 @@ func IncStreamsFailures(ctx context.Context, reason string) {
 	streamsFailuresTotal.WithLabelValues(clientName, playlistName, channelName, reason).Inc()
 }
 
+func SetPlaylistSourceDegraded(playlistName string, sourceIndex int, action string) {
+	playlistSourceDegraded.DeletePartialMatch(prometheus.Labels{
+		"playlist_name": playlistName,
+		"source_index":  strconv.Itoa(sourceIndex),
+	})
+	if action != "" {
+		playlistSourceDegraded.WithLabelValues(playlistName, strconv.Itoa(sourceIndex), action).Set(1)
+	}
+}
+
+func IncSegmenterRestarts(ctx context.Context, reason string) {
+	if ctxutil.ChannelHidden(ctx) {
+		return
+	}
+
+	channelName := ctxutil.ChannelName(ctx)
+	playlistName := ctxutil.ProviderName(ctx)
+	segmenterRestartsTotal.WithLabelValues(playlistName, channelName, reason).Inc()
+}
+
 func IncListingDownload(ctx context.Context) {
 	clientName := ctxutil.ClientName(ctx)
 	requestType := ctxutil.RequestType(ctx)
@@ -151,13 +268,49 @@ func IncProxyRequests(ctx context.Context, cacheStatus string) {
 	proxyRequestsTotal.WithLabelValues(clientName, requestType, cacheStatus).Inc()
 }
 
+func SetConcurrencyLimit(limit, scope, name string, value int64) {
+	concurrencyLimit.WithLabelValues(limit, scope, name).Set(float64(value))
+	concurrencyUsed.WithLabelValues(limit, scope, name).Add(0)
+}
+
+func IncConcurrencyUsed(limit, scope, name string) {
+	concurrencyUsed.WithLabelValues(limit, scope, name).Inc()
+}
+
+func DecConcurrencyUsed(limit, scope, name string) {
+	concurrencyUsed.WithLabelValues(limit, scope, name).Dec()
+}
+
+func IncQueueDepth(ctx context.Context, reason string) {
+	queueDepth.WithLabelValues(ctxutil.ClientName(ctx), reason).Inc()
+}
+
+func DecQueueDepth(ctx context.Context, reason string) {
+	queueDepth.WithLabelValues(ctxutil.ClientName(ctx), reason).Dec()
+}
+
+func ObserveQueueWait(ctx context.Context, reason, outcome string, wait time.Duration) {
+	queueWaitSeconds.WithLabelValues(ctxutil.ClientName(ctx), reason, outcome).Observe(wait.Seconds())
+}
+
+func IncConfigReloads(status string) {
+	configReloadsTotal.WithLabelValues(status).Inc()
+}
+
 func init() {
 	Registry.MustRegister(clientStreamsActive)
 	Registry.MustRegister(playlistStreamsActive)
 	Registry.MustRegister(streamsReusedTotal)
 	Registry.MustRegister(streamsFailuresTotal)
+	Registry.MustRegister(segmenterRestartsTotal)
+	Registry.MustRegister(playlistSourceDegraded)
 	Registry.MustRegister(listingRequestsTotal)
 	Registry.MustRegister(proxyRequestsTotal)
+	Registry.MustRegister(concurrencyLimit)
+	Registry.MustRegister(concurrencyUsed)
+	Registry.MustRegister(queueDepth)
+	Registry.MustRegister(queueWaitSeconds)
+	Registry.MustRegister(configReloadsTotal)
 	Registry.MustRegister(collectors.NewGoCollector(
 		collectors.WithoutGoCollectorRuntimeMetrics(),
 	))
@@ -13,16 +13,17 @@ type Track struct {
 }
 
 const (
-	AttrTvgID       = "tvg-id"
-	AttrTvgName     = "tvg-name"
-	AttrTvgLogo     = "tvg-logo"
-	AttrGroupTitle  = "group-title"
-	AttrRadio       = "radio"
-	AttrTvgChno     = "tvg-chno"
-	AttrTvgShift    = "tvg-shift"
-	AttrCatchup     = "catchup"
-	AttrCatchupDays = "catchup-days"
-	AttrTvgRec      = "tvg-rec"
+	AttrTvgID         = "tvg-id"
+	AttrTvgName       = "tvg-name"
+	AttrTvgLogo       = "tvg-logo"
+	AttrGroupTitle    = "group-title"
+	AttrRadio         = "radio"
+	AttrTvgChno       = "tvg-chno"
+	AttrTvgShift      = "tvg-shift"
+	AttrCatchup       = "catchup"
+	AttrCatchupDays   = "catchup-days"
+	AttrCatchupSource = "catchup-source"
+	AttrTvgRec        = "tvg-rec"
 )
 
 const (
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"majmun/internal/logging"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	muxStreamKeyVar  = "stream_key"
+	muxClientIDVar   = "client_id"
+	muxClientNameVar = "client_name"
+)
+
+type segmenterResponse struct {
+	StreamKey    string    `json:"stream_key"`
+	ChannelName  string    `json:"channel_name"`
+	PlaylistName string    `json:"playlist_name"`
+	Profile      string    `json:"profile,omitempty"`
+	ClientCount  int64     `json:"client_count"`
+	Lingering    bool      `json:"lingering"`
+	Pinned       bool      `json:"pinned"`
+	StartedAt    time.Time `json:"started_at"`
+	Uptime       string    `json:"uptime"`
+	Dir          string    `json:"dir"`
+}
+
+type revokeRequest struct {
+	Before time.Time `json:"before"`
+}
+
+type revokeResponse struct {
+	ClientName    string    `json:"client_name"`
+	RevokedBefore time.Time `json:"revoked_before"`
+}
+
+type clientResponse struct {
+	ID          string    `json:"id"`
+	ClientName  string    `json:"client_name"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Duration    string    `json:"duration"`
+}
+
+func (s *Server) setupAdminServer(addr, token string) {
+	r := mux.NewRouter()
+	r.Use(s.requestIDMiddleware)
+	r.Use(s.loggerMiddleware)
+	r.Use(adminAuthMiddleware(token))
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/segmenters", s.handleAdminSegmenters).Methods(http.MethodGet)
+	api.HandleFunc("/segmenters/{"+muxStreamKeyVar+"}", s.handleAdminStopSegmenter).Methods(http.MethodDelete)
+	api.HandleFunc("/segmenters/{"+muxStreamKeyVar+"}/clients", s.handleAdminClients).Methods(http.MethodGet)
+	api.HandleFunc("/segmenters/{"+muxStreamKeyVar+"}/clients/{"+muxClientIDVar+"}",
+		s.handleAdminDisconnectClient).Methods(http.MethodDelete)
+	api.HandleFunc("/listings/refresh", s.handleAdminRefreshListings).Methods(http.MethodPost)
+	api.HandleFunc("/clients/{"+muxClientNameVar+"}/revoke", s.handleAdminRevokeClient).Methods(http.MethodPost)
+
+	if s.dvr != nil {
+		api.HandleFunc("/recordings", s.handleAdminRecordings).Methods(http.MethodGet)
+		api.HandleFunc("/recordings", s.handleAdminScheduleRecording).Methods(http.MethodPost)
+		api.HandleFunc("/recordings/{"+muxRecordingIDVar+"}", s.handleAdminDeleteRecording).Methods(http.MethodDelete)
+	}
+
+	s.adminServer = &http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+}
+
+func adminAuthMiddleware(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				logging.Debug(r.Context(), "admin authentication failed")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) handleAdminSegmenters(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	segmenters := s.streamPool.Segmenters()
+
+	resp := make([]segmenterResponse, 0, len(segmenters))
+	for _, seg := range segmenters {
+		resp = append(resp, segmenterResponse{
+			StreamKey:    seg.StreamKey,
+			ChannelName:  seg.ChannelName,
+			PlaylistName: seg.PlaylistName,
+			Profile:      seg.Profile,
+			ClientCount:  seg.ClientCount,
+			Lingering:    seg.Lingering,
+			Pinned:       seg.Pinned,
+			StartedAt:    seg.StartedAt,
+			Uptime:       now.Sub(seg.StartedAt).Truncate(time.Second).String(),
+			Dir:          seg.Dir,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	clients, ok := s.streamPool.Clients(mux.Vars(r)[muxStreamKeyVar])
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	resp := make([]clientResponse, 0, len(clients))
+	for _, c := range clients {
+		resp = append(resp, clientResponse{
+			ID:          c.ID,
+			ClientName:  c.ClientName,
+			ConnectedAt: c.ConnectedAt,
+			Duration:    now.Sub(c.ConnectedAt).Truncate(time.Second).String(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleAdminStopSegmenter(w http.ResponseWriter, r *http.Request) {
+	streamKey := mux.Vars(r)[muxStreamKeyVar]
+
+	if !s.streamPool.StopSegmenter(streamKey) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	logging.Info(r.Context(), "segmenter stopped by admin", "stream_key", streamKey)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	streamKey := vars[muxStreamKeyVar]
+	clientID := vars[muxClientIDVar]
+
+	if !s.streamPool.DisconnectClient(streamKey, clientID) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	logging.Info(r.Context(), "client disconnected by admin", "stream_key", streamKey, "id", clientID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminRefreshListings(w http.ResponseWriter, r *http.Request) {
+	s.invalidateListings()
+
+	logging.Info(r.Context(), "listing refresh requested by admin")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleAdminRevokeClient(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[muxClientNameVar]
+
+	req := revokeRequest{Before: time.Now()}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	m := s.manager.Load()
+	if m.ClientByName(name) == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	revokedBefore, err := m.RevokeClient(name, req.Before)
+	if err != nil {
+		logging.Error(r.Context(), err, "failed to revoke client tokens", "client", name)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.invalidateListings()
+
+	logging.Info(r.Context(), "client tokens revoked by admin", "client", name, "revoked_before", revokedBefore)
+	writeJSON(w, http.StatusOK, revokeResponse{ClientName: name, RevokedBefore: revokedBefore})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"majmun/internal/app"
+	"majmun/internal/config"
+	"majmun/internal/ctxutil"
+	"majmun/internal/dvr"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/parser/m3u8"
+	"majmun/internal/parser/xmltv"
+	"majmun/internal/streampool"
+	"majmun/internal/urlgen"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const muxRecordingIDVar = "recording_id"
+
+type scheduleRequest struct {
+	ClientName string    `json:"client_name"`
+	Channel    string    `json:"channel"`
+	Title      string    `json:"title"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+type recordingReader struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *recordingReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
+func dvrDir(cfg *config.Config) string {
+	if cfg.DVR.Dir != "" {
+		return cfg.DVR.Dir
+	}
+	if cfg.Server.DataDir != "" {
+		return filepath.Join(cfg.Server.DataDir, "recordings")
+	}
+	return ""
+}
+
+func (s *Server) openRecording(ctx context.Context, rec dvr.Recording) (io.ReadCloser, error) {
+	m, releaseManager := s.acquireManager()
+	if m == nil {
+		return nil, errors.New("server stopped")
+	}
+	defer releaseManager()
+
+	client := m.ClientByName(rec.ClientName)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", rec.ClientName)
+	}
+
+	ctx = ctxutil.WithClient(ctx, client)
+	ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypePlaylist)
+	ctx = ctxutil.WithChannelName(ctx, rec.Channel)
+
+	l, err := s.listing(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+
+	var streams []urlgen.Stream
+	for _, ch := range l.Channels {
+		if ch.Name() == rec.Channel {
+			streams = ch.Streams()
+			break
+		}
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("channel not found: %s", rec.Channel)
+	}
+
+	release, ok := s.acquireSemaphores(ctx)
+	if !ok {
+		return nil, errors.New("failed to acquire semaphores")
+	}
+
+	lastErr := errors.New("channel has no proxied streams")
+	for i, stream := range streams {
+		playlist, ok := client.GetProvider(stream.ProviderInfo.ProviderType, stream.ProviderInfo.ProviderName).(*app.Playlist)
+		if !ok || playlist == nil || !playlist.IsProxied() {
+			continue
+		}
+
+		streamCtx := ctxutil.WithProviderType(ctx, metrics.RequestTypePlaylist)
+		streamCtx = ctxutil.WithProviderName(streamCtx, playlist.Name())
+
+		releaseViewer, ok := acquireLimits(streamCtx, playlist.QueueTimeout(), playlist.ViewerLimit())
+		if !ok {
+			lastErr = errors.New("failed to acquire playlist viewer slot")
+			continue
+		}
+
+		reader, err := s.streamPool.GetReader(streamCtx, profileRequest(playlist, streampool.Request{
+			StreamKey:      buildStreamKey(stream.URL, ""),
+			StreamURL:      stream.URL,
+			Fallbacks:      streamFallbacks(stream, streams[i+1:], ""),
+			ClientStreamer: playlist.ClientStreamer,
+			Semaphore:      playlist.Semaphore(),
+			Limits:         playlist.UpstreamLimits(),
+			QueueTimeout:   playlist.QueueTimeout(),
+			Segmenter:      playlist.SegmenterConfig(),
+			Mirrors:        playlist.Mirrors,
+		}, ""))
+		if err == nil {
+			return &recordingReader{ReadCloser: reader, release: func() {
+				releaseViewer()
+				release()
+			}}, nil
+		}
+		releaseViewer()
+		lastErr = err
+	}
+
+	release()
+	return nil, lastErr
+}
+
+func (s *Server) applyDVRRules() {
+	if s.dvr == nil {
+		return
+	}
+
+	m, release := s.acquireManager()
+	if m == nil {
+		return
+	}
+	defer release()
+
+	for _, client := range m.Clients() {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if !s.dvr.HasRules(client.Name()) {
+			continue
+		}
+
+		clientCtx := ctxutil.WithClient(s.ctx, client)
+
+		guide, err := s.dvrGuide(clientCtx, client)
+		if err != nil {
+			logging.Error(clientCtx, err, "failed to read programme guide")
+			continue
+		}
+
+		if err := s.dvr.ApplyRules(client.Name(), guide); err != nil {
+			logging.Error(clientCtx, err, "failed to schedule recordings")
+		}
+	}
+}
+
+func (s *Server) dvrGuide(ctx context.Context, client *app.Client) (dvr.Guide, error) {
+	l, err := s.listing(ctx, client)
+	if err != nil {
+		return dvr.Guide{}, err
+	}
+
+	guide := dvr.Guide{Channels: make(map[string]string)}
+	for _, ch := range l.Channels {
+		if id, ok := ch.GetAttr(m3u8.AttrTvgID); ok && id != "" {
+			guide.Channels[id] = ch.Name()
+		}
+	}
+	if l.EPG == nil {
+		return guide, nil
+	}
+
+	decoder := xmltv.NewDecoder(bytes.NewReader(l.EPG.Data))
+	for {
+		item, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return guide, fmt.Errorf("failed to decode epg: %w", err)
+		}
+		if programme, ok := item.(xmltv.Programme); ok {
+			guide.Programmes = append(guide.Programmes, programme)
+		}
+	}
+
+	return guide, nil
+}
+
+func (s *Server) handleRecordingsPlaylist(w http.ResponseWriter, r *http.Request) {
+	ctx := ctxutil.WithRequestType(r.Context(), metrics.RequestTypePlaylist)
+	client := ctxutil.Client(ctx).(*app.Client)
+
+	logging.Debug(ctx, "recordings playlist request")
+
+	if s.dvr == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	baseURL := s.serverURL + "/" + mux.Vars(r)[muxClientSecretVar] + "/recordings/"
+
+	var buf bytes.Buffer
+	encoder := m3u8.NewEncoder(&buf, nil)
+	for _, rec := range s.dvr.Recordings() {
+		if rec.ClientName != client.Name() || rec.Status != dvr.StatusCompleted {
+			continue
+		}
+
+		u, err := url.Parse(baseURL + rec.FileName())
+		if err != nil {
+			logging.Error(ctx, err, "failed to build recording url", "id", rec.ID)
+			continue
+		}
+
+		if err := encoder.Encode(&m3u8.Track{
+			Name:   recordingName(rec),
+			Length: rec.Duration().Seconds(),
+			URI:    u,
+			Attrs:  map[string]string{m3u8.AttrGroupTitle: rec.Channel},
+		}); err != nil {
+			logging.Error(ctx, err, "failed to write recordings playlist")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	_ = encoder.Close()
+
+	if buf.Len() == 0 {
+		buf.WriteString(m3u8.TagHeader + "\n")
+	}
+
+	setHeaders(w, playlistHeaders)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Server) handleRecordingFile(w http.ResponseWriter, r *http.Request) {
+	client := ctxutil.Client(r.Context()).(*app.Client)
+	name := mux.Vars(r)[muxFileVar]
+
+	if s.dvr == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	rec, ok := s.dvr.Recording(strings.TrimSuffix(name, filepath.Ext(name)))
+	if !ok || rec.ClientName != client.Name() || rec.FileName() != name || rec.Status != dvr.StatusCompleted {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(s.dvr.FilePath(rec))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", streamContentType)
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+}
+
+func (s *Server) handleAdminRecordings(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.dvr.Recordings())
+}
+
+func (s *Server) handleAdminScheduleRecording(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	m, release := s.acquireManager()
+	if m == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	client := m.ClientByName(req.ClientName)
+	if client == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var recordings []dvr.Recording
+	var err error
+
+	if req.Title != "" {
+		title, compileErr := regexp.Compile(req.Title)
+		if compileErr != nil {
+			http.Error(w, "invalid title pattern", http.StatusBadRequest)
+			return
+		}
+
+		guide, guideErr := s.dvrGuide(ctxutil.WithClient(r.Context(), client), client)
+		if guideErr != nil {
+			logging.Error(r.Context(), guideErr, "failed to read programme guide", "client", req.ClientName)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		recordings, err = s.dvr.ScheduleMatching(req.ClientName, req.Channel, title, guide)
+	} else {
+		if req.Channel == "" {
+			http.Error(w, "channel is required without title", http.StatusBadRequest)
+			return
+		}
+
+		var rec dvr.Recording
+		rec, err = s.dvr.Schedule(dvr.Recording{
+			ClientName: req.ClientName,
+			Channel:    req.Channel,
+			Start:      req.Start,
+			End:        req.End,
+		})
+		recordings = append(recordings, rec)
+	}
+
+	if errors.Is(err, dvr.ErrInvalidRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), err, "failed to schedule recording", "client", req.ClientName)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info(r.Context(), "recordings scheduled by admin", "client", req.ClientName, "count", len(recordings))
+	writeJSON(w, http.StatusCreated, recordings)
+}
+
+func (s *Server) handleAdminDeleteRecording(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)[muxRecordingIDVar]
+
+	rec, err := s.dvr.Delete(id)
+	if errors.Is(err, dvr.ErrNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), err, "failed to delete recording", "id", id)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info(r.Context(), "recording deleted by admin", "id", id, "status", rec.Status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func recordingName(rec dvr.Recording) string {
+	start := rec.Start.Local().Format("2006-01-02 15:04")
+	if rec.Title != "" {
+		return rec.Title + " (" + rec.Channel + ", " + start + ")"
+	}
+	return rec.Channel + " (" + start + ")"
+}
@@ -4,21 +4,32 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"majmun/internal/app"
+	"majmun/internal/catchup"
+	"majmun/internal/config/proxy"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"majmun/internal/ctxutil"
-	"majmun/internal/listing/m3u8"
-	"majmun/internal/listing/xmltv"
+	"majmun/internal/listing/cache"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
+	"majmun/internal/streampool"
 	"majmun/internal/urlgen"
+
+	"github.com/gorilla/mux"
 )
 
-const streamContentType = "video/mp2t"
+const (
+	streamContentType = "video/mp2t"
+	queryOffset       = "offset"
+	querySession      = "session"
+)
 
 type responseHeaders map[string]string
 
@@ -44,24 +55,14 @@ func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
 
 	logging.Debug(ctx, "playlist request")
 
-	setHeaders(w, playlistHeaders)
-
-	streamer := m3u8.NewStreamer(
-		client.PlaylistProviders(),
-		client.EPGLink(),
-		client.ChannelProcessor(),
-		client.PlaylistProcessor(),
-	)
-
-	count, err := streamer.WriteTo(ctx, w)
+	l, err := s.listing(ctx, client)
 	if err != nil {
-		logging.Error(ctx, err, "failed to write playlist")
-		if count == 0 {
-			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
-		}
+		logging.Error(ctx, err, "failed to build playlist")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 		return
 	}
 
+	serveContent(w, r, playlistHeaders, l.Playlist)
 	metrics.IncListingDownload(ctx)
 }
 
@@ -70,24 +71,7 @@ func (s *Server) handleEPG(w http.ResponseWriter, r *http.Request) {
 
 	logging.Debug(ctx, "epg request")
 
-	streamer, err := s.prepareEPGStreamer(ctx)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
-		return
-	}
-
-	setHeaders(w, epgHeaders)
-
-	count, err := streamer.WriteTo(ctx, w)
-	if err != nil {
-		logging.Error(ctx, err, "failed to write EPG")
-		if count == 0 {
-			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
-		}
-		return
-	}
-
-	metrics.IncListingDownload(ctx)
+	s.serveEPG(ctx, w, r, epgHeaders, func(l *cache.Listing) *cache.Content { return l.EPG })
 }
 
 func (s *Server) handleEPGgz(w http.ResponseWriter, r *http.Request) {
@@ -95,23 +79,29 @@ func (s *Server) handleEPGgz(w http.ResponseWriter, r *http.Request) {
 
 	logging.Debug(ctx, "gzipped epg request")
 
-	streamer, err := s.prepareEPGStreamer(ctx)
+	s.serveEPG(ctx, w, r, epgGzipHeaders, func(l *cache.Listing) *cache.Content { return l.EPGGzip })
+}
+
+func (s *Server) serveEPG(
+	ctx context.Context, w http.ResponseWriter, r *http.Request,
+	headers responseHeaders, content func(*cache.Listing) *cache.Content) {
+	client := ctxutil.Client(ctx).(*app.Client)
+
+	l, err := s.listing(ctx, client)
 	if err != nil {
+		logging.Error(ctx, err, "failed to get channels")
 		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 		return
 	}
 
-	setHeaders(w, epgGzipHeaders)
-
-	count, err := streamer.WriteToGzip(ctx, w)
-	if err != nil {
-		logging.Error(ctx, err, "failed to write gzipped epg")
-		if count == 0 {
-			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
-		}
+	c := content(l)
+	if c == nil {
+		logging.Error(ctx, errors.New("epg is not available"), "failed to write EPG")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 		return
 	}
 
+	serveContent(w, r, headers, c)
 	metrics.IncListingDownload(ctx)
 }
 
@@ -125,13 +115,37 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	case urlgen.RequestTypeFile:
 		s.handleFileProxy(ctx, w, data)
 	case urlgen.RequestTypeStream:
-		s.handleStreamProxy(ctx, w, r)
+		s.handleStreamRequest(ctx, w, r)
 	default:
 		logging.Error(ctx, nil, "invalid proxy request type", "type", data.RequestType)
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 	}
 }
 
+func (s *Server) handleStreamRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	file := mux.Vars(r)[muxFileVar]
+
+	originalQuery := r.URL.RawQuery
+	offset, rawQuery := cutOffset(originalQuery)
+	session, rawQuery := cutQueryParam(rawQuery, querySession)
+	if rawQuery != originalQuery {
+		r = r.Clone(ctx)
+		r.URL.RawQuery = rawQuery
+	}
+
+	switch {
+	case file == hlsPlaylistFile && !validHLSSession(session):
+		_, sessionless := cutQueryParam(originalQuery, querySession)
+		writeHLSSessionPlaylist(w, sessionless)
+	case file == hlsPlaylistFile:
+		s.handleHLSPlaylist(ctx, w, r, offset, session)
+	case streampool.IsSegmentName(file):
+		s.handleHLSSegment(ctx, w, r, file, session)
+	default:
+		s.handleStreamProxy(ctx, w, r, offset)
+	}
+}
+
 func (s *Server) handleFileProxy(ctx context.Context, w http.ResponseWriter, data *urlgen.Data) {
 	ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypeFile)
 
@@ -174,24 +188,6 @@ func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(http.StatusText(http.StatusOK)))
 }
 
-func (s *Server) prepareEPGStreamer(ctx context.Context) (*xmltv.Streamer, error) {
-	client := ctxutil.Client(ctx).(*app.Client)
-
-	m3u8Streamer := m3u8.NewStreamer(
-		client.PlaylistProviders(),
-		"",
-		client.ChannelProcessor(),
-		client.PlaylistProcessor(),
-	)
-
-	channels, err := m3u8Streamer.GetAllChannels(ctx)
-	if err != nil {
-		logging.Error(ctx, err, "failed to get channels")
-		return nil, err
-	}
-	return xmltv.NewStreamer(client.EPGProviders(), channels), nil
-}
-
 func setHeaders(w http.ResponseWriter, headers responseHeaders) {
 	for key, value := range headers {
 		w.Header().Set(key, value)
@@ -205,6 +201,100 @@ func buildStreamURL(baseURL, rawQuery string) string {
 	return baseURL
 }
 
+func streamFallbacks(primary urlgen.Stream, streams []urlgen.Stream, rawQuery string) []string {
+	if isCatchupRequest(rawQuery) {
+		return nil
+	}
+
+	result := make([]string, 0, len(streams))
+	for _, stream := range streams {
+		if stream.ProviderInfo != primary.ProviderInfo {
+			continue
+		}
+		result = append(result, buildStreamURL(stream.URL, rawQuery))
+	}
+	return result
+}
+
+func upstreamURL(stream urlgen.Stream, rawQuery string) (string, bool) {
+	window, ok := catchup.ParseQuery(rawQuery)
+	if !ok {
+		return buildStreamURL(stream.URL, rawQuery), true
+	}
+	if stream.Catchup == "" {
+		return "", false
+	}
+	return catchup.Expand(stream.Catchup, window, time.Now()), true
+}
+
+func cutOffset(rawQuery string) (time.Duration, string) {
+	value, rawQuery := cutQueryParam(rawQuery, queryOffset)
+
+	var offset time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		offset = time.Duration(secs) * time.Second
+	} else if d, err := time.ParseDuration(value); err == nil {
+		offset = d
+	}
+
+	return max(offset, 0), rawQuery
+}
+
+func cutQueryParam(rawQuery, key string) (string, string) {
+	var result string
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if name != key {
+			kept = append(kept, param)
+			continue
+		}
+		result = value
+	}
+
+	return result, strings.Join(kept, "&")
+}
+
+func isCatchupRequest(rawQuery string) bool {
+	_, ok := catchup.ParseQuery(rawQuery)
+	return ok
+}
+
+func segmenterConfig(cfg proxy.Segmenter, rawQuery string) proxy.Segmenter {
+	if isCatchupRequest(rawQuery) {
+		cfg.Linger = nil
+	}
+	return cfg
+}
+
+func profileStreamKey(playlist *app.Playlist, streamKey string) string {
+	if playlist.Profile() == "" {
+		return streamKey
+	}
+	return generateHash(streamKey, playlist.Profile())
+}
+
+func profileRequest(playlist *app.Playlist, req streampool.Request, rawQuery string) streampool.Request {
+	if playlist.Profile() == "" {
+		return req
+	}
+
+	upstream := req
+	upstream.ClientStreamer = nil
+	upstream.Offset = 0
+
+	return streampool.Request{
+		StreamKey:      profileStreamKey(playlist, req.StreamKey),
+		ClientStreamer: req.ClientStreamer,
+		Segmenter:      segmenterConfig(playlist.ProfileSegmenterConfig(), rawQuery),
+		Offset:         req.Offset,
+		Profile:        playlist.Profile(),
+		Upstream:       &upstream,
+	}
+}
+
 func buildStreamKey(baseURL, rawQuery string) string {
 	if rawQuery != "" {
 		return generateHash(baseURL+"?"+rawQuery, time.Now().Unix())
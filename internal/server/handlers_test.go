@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCutQueryParam(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawQuery string
+		key      string
+		value    string
+		rest     string
+	}{
+		{name: "empty query", key: "session"},
+		{name: "missing", rawQuery: "utc=1&lutc=2", key: "session", rest: "utc=1&lutc=2"},
+		{name: "only param", rawQuery: "session=abc", key: "session", value: "abc"},
+		{name: "first", rawQuery: "session=abc&utc=1", key: "session", value: "abc", rest: "utc=1"},
+		{name: "middle", rawQuery: "utc=1&session=abc&lutc=2", key: "session", value: "abc", rest: "utc=1&lutc=2"},
+		{name: "last wins", rawQuery: "session=a&session=b", key: "session", value: "b"},
+		{name: "no value", rawQuery: "session&utc=1", key: "session", rest: "utc=1"},
+		{name: "prefix is not a match", rawQuery: "sessions=abc", key: "session", rest: "sessions=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, rest := cutQueryParam(tt.rawQuery, tt.key)
+			assert.Equal(t, tt.value, value)
+			assert.Equal(t, tt.rest, rest)
+		})
+	}
+}
+
+func TestCutOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawQuery string
+		offset   time.Duration
+		rest     string
+	}{
+		{name: "none", rawQuery: "utc=1", rest: "utc=1"},
+		{name: "seconds", rawQuery: "offset=90&session=abc", offset: 90 * time.Second, rest: "session=abc"},
+		{name: "duration", rawQuery: "utc=1&offset=1m30s", offset: 90 * time.Second, rest: "utc=1"},
+		{name: "negative", rawQuery: "offset=-30", rest: ""},
+		{name: "invalid", rawQuery: "offset=soon&utc=1", rest: "utc=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, rest := cutOffset(tt.rawQuery)
+			assert.Equal(t, tt.offset, offset)
+			assert.Equal(t, tt.rest, rest)
+		})
+	}
+}
+
+func TestXtreamStreamURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		ext  string
+		want string
+	}{
+		{name: "ts link as ts", url: "http://majmun/token/f.ts", ext: "ts", want: "http://majmun/token/f.ts"},
+		{name: "ts link as hls", url: "http://majmun/token/f.ts", ext: "m3u8", want: "http://majmun/token/f.m3u8"},
+		{name: "hls link as ts", url: "http://majmun/tv/token/f.m3u8", ext: "ts", want: "http://majmun/tv/token/f.ts"},
+		{name: "upstream link", url: "http://provider/live/1.ts", ext: "m3u8", want: "http://provider/live/1.ts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, xtreamStreamURL(u, tt.ext).String())
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"majmun/internal/app"
+	"majmun/internal/ctxutil"
+	"majmun/internal/listing/hdhomerun"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultTunerCount = 4
+
+var (
+	jsonHeaders = responseHeaders{
+		"Content-Type":  "application/json",
+		"Cache-Control": "no-cache",
+	}
+	deviceXMLHeaders = responseHeaders{
+		"Content-Type":  "application/xml",
+		"Cache-Control": "no-cache",
+	}
+)
+
+func (s *Server) handleDiscover(w http.ResponseWriter, r *http.Request) {
+	logging.Debug(r.Context(), "hdhomerun discover request")
+
+	setHeaders(w, jsonHeaders)
+	if err := json.NewEncoder(w).Encode(s.hdhomerunDevice(r).Discover()); err != nil {
+		logging.Error(r.Context(), err, "failed to write discover response")
+	}
+}
+
+func (s *Server) handleLineupStatus(w http.ResponseWriter, r *http.Request) {
+	logging.Debug(r.Context(), "hdhomerun lineup status request")
+
+	setHeaders(w, jsonHeaders)
+	if err := json.NewEncoder(w).Encode(s.hdhomerunDevice(r).LineupStatus()); err != nil {
+		logging.Error(r.Context(), err, "failed to write lineup status response")
+	}
+}
+
+func (s *Server) handleLineup(w http.ResponseWriter, r *http.Request) {
+	ctx := ctxutil.WithRequestType(r.Context(), metrics.RequestTypeLineup)
+	client := ctxutil.Client(ctx).(*app.Client)
+
+	logging.Debug(ctx, "hdhomerun lineup request")
+
+	l, err := s.listing(ctx, client)
+	if err != nil {
+		logging.Error(ctx, err, "failed to get channels")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	setHeaders(w, jsonHeaders)
+	if err := json.NewEncoder(w).Encode(hdhomerun.Lineup(l.Channels)); err != nil {
+		logging.Error(ctx, err, "failed to write lineup")
+		return
+	}
+
+	metrics.IncListingDownload(ctx)
+}
+
+func (s *Server) handleDeviceXML(w http.ResponseWriter, r *http.Request) {
+	logging.Debug(r.Context(), "hdhomerun device request")
+
+	setHeaders(w, deviceXMLHeaders)
+	if err := s.hdhomerunDevice(r).WriteDeviceXML(w); err != nil {
+		logging.Error(r.Context(), err, "failed to write device xml")
+	}
+}
+
+func (s *Server) hdhomerunDevice(r *http.Request) hdhomerun.Device {
+	client := ctxutil.Client(r.Context()).(*app.Client)
+
+	tunerCount := client.ConcurrentStreams()
+	if tunerCount <= 0 {
+		tunerCount = defaultTunerCount
+	}
+
+	return hdhomerun.Device{
+		Name:       client.Name(),
+		BaseURL:    s.serverURL + "/" + mux.Vars(r)[muxClientSecretVar],
+		TunerCount: tunerCount,
+	}
+}
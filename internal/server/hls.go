@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"majmun/internal/app"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/streampool"
+	"majmun/internal/urlgen"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	hlsPlaylistFile     = "f.m3u8"
+	hlsContentType      = "application/vnd.apple.mpegurl"
+	hlsLeaseIDPrefix    = "hls-"
+	hlsSessionLength    = 16
+	hlsSessionBandwidth = 5000000
+	segmentContentType  = streamContentType
+)
+
+type hlsStream struct {
+	playlist    *app.Playlist
+	stream      urlgen.Stream
+	url         string
+	fallbacks   []string
+	key         string
+	upstreamKey string
+}
+
+func (s *Server) handleHLSPlaylist(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, offset time.Duration, session string) {
+	logging.Debug(ctx, "serving hls playlist")
+
+	client := ctxutil.Client(ctx).(*app.Client)
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+	leaseID := hlsLeaseID(client, session)
+	segmentQuery := joinQuery(r.URL.RawQuery, querySession+"="+session)
+
+	ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypePlaylist)
+	ctx = ctxutil.WithChannelName(ctx, data.StreamData.ChannelName)
+
+	streams := s.hlsStreams(ctx, client, data, r.URL.RawQuery)
+
+	for _, st := range streams {
+		if s.streamPool.TouchHLS(st.key, leaseID) {
+			s.writeHLSPlaylist(ctx, w, st.key, segmentQuery, offset)
+			return
+		}
+	}
+
+	release, ok := s.acquireSemaphores(ctx)
+	if !ok {
+		logging.Error(ctx, errors.New("failed to acquire semaphores"), "hls playlist failed")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	var hasLimitError bool
+
+	for i, st := range streams {
+		streamCtx := ctxutil.WithChannelHidden(ctx, st.stream.Hidden)
+		streamCtx = ctxutil.WithProviderType(streamCtx, metrics.RequestTypePlaylist)
+		streamCtx = ctxutil.WithProviderName(streamCtx, st.playlist.Name())
+
+		releaseViewer, ok := acquireLimits(streamCtx, st.playlist.QueueTimeout(), st.playlist.ViewerLimit())
+		if !ok {
+			hasLimitError = true
+			continue
+		}
+
+		streamReq := profileRequest(st.playlist, streampool.Request{
+			StreamKey:    st.upstreamKey,
+			StreamURL:    st.url,
+			Fallbacks:    st.fallbacks,
+			Semaphore:    st.playlist.Semaphore(),
+			Limits:       st.playlist.UpstreamLimits(),
+			QueueTimeout: st.playlist.QueueTimeout(),
+			Segmenter:    segmenterConfig(st.playlist.SegmenterConfig(), r.URL.RawQuery),
+			Mirrors:      st.playlist.Mirrors,
+		}, r.URL.RawQuery)
+
+		metrics.IncClientStreamsActive(streamCtx)
+		leaseRelease := func() {
+			metrics.DecClientStreamsActive(streamCtx)
+			releaseViewer()
+			release()
+		}
+
+		err := s.streamPool.OpenHLS(streamCtx, streamReq, leaseID, leaseRelease)
+		if err == nil {
+			logging.Debug(streamCtx, "opened hls stream", "stream_index", i)
+			s.writeHLSPlaylist(streamCtx, w, st.key, segmentQuery, offset)
+			return
+		}
+
+		metrics.DecClientStreamsActive(streamCtx)
+		releaseViewer()
+
+		if errors.Is(err, streampool.ErrSubscriptionSemaphore) {
+			s.handleSubscriptionError(streamCtx, i)
+			hasLimitError = true
+			continue
+		}
+		logging.Error(streamCtx, err, "failed to open hls stream", "stream_index", i)
+	}
+
+	release()
+
+	if hasLimitError {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+func (s *Server) handleHLSSegment(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, name, session string) {
+	client := ctxutil.Client(ctx).(*app.Client)
+	data := ctxutil.StreamData(ctx).(*urlgen.Data)
+	leaseID := hlsLeaseID(client, session)
+
+	for _, st := range s.hlsStreams(ctx, client, data, r.URL.RawQuery) {
+		segmentPath, ok := s.streamPool.SegmentPath(st.key, leaseID, name)
+		if !ok {
+			continue
+		}
+
+		f, err := os.Open(segmentPath)
+		if err != nil {
+			break
+		}
+		defer func() { _ = f.Close() }()
+
+		stat, err := f.Stat()
+		if err != nil {
+			break
+		}
+
+		w.Header().Set("Content-Type", segmentContentType)
+		http.ServeContent(w, r, name, stat.ModTime(), f)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+func (s *Server) hlsStreams(ctx context.Context, client *app.Client, data *urlgen.Data, rawQuery string) []hlsStream {
+	result := make([]hlsStream, 0, len(data.StreamData.Streams))
+
+	for i, stream := range data.StreamData.Streams {
+		provider := client.GetProvider(stream.ProviderInfo.ProviderType, stream.ProviderInfo.ProviderName)
+		playlist, ok := provider.(*app.Playlist)
+		if !ok || playlist == nil {
+			logging.Error(ctx, errors.New("playlist provider not found"), "stream_index", i)
+			continue
+		}
+
+		streamURL, ok := upstreamURL(stream, rawQuery)
+		if !ok {
+			continue
+		}
+
+		key := generateHash(buildStreamURL(stream.URL, rawQuery))
+		if isCatchupRequest(rawQuery) {
+			key = generateHash("catchup", stream.URL, rawQuery)
+		}
+
+		result = append(result, hlsStream{
+			playlist:    playlist,
+			stream:      stream,
+			url:         streamURL,
+			fallbacks:   streamFallbacks(stream, data.StreamData.Streams[i+1:], rawQuery),
+			key:         profileStreamKey(playlist, key),
+			upstreamKey: key,
+		})
+	}
+
+	return result
+}
+
+func hlsLeaseID(client *app.Client, session string) string {
+	return hlsLeaseIDPrefix + client.Name() + "-" + session
+}
+
+func newHLSSession() string {
+	b := make([]byte, hlsSessionLength/2)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validHLSSession(session string) bool {
+	if len(session) != hlsSessionLength {
+		return false
+	}
+	_, err := hex.DecodeString(session)
+	return err == nil
+}
+
+func writeHLSSessionPlaylist(w http.ResponseWriter, rawQuery string) {
+	w.Header().Set("Content-Type", hlsContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = fmt.Fprintf(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s?%s\n",
+		hlsSessionBandwidth, hlsPlaylistFile, joinQuery(rawQuery, querySession+"="+newHLSSession()))
+}
+
+func joinQuery(rawQuery, param string) string {
+	if rawQuery == "" {
+		return param
+	}
+	return rawQuery + "&" + param
+}
+
+func (s *Server) writeHLSPlaylist(
+	ctx context.Context, w http.ResponseWriter, streamKey, rawQuery string, offset time.Duration) {
+	data, err := s.streamPool.HLSPlaylist(streamKey)
+	if err != nil {
+		logging.Error(ctx, err, "failed to read hls playlist")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", hlsContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(rewriteHLSPlaylist(data, rawQuery, offset))
+}
+
+func rewriteHLSPlaylist(data []byte, rawQuery string, offset time.Duration) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			base, _, _ := strings.Cut(line, "?")
+			line = path.Base(base)
+			if rawQuery != "" {
+				line += "?" + rawQuery
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+
+		if line == "#EXTM3U" && offset > 0 {
+			fmt.Fprintf(&out, "#EXT-X-START:TIME-OFFSET=-%.3f\n", offset.Seconds())
+		}
+	}
+
+	return out.Bytes()
+}
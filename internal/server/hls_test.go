@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteHLSPlaylist(t *testing.T) {
+	playlist := "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXTINF:2.000,\n" +
+		"/tmp/majmun/abc/seg_1.ts\n" +
+		"\n" +
+		"#EXTINF:2.000,\n" +
+		"seg_2.ts?upstream=1\n"
+
+	tests := []struct {
+		name     string
+		rawQuery string
+		offset   time.Duration
+		expected string
+	}{
+		{
+			name: "no query",
+			expected: "#EXTM3U\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXTINF:2.000,\n" +
+				"seg_1.ts\n" +
+				"\n" +
+				"#EXTINF:2.000,\n" +
+				"seg_2.ts\n",
+		},
+		{
+			name:     "session query",
+			rawQuery: "utc=100&session=0123456789abcdef",
+			expected: "#EXTM3U\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXTINF:2.000,\n" +
+				"seg_1.ts?utc=100&session=0123456789abcdef\n" +
+				"\n" +
+				"#EXTINF:2.000,\n" +
+				"seg_2.ts?utc=100&session=0123456789abcdef\n",
+		},
+		{
+			name:     "offset",
+			rawQuery: "session=0123456789abcdef",
+			offset:   90 * time.Second,
+			expected: "#EXTM3U\n" +
+				"#EXT-X-START:TIME-OFFSET=-90.000\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXTINF:2.000,\n" +
+				"seg_1.ts?session=0123456789abcdef\n" +
+				"\n" +
+				"#EXTINF:2.000,\n" +
+				"seg_2.ts?session=0123456789abcdef\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rewriteHLSPlaylist([]byte(playlist), tt.rawQuery, tt.offset)
+			assert.Equal(t, tt.expected, string(got))
+		})
+	}
+}
+
+func TestValidHLSSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string
+		valid   bool
+	}{
+		{name: "valid", session: "0123456789abcdef", valid: true},
+		{name: "generated", session: newHLSSession(), valid: true},
+		{name: "empty", session: ""},
+		{name: "too short", session: "0123456789abcde"},
+		{name: "too long", session: "0123456789abcdef0"},
+		{name: "not hex", session: "0123456789abcdeg"},
+		{name: "path traversal", session: "../../etc/passwd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.valid, validHLSSession(tt.session))
+		})
+	}
+}
+
+func TestWriteHLSSessionPlaylist(t *testing.T) {
+	tests := []struct {
+		name      string
+		rawQuery  string
+		uriPrefix string
+	}{
+		{name: "no query", uriPrefix: "f.m3u8?session="},
+		{name: "keeps query", rawQuery: "offset=60&utc=100", uriPrefix: "f.m3u8?offset=60&utc=100&session="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeHLSSessionPlaylist(rec, tt.rawQuery)
+
+			assert.Equal(t, hlsContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+
+			m := regexp.MustCompile(`(?m)^#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=\d+\n(.+)\n$`).
+				FindStringSubmatch(rec.Body.String())
+			require.Len(t, m, 2, "unexpected playlist: %q", rec.Body.String())
+			require.Contains(t, m[1], tt.uriPrefix)
+
+			session, _ := cutQueryParam(m[1][len("f.m3u8?"):], querySession)
+			assert.True(t, validHLSSession(session), "invalid session %q", session)
+		})
+	}
+
+	first := httptest.NewRecorder()
+	second := httptest.NewRecorder()
+	writeHLSSessionPlaylist(first, "")
+	writeHLSSessionPlaylist(second, "")
+	assert.NotEqual(t, first.Body.String(), second.Body.String(), "expected a new session per playback")
+}
+
+func TestHLSSessionQueryFlow(t *testing.T) {
+	playlistQuery := "offset=60&utc=100&session=0123456789abcdef"
+
+	offset, rawQuery := cutOffset(playlistQuery)
+	session, rawQuery := cutQueryParam(rawQuery, querySession)
+	require.True(t, validHLSSession(session))
+	assert.Equal(t, time.Minute, offset)
+	assert.Equal(t, "utc=100", rawQuery)
+
+	got := rewriteHLSPlaylist([]byte("#EXTM3U\nseg_1.ts\n"), joinQuery(rawQuery, querySession+"="+session), offset)
+	assert.Equal(t,
+		"#EXTM3U\n#EXT-X-START:TIME-OFFSET=-60.000\nseg_1.ts?utc=100&session=0123456789abcdef\n", string(got))
+}
+
+func TestJoinQuery(t *testing.T) {
+	assert.Equal(t, "session=a", joinQuery("", "session=a"))
+	assert.Equal(t, "utc=1&session=a", joinQuery("utc=1", "session=a"))
+}
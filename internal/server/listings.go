@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"majmun/internal/app"
+	"majmun/internal/config"
+	"majmun/internal/ctxutil"
+	"majmun/internal/listing/cache"
+	"majmun/internal/logging"
+	"net/http"
+	"time"
+)
+
+func listingRefreshInterval(cfg *config.Config) time.Duration {
+	interval := time.Duration(cfg.Server.ListingRefresh)
+	for _, ttl := range []time.Duration{
+		time.Duration(cfg.URLGenerator.StreamTTL),
+		time.Duration(cfg.URLGenerator.FileTTL),
+	} {
+		if ttl > 0 && interval > ttl/2 {
+			interval = ttl / 2
+		}
+	}
+	return interval
+}
+
+func (s *Server) runListingRefresh() {
+	for {
+		s.refreshAllListings(s.ctx)
+		s.refreshPinned()
+		s.applyDVRRules()
+
+		timer := time.NewTimer(time.Duration(s.listingRefresh.Load()))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-s.refreshListings:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Server) invalidateListings() {
+	select {
+	case s.refreshListings <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) refreshAllListings(ctx context.Context) {
+	m, release := s.acquireManager()
+	if m == nil {
+		return
+	}
+	defer release()
+
+	clients := m.Clients()
+
+	for _, client := range clients {
+		if ctx.Err() != nil {
+			return
+		}
+
+		clientCtx := ctxutil.WithClient(ctx, client)
+		start := time.Now()
+
+		l, err := s.listingCache(client).Refresh(clientCtx)
+		if err != nil {
+			logging.Error(clientCtx, err, "failed to refresh listing")
+			continue
+		}
+
+		logging.Debug(clientCtx, "listing refreshed",
+			"channels", len(l.Channels), "duration", time.Since(start).Truncate(time.Millisecond))
+	}
+
+	if s.manager.Load() == m {
+		s.pruneListings(clients)
+	}
+}
+
+// listingCache returns the cache of client. Clients of a manager replaced by a
+// reload get a cache of their own that is not stored, so requests still in
+// flight during a reload do not evict the caches of the current clients.
+func (s *Server) listingCache(client *app.Client) *cache.Cache {
+	s.listingsMu.Lock()
+	c, ok := s.listings[client.Name()]
+	if ok && c.Client() == client {
+		s.listingsMu.Unlock()
+		return c
+	}
+
+	if s.manager.Load().ClientByName(client.Name()) != client {
+		s.listingsMu.Unlock()
+		return cache.New(client, nil)
+	}
+
+	replaced := c
+	c = cache.New(client, s.listingContents)
+	s.listings[client.Name()] = c
+	s.listingsMu.Unlock()
+
+	if replaced != nil {
+		replaced.Close()
+	}
+	return c
+}
+
+func (s *Server) pruneListings(clients []*app.Client) {
+	current := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		current[client.Name()] = true
+	}
+
+	var removed []*cache.Cache
+
+	s.listingsMu.Lock()
+	for name, c := range s.listings {
+		if !current[name] {
+			removed = append(removed, c)
+			delete(s.listings, name)
+		}
+	}
+	s.listingsMu.Unlock()
+
+	for _, c := range removed {
+		c.Close()
+	}
+}
+
+func (s *Server) listing(ctx context.Context, client *app.Client) (*cache.Listing, error) {
+	return s.listingCache(client).Get(ctx)
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request, headers responseHeaders, content *cache.Content) {
+	setHeaders(w, headers)
+	w.Header().Set("ETag", content.ETag)
+	http.ServeContent(w, r, "", content.ModTime, bytes.NewReader(content.Data))
+}
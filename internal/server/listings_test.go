@@ -0,0 +1,54 @@
+package server
+
+import (
+	"majmun/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) (*Server, *config.Config) {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cachePath := t.TempDir()
+	cfg.Proxy.HTTPClient.Cache.Path = &cachePath
+	cfg.URLGenerator.Secret = "secret"
+	cfg.Clients = []config.Client{{Name: "living-room", Secret: "living-room-secret"}}
+
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.cancel()
+		s.streamPool.Stop()
+		s.manager.Load().Close()
+	})
+	return s, cfg
+}
+
+func TestListingCache_OldClientDoesNotEvictCurrent(t *testing.T) {
+	s, cfg := newTestServer(t)
+
+	oldClient := s.manager.Load().ClientByName("living-room")
+	oldCache := s.listingCache(oldClient)
+	assert.Same(t, oldCache, s.listingCache(oldClient))
+
+	require.NoError(t, s.Reload(cfg))
+	newClient := s.manager.Load().ClientByName("living-room")
+	require.NotSame(t, oldClient, newClient)
+
+	current := s.listingCache(newClient)
+	assert.NotSame(t, oldCache, current)
+
+	for range 3 {
+		stale := s.listingCache(oldClient)
+		assert.NotSame(t, current, stale)
+		assert.Same(t, oldClient, stale.Client())
+		assert.Same(t, current, s.listingCache(newClient), "expected old client calls to keep the current cache")
+	}
+
+	s.listingsMu.Lock()
+	defer s.listingsMu.Unlock()
+	assert.Same(t, current, s.listings["living-room"])
+}
@@ -39,7 +39,14 @@ func (s *Server) clientAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		client := s.manager.Client(secret)
+		m, release := s.acquireManager()
+		if m == nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		client := m.Client(secret)
 		if client == nil {
 			logging.Debug(r.Context(), "authentication failed: invalid secret", "secret", secret)
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
@@ -60,9 +67,16 @@ func (s *Server) proxyAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		m, release := s.acquireManager()
+		if m == nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
 		ctx := r.Context()
 
-		for _, client := range s.manager.Clients() {
+		for _, client := range m.ClientsForToken(token) {
 			data, err := client.URLGenerator().Decrypt(token)
 
 			if err == nil && data != nil {
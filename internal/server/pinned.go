@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"majmun/internal/app"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/streampool"
+	"majmun/internal/utils"
+	"slices"
+)
+
+func (s *Server) refreshPinned() {
+	s.pinnedMu.Lock()
+	defer s.pinnedMu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	m, release := s.acquireManager()
+	if m == nil {
+		return
+	}
+	pinned := s.pinnedStreams(s.ctx, m)
+	release()
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	s.streamPool.SetPinned(pinned)
+	logging.Info(s.ctx, "pinned streams updated", "count", len(pinned))
+}
+
+// pinnedStreams collects the pinned channels of all clients. A channel pinned
+// by several clients runs once and counts against the limits of each of them.
+func (s *Server) pinnedStreams(ctx context.Context, m *app.Manager) []streampool.PinnedStream {
+	var result []streampool.PinnedStream
+	seen := make(map[string]int)
+
+	for _, client := range m.Clients() {
+		l, err := s.listing(ctx, client)
+		if err != nil {
+			logging.Error(ctx, err, "failed to get channels for pinned streams", "client", client.Name())
+			continue
+		}
+
+		for _, ch := range l.Channels {
+			if !ch.IsPinned() || len(ch.Streams()) == 0 {
+				continue
+			}
+
+			stream := ch.Streams()[0]
+			playlist, ok := client.GetProvider(stream.ProviderInfo.ProviderType, stream.ProviderInfo.ProviderName).(*app.Playlist)
+			if !ok || playlist == nil || !playlist.IsProxied() {
+				logging.Debug(ctx, "skipping pinned channel without proxy", "channel", ch.Name())
+				continue
+			}
+
+			key := buildStreamKey(stream.URL, "")
+			if i, ok := seen[key]; ok {
+				result[i].Limits = appendPinnedLimits(result[i], playlist)
+				continue
+			}
+			seen[key] = len(result)
+
+			result = append(result, streampool.PinnedStream{
+				StreamKey:    key,
+				StreamURL:    stream.URL,
+				Fallbacks:    streamFallbacks(stream, ch.Streams()[1:], ""),
+				ChannelName:  ch.Name(),
+				PlaylistName: playlist.Name(),
+				Semaphore:    playlist.Semaphore(),
+				Limits:       playlist.UpstreamLimits(),
+				Segmenter:    playlist.SegmenterConfig(),
+				Mirrors:      playlist.Mirrors,
+			})
+		}
+	}
+
+	return result
+}
+
+// appendPinnedLimits adds the limits of another client pinning the same
+// stream, skipping the ones the pinned stream already holds.
+func appendPinnedLimits(pinned streampool.PinnedStream, playlist *app.Playlist) []*utils.Limit {
+	limits := slices.Clone(pinned.Limits)
+	held := func(sem *utils.Semaphore) bool {
+		if sem == pinned.Semaphore {
+			return true
+		}
+		for _, limit := range limits {
+			if limit.Semaphore() == sem {
+				return true
+			}
+		}
+		return false
+	}
+
+	if sem := playlist.Semaphore(); sem != nil && !held(sem) {
+		limits = append(limits, utils.NewLimit(sem, metrics.LimitConcurrency, metrics.LimitScopePlaylist,
+			playlist.Name(), metrics.FailureReasonPlaylistLimit))
+	}
+	for _, limit := range playlist.UpstreamLimits() {
+		if !held(limit.Semaphore()) {
+			limits = append(limits, limit)
+		}
+	}
+
+	return limits
+}
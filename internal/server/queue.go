@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"majmun/internal/logging"
+	"majmun/internal/shell"
+	"net/http"
+	"sync"
+)
+
+type queuedResponseWriter struct {
+	http.ResponseWriter
+	streamer *shell.Streamer
+	stopped  bool
+	started  bool
+	header   http.Header
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+func newQueuedResponseWriter(w http.ResponseWriter, streamer *shell.Streamer) *queuedResponseWriter {
+	return &queuedResponseWriter{ResponseWriter: w, streamer: streamer}
+}
+
+func (q *queuedResponseWriter) start(ctx context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped || q.cancel != nil {
+		return
+	}
+
+	ctx, q.cancel = context.WithCancel(ctx)
+	q.done = make(chan struct{})
+	q.started = true
+	q.header = make(http.Header)
+	q.ResponseWriter.Header().Set("Content-Type", streamContentType)
+
+	logging.Debug(ctx, "streaming queue placeholder")
+
+	go func() {
+		defer close(q.done)
+		for ctx.Err() == nil {
+			if _, err := q.streamer.RunWithStdout(ctx, q.ResponseWriter); err != nil {
+				if ctx.Err() == nil {
+					logging.Error(ctx, err, "failed to stream queue placeholder")
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (q *queuedResponseWriter) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	cancel, done := q.cancel, q.done
+	q.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// placeholderStarted reports whether the placeholder began the response, so
+// the status and headers of the real stream can no longer be sent.
+func (q *queuedResponseWriter) placeholderStarted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.started
+}
+
+// Header does not stop the placeholder. Once it has started, the response
+// headers are owned by the placeholder and changes go to a detached map.
+func (q *queuedResponseWriter) Header() http.Header {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started {
+		return q.header
+	}
+	return q.ResponseWriter.Header()
+}
+
+func (q *queuedResponseWriter) Write(b []byte) (int, error) {
+	q.stop()
+	return q.ResponseWriter.Write(b)
+}
+
+func (q *queuedResponseWriter) WriteHeader(statusCode int) {
+	q.stop()
+	if q.placeholderStarted() {
+		return
+	}
+	q.ResponseWriter.WriteHeader(statusCode)
+}
+
+// streamError ends a stream response with statusCode. When a queue placeholder
+// already began the response, it is closed instead so no error text ends up
+// in the stream.
+func streamError(w http.ResponseWriter, statusCode int) {
+	if q, ok := w.(*queuedResponseWriter); ok && q.placeholderStarted() {
+		q.stop()
+		return
+	}
+	http.Error(w, http.StatusText(statusCode), statusCode)
+}
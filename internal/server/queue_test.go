@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"majmun/internal/shell"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingResponseWriter struct {
+	header http.Header
+	writes [][]byte
+	status int
+	mu     sync.Mutex
+}
+
+func newRecordingResponseWriter() *recordingResponseWriter {
+	return &recordingResponseWriter{header: make(http.Header)}
+}
+
+func (w *recordingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, bytes.Clone(b))
+	return len(b), nil
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = statusCode
+}
+
+func (w *recordingResponseWriter) body() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return bytes.Join(w.writes, nil)
+}
+
+func newTestPlaceholder(t *testing.T, script string) *shell.Streamer {
+	t.Helper()
+	streamer, err := shell.NewShellStreamer([]string{"sh", "-c", script}, nil, nil)
+	require.NoError(t, err)
+	return streamer
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueuedResponseWriter_PlaceholderStopsBeforeFirstWrite(t *testing.T) {
+	rec := newRecordingResponseWriter()
+	q := newQueuedResponseWriter(rec, newTestPlaceholder(t, "while :; do printf P; sleep 0.01; done"))
+
+	q.start(context.Background())
+	waitFor(t, func() bool { return len(rec.body()) > 0 })
+
+	_, err := q.Write([]byte("stream"))
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	body := rec.body()
+	require.True(t, bytes.HasSuffix(body, []byte("stream")), "unexpected body %q", body)
+	assert.Equal(t, bytes.Repeat([]byte("P"), len(body)-len("stream")), body[:len(body)-len("stream")],
+		"expected only placeholder bytes before the stream")
+	assert.Equal(t, streamContentType, rec.Header().Get("Content-Type"))
+
+	_, err = q.Write([]byte("more"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(rec.body(), []byte("streammore")))
+}
+
+func TestQueuedResponseWriter_StopAfterPlaceholderExited(t *testing.T) {
+	rec := newRecordingResponseWriter()
+	q := newQueuedResponseWriter(rec, newTestPlaceholder(t, "printf P; exit 1"))
+
+	q.start(context.Background())
+	<-q.done
+
+	q.stop()
+	q.stop()
+	q.WriteHeader(http.StatusOK)
+	_, err := q.Write([]byte("stream"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Pstream", string(rec.body()))
+	assert.Zero(t, rec.status, "expected status of the real stream to be dropped after the placeholder")
+}
+
+func TestQueuedResponseWriter_StartAfterStopIsNoop(t *testing.T) {
+	rec := newRecordingResponseWriter()
+	q := newQueuedResponseWriter(rec, newTestPlaceholder(t, "printf P"))
+
+	q.Header().Set("Content-Type", "video/mp2t")
+	q.WriteHeader(http.StatusOK)
+	q.start(context.Background())
+
+	assert.Nil(t, q.done)
+	assert.Equal(t, http.StatusOK, rec.status)
+	_, err := q.Write([]byte("stream"))
+	require.NoError(t, err)
+	assert.Equal(t, "stream", string(rec.body()))
+}
+
+func TestQueuedResponseWriter_HeaderKeepsPlaceholderRunning(t *testing.T) {
+	rec := newRecordingResponseWriter()
+	q := newQueuedResponseWriter(rec, newTestPlaceholder(t, "while :; do printf P; sleep 0.01; done"))
+	defer q.stop()
+
+	q.start(context.Background())
+	waitFor(t, func() bool { return len(rec.body()) > 0 })
+
+	q.Header().Set("Content-Type", "text/plain")
+	written := len(rec.body())
+	waitFor(t, func() bool { return len(rec.body()) > written })
+
+	assert.Equal(t, streamContentType, rec.Header().Get("Content-Type"))
+}
+
+func TestStreamError_ClosesStartedPlaceholder(t *testing.T) {
+	rec := newRecordingResponseWriter()
+	q := newQueuedResponseWriter(rec, newTestPlaceholder(t, "while :; do printf P; sleep 0.01; done"))
+
+	q.start(context.Background())
+	waitFor(t, func() bool { return len(rec.body()) > 0 })
+
+	streamError(q, http.StatusBadGateway)
+	body := rec.body()
+
+	assert.Equal(t, bytes.Repeat([]byte("P"), len(body)), body, "expected no error text in the stream")
+	assert.Zero(t, rec.status)
+	select {
+	case <-q.done:
+	default:
+		t.Error("expected placeholder to be stopped")
+	}
+}
+
+func TestStreamError_WithoutPlaceholder(t *testing.T) {
+	rec := newRecordingResponseWriter()
+	q := newQueuedResponseWriter(rec, newTestPlaceholder(t, "printf P"))
+
+	streamError(q, http.StatusBadGateway)
+
+	assert.Equal(t, http.StatusBadGateway, rec.status)
+	assert.Contains(t, string(rec.body()), http.StatusText(http.StatusBadGateway))
+}
@@ -7,50 +7,50 @@ import (
 	"majmun/internal/ctxutil"
 	"majmun/internal/metrics"
 	"majmun/internal/utils"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sync/semaphore"
 )
 
-const (
-	semaphoreTimeout = 3 * time.Second
-)
-
-func (s *Server) acquireSemaphores(ctx context.Context) bool {
+func (s *Server) acquireSemaphores(ctx context.Context) (func(), bool) {
 	c := ctxutil.Client(ctx).(*app.Client)
+	return acquireLimits(ctx, c.QueueTimeout(), c.ViewerLimits()...)
+}
 
+func acquireLimits(ctx context.Context, timeout time.Duration, limits ...*utils.Limit) (func(), bool) {
 	g, gCtx := errgroup.WithContext(ctx)
 
-	acquireSem := func(sem *semaphore.Weighted, reason string) func() error {
-		return func() error {
-			if sem == nil || utils.AcquireSemaphore(gCtx, sem, semaphoreTimeout, reason) {
-				return nil
-			}
-			metrics.IncStreamsFailures(gCtx, reason)
-			return fmt.Errorf("failed to acquire semaphore: %s", reason)
+	acquired := make([]atomic.Bool, len(limits))
+
+	for i, limit := range limits {
+		if limit == nil {
+			continue
 		}
+		g.Go(func() error {
+			if !limit.Acquire(gCtx, timeout) {
+				metrics.IncStreamsFailures(gCtx, limit.Reason)
+				return fmt.Errorf("failed to acquire semaphore: %s", limit.Reason)
+			}
+			acquired[i].Store(true)
+			return nil
+		})
 	}
 
-	if managerSem := s.manager.Semaphore(); managerSem != nil {
-		g.Go(acquireSem(managerSem, metrics.FailureReasonGlobalLimit))
-	}
+	err := g.Wait()
 
-	if clientSem := c.Semaphore(); clientSem != nil {
-		g.Go(acquireSem(clientSem, metrics.FailureReasonClientLimit))
+	release := func() {
+		for i, limit := range limits {
+			if acquired[i].Load() {
+				limit.Release()
+			}
+		}
 	}
 
-	return g.Wait() == nil
-}
-
-func (s *Server) releaseSemaphores(ctx context.Context) {
-	c := ctxutil.Client(ctx).(*app.Client)
-
-	if managerSem := s.manager.Semaphore(); managerSem != nil {
-		managerSem.Release(1)
+	if err != nil {
+		release()
+		return nil, false
 	}
 
-	if clientSem := c.Semaphore(); clientSem != nil {
-		clientSem.Release(1)
-	}
+	return release, true
 }
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"majmun/internal/app"
 	"majmun/internal/config"
+	"majmun/internal/dvr"
+	"majmun/internal/listing/cache"
 	"majmun/internal/logging"
 	"majmun/internal/metrics"
 	"majmun/internal/streampool"
+	"majmun/internal/xtream"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -18,18 +23,29 @@ import (
 const (
 	muxClientSecretVar   = "client_secret"
 	muxEncryptedTokenVar = "encrypted_token"
+	muxFileVar           = "file"
 )
 
 type Server struct {
 	router  *mux.Router
 	server  *http.Server
-	manager *app.Manager
+	manager atomic.Pointer[app.Manager]
 
 	streamPool *streampool.StreamPool
+	pinnedMu   sync.Mutex
+	dvr        *dvr.Recorder
+
+	listings        map[string]*cache.Cache
+	listingContents *cache.ContentPool
+	listingsMu      sync.Mutex
+	listingRefresh  atomic.Int64
+	refreshListings chan struct{}
+	xtreamIDs       *xtream.StreamIDs
 
 	serverURL     string
 	listenAddr    string
 	metricsServer *http.Server
+	adminServer   *http.Server
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -44,25 +60,53 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	server := &Server{
-		router:     mux.NewRouter(),
-		manager:    m,
-		streamPool: streampool.New(),
-		serverURL:  cfg.Server.PublicURL.String(),
-		listenAddr: cfg.Server.ListenAddr,
-		ctx:        ctx,
-		cancel:     cancel,
+		router:          mux.NewRouter(),
+		streamPool:      streampool.New(),
+		listings:        make(map[string]*cache.Cache),
+		listingContents: cache.NewContentPool(),
+		refreshListings: make(chan struct{}, 1),
+		xtreamIDs:       xtream.NewStreamIDs(),
+		serverURL:       cfg.Server.PublicURL.String(),
+		listenAddr:      cfg.Server.ListenAddr,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+	server.manager.Store(m)
+	server.listingRefresh.Store(int64(listingRefreshInterval(cfg)))
+
+	if dir := dvrDir(cfg); dir != "" {
+		recorder, err := dvr.New(dir, server.openRecording)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if err := recorder.Configure(cfg.DVR); err != nil {
+			cancel()
+			return nil, err
+		}
+		server.dvr = recorder
 	}
 
 	if cfg.Server.MetricsAddr != "" {
 		server.setupMetricsServer(cfg.Server.MetricsAddr)
 	}
 
+	if cfg.Server.AdminAddr != "" {
+		server.setupAdminServer(cfg.Server.AdminAddr, cfg.Server.AdminToken)
+	}
+
 	return server, nil
 }
 
 func (s *Server) Start() error {
 	s.setupRoutes()
 
+	go s.runListingRefresh()
+
+	if s.dvr != nil {
+		go s.dvr.Run(s.ctx)
+	}
+
 	if s.metricsServer != nil {
 		go func() {
 			logging.Info(s.ctx, "starting metrics server", "address", s.metricsServer.Addr)
@@ -72,6 +116,15 @@ func (s *Server) Start() error {
 		}()
 	}
 
+	if s.adminServer != nil {
+		go func() {
+			logging.Info(s.ctx, "starting admin server", "address", s.adminServer.Addr)
+			if err := s.adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Error(s.ctx, err, "admin server failed")
+			}
+		}()
+	}
+
 	s.server = &http.Server{
 		Addr:    s.listenAddr,
 		Handler: s.router,
@@ -88,6 +141,55 @@ func (s *Server) Start() error {
 	return nil
 }
 
+func (s *Server) Reload(cfg *config.Config) error {
+	current := s.manager.Load()
+
+	m, err := current.Reload(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.manager.Store(m)
+	current.Close()
+	s.listingRefresh.Store(int64(listingRefreshInterval(cfg)))
+	s.invalidateListings()
+
+	if s.dvr != nil {
+		if err := s.dvr.Configure(cfg.DVR); err != nil {
+			logging.Error(s.ctx, err, "failed to configure dvr")
+		}
+		if dvrDir(cfg) != s.dvr.Dir() {
+			logging.Info(s.ctx, "dvr.dir change requires restart", "dir", s.dvr.Dir())
+		}
+	}
+
+	if cfg.Server.ListenAddr != s.listenAddr {
+		logging.Info(s.ctx, "listen_addr change requires restart", "address", s.listenAddr)
+	}
+	if s.metricsServer != nil && cfg.Server.MetricsAddr != s.metricsServer.Addr {
+		logging.Info(s.ctx, "metrics_addr change requires restart", "address", s.metricsServer.Addr)
+	}
+	if s.adminServer != nil && cfg.Server.AdminAddr != s.adminServer.Addr {
+		logging.Info(s.ctx, "admin_addr change requires restart", "address", s.adminServer.Addr)
+	}
+
+	return nil
+}
+
+// acquireManager returns the current manager and keeps its cache store open
+// until release is called. It returns nil once the server has been stopped.
+func (s *Server) acquireManager() (m *app.Manager, release func()) {
+	for {
+		m = s.manager.Load()
+		if m.Acquire() {
+			return m, m.Release
+		}
+		if s.manager.Load() == m {
+			return nil, nil
+		}
+	}
+}
+
 func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -95,6 +197,7 @@ func (s *Server) Stop() error {
 	s.cancel()
 
 	s.streamPool.Stop()
+	s.manager.Load().Close()
 
 	logging.Info(ctx, "stopping http server")
 	if err := s.server.Shutdown(ctx); err != nil {
@@ -110,6 +213,14 @@ func (s *Server) Stop() error {
 		}
 	}
 
+	if s.adminServer != nil {
+		logging.Info(ctx, "stopping admin server")
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			logging.Error(ctx, err, "admin server shutdown timeout, force closing connections")
+			_ = s.adminServer.Close()
+		}
+	}
+
 	logging.Info(ctx, "server stopped")
 	return nil
 }
@@ -120,15 +231,23 @@ func (s *Server) setupRoutes() {
 	s.router.Use(s.requestIDMiddleware)
 	s.router.Use(s.loggerMiddleware)
 
+	s.setupXtreamRoutes()
+
 	clientRouter := s.router.PathPrefix("/{" + muxClientSecretVar + "}").Subrouter()
 	clientRouter.Use(s.clientAuthMiddleware)
 	clientRouter.HandleFunc("/playlist.m3u8", s.handlePlaylist)
 	clientRouter.HandleFunc("/epg.xml", s.handleEPG)
 	clientRouter.HandleFunc("/epg.xml.gz", s.handleEPGgz)
+	clientRouter.HandleFunc("/discover.json", s.handleDiscover)
+	clientRouter.HandleFunc("/lineup_status.json", s.handleLineupStatus)
+	clientRouter.HandleFunc("/lineup.json", s.handleLineup)
+	clientRouter.HandleFunc("/device.xml", s.handleDeviceXML)
+	clientRouter.HandleFunc("/recordings.m3u8", s.handleRecordingsPlaylist)
+	clientRouter.HandleFunc("/recordings/{"+muxFileVar+"}", s.handleRecordingFile)
 
 	proxyRouter := s.router.PathPrefix("/{" + muxEncryptedTokenVar + "}").Subrouter()
 	proxyRouter.Use(s.proxyAuthMiddleware)
-	proxyRouter.HandleFunc("/{.*}", s.handleProxy)
+	proxyRouter.HandleFunc("/{"+muxFileVar+"}", s.handleProxy)
 }
 
 func (s *Server) setupMetricsServer(addr string) {
@@ -16,10 +16,7 @@ import (
 	"majmun/internal/urlgen"
 )
 
-const (
-	maxRetryAttempts = 2
-	retryTimeout     = 3 * time.Second
-)
+const maxRetryAttempts = 2
 
 type streamResult struct {
 	success         bool
@@ -34,7 +31,7 @@ type allStreamsResult struct {
 	defaultProvider  *app.Playlist
 }
 
-func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r *http.Request, offset time.Duration) {
 	logging.Debug(ctx, "proxying stream")
 
 	client := ctxutil.Client(ctx).(*app.Client)
@@ -43,41 +40,45 @@ func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r
 	ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypePlaylist)
 	ctx = ctxutil.WithChannelName(ctx, data.StreamData.ChannelName)
 
-	if !s.acquireSemaphores(ctx) {
+	firstPlaylist := firstStreamPlaylist(client, data)
+
+	if firstPlaylist != nil && firstPlaylist.QueuedStreamer() != nil {
+		queued := newQueuedResponseWriter(w, firstPlaylist.QueuedStreamer())
+		defer queued.stop()
+
+		placeholderCtx := ctx
+		ctx = ctxutil.WithOnQueued(ctx, func() { queued.start(placeholderCtx) })
+		w = queued
+	}
+
+	release, ok := s.acquireSemaphores(ctx)
+	if !ok {
 		logging.Error(ctx, errors.New("failed to acquire semaphores"), "stream proxy failed")
-		if len(data.StreamData.Streams) > 0 {
-			firstProvider := client.GetProvider(
-				data.StreamData.Streams[0].ProviderInfo.ProviderType,
-				data.StreamData.Streams[0].ProviderInfo.ProviderName,
-			)
-			if playlist, ok := firstProvider.(*app.Playlist); ok && playlist != nil {
-				w.Header().Set("Content-Type", streamContentType)
-				_, err := playlist.LimitStreamer().RunWithStdout(ctx, w)
-				if err != nil {
-					logging.Error(ctx, err, "failed to stream limit response")
-				}
-				return
+		if firstPlaylist != nil {
+			w.Header().Set("Content-Type", streamContentType)
+			_, err := firstPlaylist.LimitStreamer().RunWithStdout(ctx, w)
+			if err != nil {
+				logging.Error(ctx, err, "failed to stream limit response")
 			}
+			return
 		}
-		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		streamError(w, http.StatusServiceUnavailable)
 		return
 	}
-	defer s.releaseSemaphores(ctx)
+	defer release()
 
 	var lastResult allStreamsResult
 
 	for attempt := 0; attempt < maxRetryAttempts; attempt++ {
-		if attempt > 0 && lastResult.hasLimitError {
-			logging.Debug(ctx, "sleeping before retry attempt")
-			time.Sleep(retryTimeout)
-		}
-
-		result := s.tryAllStreams(ctx, w, r, client, data)
+		result := s.tryAllStreams(ctx, w, r, client, data, offset)
 		if result.success {
 			return
 		}
 
 		lastResult = result
+		if result.hasLimitError || ctx.Err() != nil {
+			break
+		}
 	}
 
 	if lastResult.hasLimitError && lastResult.defaultProvider != nil {
@@ -98,11 +99,24 @@ func (s *Server) handleStreamProxy(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	streamError(w, http.StatusBadGateway)
+}
+
+func firstStreamPlaylist(client *app.Client, data *urlgen.Data) *app.Playlist {
+	if len(data.StreamData.Streams) == 0 {
+		return nil
+	}
+	provider := client.GetProvider(
+		data.StreamData.Streams[0].ProviderInfo.ProviderType,
+		data.StreamData.Streams[0].ProviderInfo.ProviderName,
+	)
+	playlist, _ := provider.(*app.Playlist)
+	return playlist
 }
 
 func (s *Server) tryAllStreams(
-	ctx context.Context, w http.ResponseWriter, r *http.Request, client *app.Client, data *urlgen.Data) allStreamsResult {
+	ctx context.Context, w http.ResponseWriter, r *http.Request,
+	client *app.Client, data *urlgen.Data, offset time.Duration) allStreamsResult {
 	var hasLimitError bool
 	var hasUpstreamError bool
 	var firstProvider *app.Playlist
@@ -128,7 +142,8 @@ func (s *Server) tryAllStreams(
 			firstProvider = playlist
 		}
 
-		result := s.tryStream(ctx, w, r, playlist, stream, i)
+		fallbacks := streamFallbacks(stream, data.StreamData.Streams[i+1:], r.URL.RawQuery)
+		result := s.tryStream(ctx, w, r, playlist, stream, fallbacks, i, offset)
 
 		if result.success {
 			return allStreamsResult{true, false, false, nil}
@@ -148,22 +163,37 @@ func (s *Server) tryAllStreams(
 func (s *Server) tryStream(
 	ctx context.Context,
 	w http.ResponseWriter, r *http.Request,
-	playlist *app.Playlist, stream urlgen.Stream, streamIndex int) streamResult {
+	playlist *app.Playlist, stream urlgen.Stream, fallbacks []string, streamIndex int, offset time.Duration) streamResult {
 
 	ctx = ctxutil.WithChannelHidden(ctx, stream.Hidden)
 	ctx = ctxutil.WithProviderType(ctx, metrics.RequestTypePlaylist)
 	ctx = ctxutil.WithProviderName(ctx, playlist.Name())
 
-	streamURL := buildStreamURL(stream.URL, r.URL.RawQuery)
+	streamURL, ok := upstreamURL(stream, r.URL.RawQuery)
+	if !ok {
+		logging.Debug(ctx, "stream source has no catchup", "stream_index", streamIndex)
+		return streamResult{false, false, false}
+	}
 	streamKey := buildStreamKey(stream.URL, r.URL.RawQuery)
 
-	streamReq := streampool.Request{
+	releaseViewer, ok := acquireLimits(ctx, playlist.QueueTimeout(), playlist.ViewerLimit())
+	if !ok {
+		return streamResult{false, true, false}
+	}
+	defer releaseViewer()
+
+	streamReq := profileRequest(playlist, streampool.Request{
 		StreamKey:      streamKey,
 		StreamURL:      streamURL,
+		Fallbacks:      fallbacks,
 		ClientStreamer: playlist.ClientStreamer,
 		Semaphore:      playlist.Semaphore(),
-		Segmenter:      playlist.SegmenterConfig(),
-	}
+		Limits:         playlist.UpstreamLimits(),
+		QueueTimeout:   playlist.QueueTimeout(),
+		Segmenter:      segmenterConfig(playlist.SegmenterConfig(), r.URL.RawQuery),
+		Offset:         offset,
+		Mirrors:        playlist.Mirrors,
+	}, r.URL.RawQuery)
 
 	reader, err := s.streamPool.GetReader(ctx, streamReq)
 	if errors.Is(err, streampool.ErrSubscriptionSemaphore) {
@@ -177,19 +207,17 @@ func (s *Server) tryStream(
 	defer func() { _ = reader.Close() }()
 
 	logging.Debug(ctx, "started stream", "stream_index", streamIndex)
-	return s.streamToResponse(ctx, w, reader)
+	return s.streamToResponse(ctx, w, playlist, reader)
 }
 
 func (s *Server) handleSubscriptionError(ctx context.Context, streamIndex int) {
 	logging.Error(
 		ctx, streampool.ErrSubscriptionSemaphore,
 		"failed to get stream - subscription semaphore", "stream_index", streamIndex)
-
-	metrics.IncStreamsFailures(ctx, metrics.FailureReasonPlaylistLimit)
 }
 
 func (s *Server) streamToResponse(
-	ctx context.Context, w http.ResponseWriter, reader io.ReadCloser) streamResult {
+	ctx context.Context, w http.ResponseWriter, playlist *app.Playlist, reader io.ReadCloser) streamResult {
 
 	metrics.IncClientStreamsActive(ctx)
 	defer metrics.DecClientStreamsActive(ctx)
@@ -203,6 +231,15 @@ func (s *Server) streamToResponse(
 		return streamResult{false, false, true}
 	}
 
+	if errors.Is(err, streampool.ErrPreempted) {
+		logging.Info(ctx, "stream preempted by a higher priority client")
+		metrics.IncStreamsFailures(ctx, metrics.FailureReasonPreempted)
+		if _, err := playlist.PreemptedStreamer().RunWithStdout(ctx, w); err != nil {
+			logging.Error(ctx, err, "failed to stream preempted response")
+		}
+		return streamResult{true, false, false}
+	}
+
 	if err != nil && !isClientDisconnect(err) {
 		logging.Error(ctx, err, "error copying stream to response")
 	}
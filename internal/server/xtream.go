@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"majmun/internal/app"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/xtream"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	muxXtreamUsernameVar = "username"
+	muxXtreamPasswordVar = "password"
+	muxXtreamStreamIDVar = "stream_id"
+	muxXtreamExtVar      = "ext"
+)
+
+func (s *Server) setupXtreamRoutes() {
+	xtreamRouter := s.router.NewRoute().Subrouter()
+	xtreamRouter.Use(s.xtreamAuthMiddleware)
+	xtreamRouter.HandleFunc("/player_api.php", s.handleXtreamPlayerAPI)
+	xtreamRouter.HandleFunc("/get.php", s.handlePlaylist)
+	xtreamRouter.HandleFunc("/xmltv.php", s.handleEPG)
+	xtreamRouter.HandleFunc(
+		"/live/{"+muxXtreamUsernameVar+"}/{"+muxXtreamPasswordVar+"}/{"+muxXtreamStreamIDVar+":[0-9]+}.{"+muxXtreamExtVar+":ts|m3u8}",
+		s.handleXtreamLive,
+	)
+}
+
+func (s *Server) xtreamAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password := xtreamCredentials(r)
+		if username == "" || password == "" {
+			logging.Debug(r.Context(), "authentication failed: no credentials")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		m, release := s.acquireManager()
+		if m == nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		client := m.ClientByCredentials(username, password)
+		if client == nil {
+			logging.Debug(r.Context(), "authentication failed: invalid credentials", "username", username)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		ctx := ctxutil.WithClient(r.Context(), client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) handleXtreamPlayerAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	client := ctxutil.Client(ctx).(*app.Client)
+	action := r.URL.Query().Get("action")
+
+	logging.Debug(ctx, "xtream api request", "action", action)
+
+	switch action {
+	case "":
+		publicURL, err := url.Parse(s.serverURL)
+		if err != nil {
+			logging.Error(ctx, err, "failed to parse public url")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		username, password := xtreamCredentials(r)
+		writeJSON(w, http.StatusOK, xtream.NewAccountInfo(
+			username, password, client.ConcurrentStreams(), publicURL, time.Now()))
+
+	case xtream.ActionLiveCategories:
+		catalog, err := s.xtreamCatalog(ctx, client)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, catalog.Categories())
+
+	case xtream.ActionLiveStreams:
+		ctx = ctxutil.WithRequestType(ctx, metrics.RequestTypePlaylist)
+		catalog, err := s.xtreamCatalog(ctx, client)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, catalog.Streams(r.URL.Query().Get("category_id")))
+		metrics.IncListingDownload(ctx)
+
+	case xtream.ActionVODCategories, xtream.ActionVODStreams, xtream.ActionSeriesCategories, xtream.ActionSeries:
+		writeJSON(w, http.StatusOK, []any{})
+
+	default:
+		logging.Debug(ctx, "unsupported xtream action", "action", action)
+		writeJSON(w, http.StatusOK, []any{})
+	}
+}
+
+func (s *Server) handleXtreamLive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	client := ctxutil.Client(ctx).(*app.Client)
+
+	streamID, err := strconv.Atoi(mux.Vars(r)[muxXtreamStreamIDVar])
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	logging.Debug(ctx, "xtream live request", "stream_id", streamID)
+
+	catalog, err := s.xtreamCatalog(ctx, client)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	streamURL, ok := catalog.StreamURL(streamID)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, xtreamStreamURL(streamURL, mux.Vars(r)[muxXtreamExtVar]).String(), http.StatusFound)
+}
+
+// xtreamStreamURL points a proxied stream link at the format asked for by
+// the extension. Links to unproxied upstreams are returned as they are.
+func xtreamStreamURL(u *url.URL, ext string) *url.URL {
+	dir, file := path.Split(u.Path)
+	if file != "f.ts" && file != hlsPlaylistFile {
+		return u
+	}
+
+	result := *u
+	result.Path = dir + "f." + ext
+	result.RawPath = ""
+	return &result
+}
+
+func (s *Server) xtreamCatalog(ctx context.Context, client *app.Client) (*xtream.Catalog, error) {
+	l, err := s.listing(ctx, client)
+	if err != nil {
+		logging.Error(ctx, err, "failed to get channels")
+		return nil, err
+	}
+
+	return xtream.NewCatalog(l.Channels, s.xtreamIDs), nil
+}
+
+func xtreamCredentials(r *http.Request) (string, string) {
+	vars := mux.Vars(r)
+	if username, ok := vars[muxXtreamUsernameVar]; ok {
+		return username, vars[muxXtreamPasswordVar]
+	}
+
+	query := r.URL.Query()
+	return query.Get("username"), query.Get("password")
+}
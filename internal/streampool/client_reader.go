@@ -1,17 +1,40 @@
 package streampool
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type clientReader struct {
 	*clientStream
-	seg  *segmenter
-	once sync.Once
+	seg         *segmenter
+	id          string
+	clientName  string
+	priority    int
+	connectedAt time.Time
+	preempted   atomic.Bool
+	once        sync.Once
+}
+
+func (cr *clientReader) Read(p []byte) (int, error) {
+	n, err := cr.clientStream.Read(p)
+	if err != nil && cr.preempted.Load() {
+		err = ErrPreempted
+	}
+	return n, err
+}
+
+func (cr *clientReader) preempt() {
+	cr.preempted.Store(true)
+	_ = cr.Close()
 }
 
 func (cr *clientReader) Close() error {
 	var err error
 	cr.once.Do(func() {
 		err = cr.clientStream.Close()
+		cr.seg.unregisterReader(cr)
 		cr.seg.removeClient()
 	})
 	return err
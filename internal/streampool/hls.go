@@ -0,0 +1,193 @@
+package streampool
+
+import (
+	"context"
+	"errors"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHLSIdleTimeout = 30 * time.Second
+	minLeaseCheckInterval = 100 * time.Millisecond
+)
+
+var (
+	ErrStreamNotFound = errors.New("stream not found")
+
+	segmentNamePattern = regexp.MustCompile(`^seg_[0-9]+\.ts$`)
+)
+
+type hlsLease struct {
+	id          string
+	clientName  string
+	priority    int
+	connectedAt time.Time
+	lastSeen    atomic.Int64
+	release     func()
+	done        chan struct{}
+	closed      atomic.Bool
+}
+
+func (l *hlsLease) touch() {
+	l.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (l *hlsLease) idleFor() time.Duration {
+	return time.Since(time.Unix(0, l.lastSeen.Load()))
+}
+
+func (l *hlsLease) close() {
+	if l.closed.CompareAndSwap(false, true) {
+		close(l.done)
+	}
+}
+
+func (d *StreamPool) TouchHLS(streamKey, leaseID string) bool {
+	seg := d.pool.get(streamKey)
+	if seg == nil {
+		return false
+	}
+
+	l := seg.lease(leaseID)
+	if l == nil {
+		return false
+	}
+
+	l.touch()
+	return true
+}
+
+func (d *StreamPool) OpenHLS(ctx context.Context, req Request, leaseID string, release func()) error {
+	clientCtx := ctxutil.WithStreamID(ctx, req.StreamKey)
+
+	seg, err := d.acquireSegmenter(clientCtx, req)
+	if err != nil {
+		return err
+	}
+
+	l := &hlsLease{
+		id:          leaseID,
+		clientName:  ctxutil.ClientName(clientCtx),
+		priority:    ctxutil.Priority(clientCtx),
+		connectedAt: time.Now(),
+		release:     release,
+		done:        make(chan struct{}),
+	}
+	l.touch()
+
+	if !seg.addLease(l) {
+		seg.removeClient()
+		release()
+		return nil
+	}
+
+	idleTimeout := defaultHLSIdleTimeout
+	if req.Segmenter.HLSIdleTimeout != nil {
+		idleTimeout = time.Duration(*req.Segmenter.HLSIdleTimeout)
+	}
+
+	go seg.watchLease(context.WithoutCancel(clientCtx), l, idleTimeout)
+
+	return nil
+}
+
+func (d *StreamPool) HLSPlaylist(streamKey string) ([]byte, error) {
+	seg := d.pool.get(streamKey)
+	if seg == nil {
+		return nil, ErrStreamNotFound
+	}
+	return os.ReadFile(seg.playlistPath)
+}
+
+func (d *StreamPool) SegmentPath(streamKey, leaseID, name string) (string, bool) {
+	if !segmentNamePattern.MatchString(name) {
+		return "", false
+	}
+
+	seg := d.pool.get(streamKey)
+	if seg == nil {
+		return "", false
+	}
+
+	l := seg.lease(leaseID)
+	if l == nil {
+		return "", false
+	}
+	l.touch()
+
+	path := filepath.Join(seg.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+func IsSegmentName(name string) bool {
+	return segmentNamePattern.MatchString(name)
+}
+
+func (s *segmenter) addLease(l *hlsLease) bool {
+	s.leasesMu.Lock()
+	defer s.leasesMu.Unlock()
+
+	if existing, ok := s.leases[l.id]; ok {
+		existing.touch()
+		return false
+	}
+	s.leases[l.id] = l
+	return true
+}
+
+func (s *segmenter) lease(id string) *hlsLease {
+	s.leasesMu.Lock()
+	defer s.leasesMu.Unlock()
+	return s.leases[id]
+}
+
+func (s *segmenter) allLeases() []*hlsLease {
+	s.leasesMu.Lock()
+	defer s.leasesMu.Unlock()
+
+	result := make([]*hlsLease, 0, len(s.leases))
+	for _, l := range s.leases {
+		result = append(result, l)
+	}
+	return result
+}
+
+func (s *segmenter) watchLease(ctx context.Context, l *hlsLease, idleTimeout time.Duration) {
+	interval := max(idleTimeout/4, minLeaseCheckInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	defer func() {
+		s.leasesMu.Lock()
+		if s.leases[l.id] == l {
+			delete(s.leases, l.id)
+		}
+		s.leasesMu.Unlock()
+
+		l.release()
+		s.removeClient()
+		logging.Debug(ctx, "hls lease released", "lease", l.id)
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if l.idleFor() > idleTimeout {
+				return
+			}
+		}
+	}
+}
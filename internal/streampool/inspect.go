@@ -0,0 +1,119 @@
+package streampool
+
+import (
+	"sort"
+	"time"
+)
+
+type SegmenterInfo struct {
+	StreamKey    string
+	ChannelName  string
+	PlaylistName string
+	Profile      string
+	ClientCount  int64
+	Lingering    bool
+	Pinned       bool
+	StartedAt    time.Time
+	Dir          string
+}
+
+type ClientInfo struct {
+	ID          string
+	ClientName  string
+	ConnectedAt time.Time
+}
+
+func (d *StreamPool) Segmenters() []SegmenterInfo {
+	segmenters := d.pool.all()
+
+	result := make([]SegmenterInfo, 0, len(segmenters))
+	for _, seg := range segmenters {
+		result = append(result, SegmenterInfo{
+			StreamKey:    seg.streamKey,
+			ChannelName:  seg.channelName,
+			PlaylistName: seg.playlistName,
+			Profile:      seg.profile,
+			ClientCount:  seg.clientCount.Load(),
+			Lingering:    seg.lingering(),
+			Pinned:       d.Pinned(seg.streamKey),
+			StartedAt:    seg.startedAt,
+			Dir:          seg.dir,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+
+	return result
+}
+
+func (d *StreamPool) Clients(streamKey string) ([]ClientInfo, bool) {
+	seg := d.pool.get(streamKey)
+	if seg == nil {
+		return nil, false
+	}
+
+	readers := seg.allReaders()
+	leases := seg.allLeases()
+
+	result := make([]ClientInfo, 0, len(readers)+len(leases))
+	for _, cr := range readers {
+		result = append(result, ClientInfo{
+			ID:          cr.id,
+			ClientName:  cr.clientName,
+			ConnectedAt: cr.connectedAt,
+		})
+	}
+	for _, l := range leases {
+		result = append(result, ClientInfo{
+			ID:          l.id,
+			ClientName:  l.clientName,
+			ConnectedAt: l.connectedAt,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ConnectedAt.Before(result[j].ConnectedAt)
+	})
+
+	return result, true
+}
+
+func (d *StreamPool) StopSegmenter(streamKey string) bool {
+	seg := d.pool.get(streamKey)
+	if seg == nil {
+		return false
+	}
+
+	d.stopSegmenter(seg)
+	return true
+}
+
+func (d *StreamPool) stopSegmenter(seg *segmenter) {
+	for _, cr := range seg.allReaders() {
+		_ = cr.Close()
+	}
+
+	d.pool.removeSegmenter(seg)
+}
+
+func (d *StreamPool) DisconnectClient(streamKey, clientID string) bool {
+	seg := d.pool.get(streamKey)
+	if seg == nil {
+		return false
+	}
+
+	if l := seg.lease(clientID); l != nil {
+		l.close()
+		return true
+	}
+
+	cr := seg.reader(clientID)
+	if cr == nil {
+		return false
+	}
+
+	_ = cr.Close()
+	return true
+}
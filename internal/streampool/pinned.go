@@ -0,0 +1,137 @@
+package streampool
+
+import (
+	"context"
+	"majmun/internal/config/proxy"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"majmun/internal/utils"
+	"time"
+)
+
+const (
+	minPinnedBackoff = time.Second
+	maxPinnedBackoff = time.Minute
+)
+
+type PinnedStream struct {
+	StreamKey    string
+	StreamURL    string
+	Fallbacks    []string
+	ChannelName  string
+	PlaylistName string
+	Semaphore    *utils.Semaphore
+	Limits       []*utils.Limit
+	Segmenter    proxy.Segmenter
+	Mirrors      MirrorsFunc
+}
+
+type pinnedStream struct {
+	stream PinnedStream
+	cancel context.CancelFunc
+}
+
+func (d *StreamPool) SetPinned(streams []PinnedStream) {
+	d.pinnedMu.Lock()
+	defer d.pinnedMu.Unlock()
+
+	if d.pinnedStopped {
+		return
+	}
+
+	wanted := make(map[string]PinnedStream, len(streams))
+	for _, st := range streams {
+		wanted[st.StreamKey] = st
+	}
+
+	for key, p := range d.pinned {
+		if st, ok := wanted[key]; ok && st.StreamURL == p.stream.StreamURL {
+			continue
+		}
+		p.cancel()
+		delete(d.pinned, key)
+	}
+
+	for key, st := range wanted {
+		if _, ok := d.pinned[key]; ok {
+			continue
+		}
+
+		ctx := ctxutil.WithChannelName(context.Background(), st.ChannelName)
+		ctx = ctxutil.WithProviderType(ctx, metrics.RequestTypePlaylist)
+		ctx = ctxutil.WithProviderName(ctx, st.PlaylistName)
+		ctx = ctxutil.WithStreamID(ctx, st.StreamKey)
+		ctx, cancel := context.WithCancel(ctx)
+
+		d.pinned[key] = &pinnedStream{stream: st, cancel: cancel}
+		go d.supervisePinned(ctx, st)
+	}
+}
+
+func (d *StreamPool) stopPinned() {
+	d.pinnedMu.Lock()
+	defer d.pinnedMu.Unlock()
+
+	for key, p := range d.pinned {
+		p.cancel()
+		delete(d.pinned, key)
+	}
+	d.pinnedStopped = true
+}
+
+func (d *StreamPool) Pinned(streamKey string) bool {
+	d.pinnedMu.Lock()
+	defer d.pinnedMu.Unlock()
+	_, ok := d.pinned[streamKey]
+	return ok
+}
+
+func (d *StreamPool) supervisePinned(ctx context.Context, st PinnedStream) {
+	req := Request{
+		StreamKey: st.StreamKey,
+		StreamURL: st.StreamURL,
+		Fallbacks: st.Fallbacks,
+		Semaphore: st.Semaphore,
+		Limits:    st.Limits,
+		Segmenter: st.Segmenter,
+		Mirrors:   st.Mirrors,
+	}
+
+	logging.Info(ctx, "pinning stream")
+
+	backoff := minPinnedBackoff
+
+	for {
+		seg, err := d.acquireSegmenter(ctx, req)
+		if err == nil {
+			startedAt := time.Now()
+
+			select {
+			case <-ctx.Done():
+				seg.removeClient()
+				logging.Info(ctx, "unpinned stream")
+				return
+			case <-seg.exited:
+			}
+
+			d.stopSegmenter(seg)
+			seg.removeClient()
+
+			if time.Since(startedAt) > maxPinnedBackoff {
+				backoff = minPinnedBackoff
+			}
+			logging.Info(ctx, "pinned segmenter exited, restarting", "backoff", backoff)
+		} else if ctx.Err() == nil {
+			logging.Error(ctx, err, "failed to start pinned stream", "backoff", backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxPinnedBackoff)
+	}
+}
@@ -4,15 +4,29 @@ import (
 	"context"
 	"fmt"
 	"majmun/internal/config/proxy"
+	"majmun/internal/ctxutil"
+	"majmun/internal/logging"
+	"majmun/internal/metrics"
 	"majmun/internal/shell"
+	"majmun/internal/utils"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const segmentReadyPoll = 100 * time.Millisecond
+const (
+	segmentReadyPoll       = 100 * time.Millisecond
+	defaultSegmentDuration = 2 * time.Second
+	defaultLiveStartIndex  = -3
+	maxStallPoll           = time.Second
+	maxSourceRestarts      = 3
+	restartBackoff         = 500 * time.Millisecond
+)
 
 type segmenter struct {
 	streamKey    string
@@ -20,17 +34,41 @@ type segmenter struct {
 	playlistPath string
 	config       proxy.Segmenter
 	streamer     *shell.Streamer
+	sources      []string
+	upstream     *segmenter
+	profile      string
+
+	channelName  string
+	playlistName string
+	startedAt    time.Time
+
+	readers   map[string]*clientReader
+	readersMu sync.Mutex
+
+	leases   map[string]*hlsLease
+	leasesMu sync.Mutex
+
+	dependents   map[*segmenter]struct{}
+	dependentsMu sync.Mutex
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	semaphore      *utils.Semaphore
+	limits         []*utils.Limit
+	holdsSemaphore atomic.Bool
+
 	clientCount atomic.Int64
+	emptyAt     atomic.Int64
 	emptyChan   chan struct{}
-	emptyOnce   sync.Once
+	emptyClosed bool
+	emptyMu     sync.Mutex
 
 	ready     chan struct{}
 	readyOnce sync.Once
 	startErr  error
+
+	exited chan struct{}
 }
 
 func newSegmenter(parentCtx context.Context, streamKey string, baseDir string, cfg proxy.Segmenter, streamURL string) (*segmenter, error) {
@@ -46,11 +84,14 @@ func newSegmenter(parentCtx context.Context, streamKey string, baseDir string, c
 		return nil, fmt.Errorf("parse segmenter command: %w", err)
 	}
 
-	streamer := base.WithTemplateVars(map[string]any{
-		"url":           streamURL,
+	vars := map[string]any{
 		"segment_path":  filepath.Join(dir, "seg_%05d.ts"),
 		"playlist_path": playlistPath,
-	})
+	}
+	if n := timeshiftSegments(cfg); n > 0 {
+		vars["max_segments"] = n
+	}
+	streamer := base.WithTemplateVars(vars)
 
 	ctx, cancel := context.WithCancel(parentCtx)
 
@@ -60,10 +101,18 @@ func newSegmenter(parentCtx context.Context, streamKey string, baseDir string, c
 		playlistPath: playlistPath,
 		config:       cfg,
 		streamer:     streamer,
+		sources:      []string{streamURL},
+		channelName:  ctxutil.ChannelName(parentCtx),
+		playlistName: ctxutil.ProviderName(parentCtx),
+		startedAt:    time.Now(),
+		readers:      make(map[string]*clientReader),
+		leases:       make(map[string]*hlsLease),
+		dependents:   make(map[*segmenter]struct{}),
 		ctx:          ctx,
 		cancel:       cancel,
 		emptyChan:    make(chan struct{}),
 		ready:        make(chan struct{}),
+		exited:       make(chan struct{}),
 	}
 
 	s.clientCount.Store(1)
@@ -74,12 +123,274 @@ func newSegmenter(parentCtx context.Context, streamKey string, baseDir string, c
 func (s *segmenter) start(ctx context.Context) {
 	go s.waitForSegments()
 
-	err := s.streamer.Run(ctx)
-	if err != nil && ctx.Err() == nil {
-		s.setReady(fmt.Errorf("segmenter process: %w", err))
-	} else {
-		s.setReady(nil)
+	source := 0
+	failures := make([]int, len(s.sources))
+
+	for run := 0; ; run++ {
+		runCtx, runCancel := context.WithCancel(ctx)
+
+		var stalled atomic.Bool
+		go func() {
+			if s.watchStall(runCtx) {
+				stalled.Store(true)
+				runCancel()
+			}
+		}()
+
+		lastWrite := s.lastSegmentWrite()
+		err := s.streamerFor(source, run > 0).Run(runCtx)
+		runCancel()
+
+		if ctx.Err() != nil {
+			s.setReady(nil)
+			return
+		}
+
+		if !stalled.Load() && (len(s.sources) == 1 || !s.isReady()) {
+			if err != nil {
+				s.setReady(fmt.Errorf("segmenter process: %w", err))
+			} else {
+				s.setReady(nil)
+			}
+			return
+		}
+
+		if s.lastSegmentWrite().Equal(lastWrite) {
+			failures[source]++
+		} else {
+			clear(failures)
+		}
+
+		next, ok := nextSource(source, failures)
+		if !ok {
+			logging.Error(ctx, err, "all segmenter sources failed")
+			return
+		}
+
+		if next == source {
+			if !sleepCtx(ctx, restartDelay(failures[source])) {
+				s.setReady(nil)
+				return
+			}
+			logging.Info(ctx, "segmenter stalled, restarting", "stall_timeout", s.stallTimeout())
+			metrics.IncSegmenterRestarts(ctx, metrics.RestartReasonStall)
+			continue
+		}
+
+		source = next
+		logging.Info(ctx, "switching segmenter to next source", "source_index", source, "stalled", stalled.Load())
+		metrics.IncSegmenterRestarts(ctx, metrics.RestartReasonFailover)
+	}
+}
+
+// nextSource picks the source to run after current, skipping sources that
+// restarted maxSourceRestarts times in a row without producing a segment.
+func nextSource(current int, failures []int) (int, bool) {
+	for i := 1; i <= len(failures); i++ {
+		next := (current + i) % len(failures)
+		if failures[next] < maxSourceRestarts {
+			return next, true
+		}
+	}
+	return 0, false
+}
+
+// restartDelay backs off repeated restarts of a source that keeps failing
+// before its first segment.
+func restartDelay(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	return restartBackoff << (failures - 1)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *segmenter) streamerFor(source int, restarted bool) *shell.Streamer {
+	return s.streamer.WithTemplateVars(map[string]any{
+		"url":       s.sources[source],
+		"restarted": restarted,
+	})
+}
+
+func (s *segmenter) setUpstream(upstream *segmenter, profile string) {
+	s.upstream = upstream
+	s.profile = profile
+	s.sources = []string{upstream.playlistPath}
+
+	upstream.dependentsMu.Lock()
+	upstream.dependents[s] = struct{}{}
+	upstream.dependentsMu.Unlock()
+}
+
+func (s *segmenter) releaseUpstream() {
+	s.upstream.dependentsMu.Lock()
+	delete(s.upstream.dependents, s)
+	s.upstream.dependentsMu.Unlock()
+
+	s.upstream.removeClient()
+}
+
+func (s *segmenter) allDependents() []*segmenter {
+	s.dependentsMu.Lock()
+	defer s.dependentsMu.Unlock()
+
+	result := make([]*segmenter, 0, len(s.dependents))
+	for dep := range s.dependents {
+		result = append(result, dep)
+	}
+	return result
+}
+
+func (s *segmenter) viewerPriority() (int, bool) {
+	priority := math.MinInt
+	var count int64
+
+	for _, cr := range s.allReaders() {
+		priority = max(priority, cr.priority)
+		count++
+	}
+	for _, l := range s.allLeases() {
+		priority = max(priority, l.priority)
+		count++
+	}
+	for _, dep := range s.allDependents() {
+		if dep.lingering() {
+			count++
+			continue
+		}
+		depPriority, ok := dep.viewerPriority()
+		if !ok {
+			return 0, false
+		}
+		priority = max(priority, depPriority)
+		count++
 	}
+
+	return priority, count > 0 && count == s.clientCount.Load()
+}
+
+func (s *segmenter) upstreamExited() <-chan struct{} {
+	if s.upstream == nil {
+		return nil
+	}
+	return s.upstream.exited
+}
+
+func (s *segmenter) setSource(streamURL string) {
+	s.sources[0] = streamURL
+}
+
+func (s *segmenter) setFallbacks(urls []string) {
+	s.sources = append(s.sources[:1], urls...)
+}
+
+func (s *segmenter) isReady() bool {
+	select {
+	case <-s.ready:
+		return s.startErr == nil
+	default:
+		return false
+	}
+}
+
+func (s *segmenter) watchStall(ctx context.Context) bool {
+	timeout := s.stallTimeout()
+	if timeout <= 0 {
+		return false
+	}
+
+	select {
+	case <-s.ready:
+		if s.startErr != nil {
+			return false
+		}
+	case <-ctx.Done():
+		return false
+	}
+
+	ticker := time.NewTicker(min(timeout/4, maxStallPoll))
+	defer ticker.Stop()
+
+	last := s.lastSegmentWrite()
+	lastProgress := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if latest := s.lastSegmentWrite(); !latest.Equal(last) {
+				last = latest
+				lastProgress = time.Now()
+			} else if time.Since(lastProgress) > timeout {
+				return true
+			}
+		}
+	}
+}
+
+func (s *segmenter) stallTimeout() time.Duration {
+	if s.config.StallSegments == nil || *s.config.StallSegments <= 0 {
+		return 0
+	}
+
+	return time.Duration(*s.config.StallSegments) * segmentDuration(s.config)
+}
+
+func (s *segmenter) liveStartIndex(offset time.Duration) int {
+	if offset <= 0 {
+		return defaultLiveStartIndex
+	}
+	return defaultLiveStartIndex - segmentsFor(offset, s.config)
+}
+
+func segmentDuration(cfg proxy.Segmenter) time.Duration {
+	for _, v := range cfg.TemplateVars {
+		if v.Name != "segment_duration" {
+			continue
+		}
+		if secs, err := strconv.ParseFloat(v.Value, 64); err == nil && secs > 0 {
+			return time.Duration(secs * float64(time.Second))
+		}
+	}
+	return defaultSegmentDuration
+}
+
+func segmentsFor(d time.Duration, cfg proxy.Segmenter) int {
+	segDur := segmentDuration(cfg)
+	return int((d + segDur - 1) / segDur)
+}
+
+func timeshiftSegments(cfg proxy.Segmenter) int {
+	if cfg.Timeshift == nil || *cfg.Timeshift <= 0 {
+		return 0
+	}
+
+	n := segmentsFor(time.Duration(*cfg.Timeshift), cfg)
+	for _, v := range cfg.TemplateVars {
+		if v.Name != "max_segments" {
+			continue
+		}
+		if configured, err := strconv.Atoi(v.Value); err == nil && (configured == 0 || configured >= n) {
+			return 0
+		}
+	}
+	return n
 }
 
 func (s *segmenter) setReady(err error) {
@@ -111,6 +422,23 @@ func (s *segmenter) waitForSegments() {
 	}
 }
 
+func (s *segmenter) lastSegmentWrite() time.Time {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return time.Time{}
+	}
+	var latest time.Time
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".ts" {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
 func (s *segmenter) countSegments() int {
 	entries, err := os.ReadDir(s.dir)
 	if err != nil {
@@ -126,19 +454,151 @@ func (s *segmenter) countSegments() int {
 }
 
 func (s *segmenter) addClient() {
-	s.clientCount.Add(1)
+	s.emptyMu.Lock()
+	defer s.emptyMu.Unlock()
+
+	if s.clientCount.Add(1) == 1 && s.emptyClosed {
+		s.emptyChan = make(chan struct{})
+		s.emptyClosed = false
+	}
 }
 
 func (s *segmenter) removeClient() {
+	s.emptyMu.Lock()
+	defer s.emptyMu.Unlock()
+
 	if s.clientCount.Add(-1) <= 0 {
-		s.notifyEmpty()
+		s.closeEmpty()
 	}
 }
 
+func (s *segmenter) registerReader(cr *clientReader) {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+	s.readers[cr.id] = cr
+}
+
+func (s *segmenter) unregisterReader(cr *clientReader) {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+	if s.readers[cr.id] == cr {
+		delete(s.readers, cr.id)
+	}
+}
+
+func (s *segmenter) reader(id string) *clientReader {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+	return s.readers[id]
+}
+
+func (s *segmenter) allReaders() []*clientReader {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+
+	result := make([]*clientReader, 0, len(s.readers))
+	for _, cr := range s.readers {
+		result = append(result, cr)
+	}
+	return result
+}
+
 func (s *segmenter) waitEmpty() <-chan struct{} {
+	s.emptyMu.Lock()
+	defer s.emptyMu.Unlock()
 	return s.emptyChan
 }
 
+func (s *segmenter) idleFor() time.Duration {
+	if s.clientCount.Load() > 0 {
+		var idle time.Duration
+		for i, dep := range s.allDependents() {
+			if depIdle := dep.idleFor(); i == 0 || depIdle < idle {
+				idle = depIdle
+			}
+		}
+		return idle
+	}
+	return time.Since(time.Unix(0, s.emptyAt.Load()))
+}
+
+// lingering reports whether the segmenter runs without viewers. An upstream
+// segmenter whose only clients are lingering profile segmenters lingers too.
+func (s *segmenter) lingering() bool {
+	if s.ctx.Err() != nil || !s.isReady() {
+		return false
+	}
+
+	clients := s.clientCount.Load()
+	if clients == 0 {
+		return true
+	}
+
+	dependents := s.allDependents()
+	if int64(len(dependents)) != clients {
+		return false
+	}
+	for _, dep := range dependents {
+		if !dep.lingering() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *segmenter) linger() time.Duration {
+	if s.config.Linger == nil {
+		return 0
+	}
+	return time.Duration(*s.config.Linger)
+}
+
+func (s *segmenter) setSemaphore(sem *utils.Semaphore, limits ...*utils.Limit) {
+	s.semaphore = sem
+	s.limits = limits
+	s.holdsSemaphore.Store(sem != nil || len(limits) > 0)
+}
+
+func (s *segmenter) releaseSemaphore() bool {
+	if s.holdsSemaphore.CompareAndSwap(true, false) {
+		s.releaseExcept()
+		return true
+	}
+	return false
+}
+
+func (s *segmenter) releaseExcept(keep ...*utils.Semaphore) {
+	if s.semaphore != nil && !slices.Contains(keep, s.semaphore) {
+		s.semaphore.Release()
+	}
+	for _, limit := range s.limits {
+		if !slices.Contains(keep, limit.Semaphore()) {
+			limit.Release()
+		}
+	}
+}
+
+func (s *segmenter) holdsAll(sems []*utils.Semaphore) bool {
+	for _, sem := range sems {
+		if !s.holds(sem) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *segmenter) holds(sem *utils.Semaphore) bool {
+	if s.semaphore == sem {
+		return true
+	}
+	for _, limit := range s.limits {
+		if limit.Semaphore() == sem {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *segmenter) stop() {
 	s.cancel()
 	s.notifyEmpty()
@@ -149,7 +609,15 @@ func (s *segmenter) cleanup() {
 }
 
 func (s *segmenter) notifyEmpty() {
-	s.emptyOnce.Do(func() {
+	s.emptyMu.Lock()
+	defer s.emptyMu.Unlock()
+	s.closeEmpty()
+}
+
+func (s *segmenter) closeEmpty() {
+	if !s.emptyClosed {
+		s.emptyAt.Store(time.Now().UnixNano())
 		close(s.emptyChan)
-	})
+		s.emptyClosed = true
+	}
 }
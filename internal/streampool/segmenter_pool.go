@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"majmun/internal/config/proxy"
+	"majmun/internal/utils"
 	"sync"
 )
 
@@ -23,6 +24,7 @@ func (p *segmenterPool) getOrCreate(streamKey string, ctx context.Context, baseD
 	defer p.mu.Unlock()
 
 	if seg, exists := p.segmenters[streamKey]; exists {
+		seg.addClient()
 		return seg, false, nil
 	}
 
@@ -49,6 +51,95 @@ func (p *segmenterPool) remove(streamKey string) {
 	}
 }
 
+func (p *segmenterPool) removeSegmenter(seg *segmenter) {
+	p.mu.Lock()
+	current, exists := p.segmenters[seg.streamKey]
+	if exists && current == seg {
+		delete(p.segmenters, seg.streamKey)
+	}
+	p.mu.Unlock()
+
+	seg.stop()
+	seg.cleanup()
+}
+
+func (p *segmenterPool) retireIdle(seg *segmenter) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.segmenters[seg.streamKey] != seg || seg.clientCount.Load() > 0 {
+		return false
+	}
+	delete(p.segmenters, seg.streamKey)
+	return true
+}
+
+func (p *segmenterPool) takeLingering(sems ...*utils.Semaphore) *segmenter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var victim *segmenter
+	for _, seg := range p.segmenters {
+		if !seg.lingering() || !seg.holdsAll(sems) {
+			continue
+		}
+		if victim == nil || seg.idleFor() > victim.idleFor() {
+			victim = seg
+		}
+	}
+
+	if victim == nil || !victim.holdsSemaphore.CompareAndSwap(true, false) {
+		return nil
+	}
+	delete(p.segmenters, victim.streamKey)
+	return victim
+}
+
+func (p *segmenterPool) takePreemptible(priority int, sems ...*utils.Semaphore) *segmenter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var victim *segmenter
+	var victimPriority int
+	for _, seg := range p.segmenters {
+		if seg.ctx.Err() != nil || !seg.isReady() || !seg.holdsAll(sems) {
+			continue
+		}
+		segPriority, ok := seg.viewerPriority()
+		if !ok || segPriority >= priority {
+			continue
+		}
+		if victim == nil || segPriority < victimPriority ||
+			(segPriority == victimPriority && seg.startedAt.After(victim.startedAt)) {
+			victim = seg
+			victimPriority = segPriority
+		}
+	}
+
+	if victim == nil || !victim.holdsSemaphore.CompareAndSwap(true, false) {
+		return nil
+	}
+	delete(p.segmenters, victim.streamKey)
+	return victim
+}
+
+func (p *segmenterPool) get(streamKey string) *segmenter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.segmenters[streamKey]
+}
+
+func (p *segmenterPool) all() []*segmenter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]*segmenter, 0, len(p.segmenters))
+	for _, seg := range p.segmenters {
+		result = append(result, seg)
+	}
+	return result
+}
+
 func (p *segmenterPool) stopAll() {
 	p.mu.Lock()
 	segmenters := p.segmenters
@@ -12,35 +12,47 @@ import (
 	"majmun/internal/utils"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
-const semaphoreTimeout = 200 * time.Millisecond
-
 var (
 	ErrSubscriptionSemaphore = errors.New("failed to acquire subscription semaphore")
 	ErrSegmenterFailed       = errors.New("segmenter failed to start")
+	ErrPreempted             = errors.New("stream preempted by a higher priority client")
 )
 
 type Streamer interface {
 	RunWithStdout(ctx context.Context, w io.Writer) (int64, error)
 }
 
-type ClientStreamerFunc func(playlistPath string) Streamer
+type ClientStreamerFunc func(playlistPath string, liveStartIndex int) Streamer
+
+type MirrorsFunc func(req Request) []Request
 
 type Request struct {
 	StreamKey      string
 	StreamURL      string
+	Fallbacks      []string
 	ClientStreamer ClientStreamerFunc
-	Semaphore      *semaphore.Weighted
+	Semaphore      *utils.Semaphore
+	Limits         []*utils.Limit
+	QueueTimeout   time.Duration
 	Segmenter      proxy.Segmenter
+	Offset         time.Duration
+	Profile        string
+	Upstream       *Request
+	Mirrors        MirrorsFunc
+	Account        string
 }
 
 type StreamPool struct {
 	pool    *segmenterPool
 	baseDir string
+
+	pinned        map[string]*pinnedStream
+	pinnedStopped bool
+	pinnedMu      sync.Mutex
 }
 
 func New() *StreamPool {
@@ -51,16 +63,44 @@ func New() *StreamPool {
 	return &StreamPool{
 		pool:    newSegmenterPool(),
 		baseDir: dir,
+		pinned:  make(map[string]*pinnedStream),
 	}
 }
 
 func (d *StreamPool) Stop() {
+	d.stopPinned()
 	d.pool.stopAll()
 	_ = os.RemoveAll(d.baseDir)
 }
 
 func (d *StreamPool) GetReader(ctx context.Context, req Request) (io.ReadCloser, error) {
 	clientCtx := ctxutil.WithStreamID(ctx, req.StreamKey)
+
+	seg, err := d.acquireSegmenter(clientCtx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctxutil.RequestID(clientCtx) == "" {
+		clientCtx = ctxutil.WithRequestID(clientCtx)
+	}
+
+	cs := newClientStream(clientCtx, req.ClientStreamer(seg.playlistPath, seg.liveStartIndex(req.Offset)))
+
+	cr := &clientReader{
+		clientStream: cs,
+		seg:          seg,
+		id:           ctxutil.RequestID(clientCtx),
+		clientName:   ctxutil.ClientName(clientCtx),
+		priority:     ctxutil.Priority(clientCtx),
+		connectedAt:  time.Now(),
+	}
+	seg.registerReader(cr)
+
+	return cr, nil
+}
+
+func (d *StreamPool) acquireSegmenter(clientCtx context.Context, req Request) (*segmenter, error) {
 	streamCtx := context.WithoutCancel(clientCtx)
 
 	seg, isNew, err := d.pool.getOrCreate(req.StreamKey, streamCtx, d.baseDir, req.Segmenter, req.StreamURL)
@@ -69,16 +109,32 @@ func (d *StreamPool) GetReader(ctx context.Context, req Request) (io.ReadCloser,
 	}
 
 	if isNew {
-		if !utils.AcquireSemaphore(streamCtx, req.Semaphore, semaphoreTimeout, "subscription") {
-			d.pool.remove(req.StreamKey)
-			return nil, ErrSubscriptionSemaphore
+		if req.Upstream != nil {
+			upstreamCtx := ctxutil.WithStreamID(clientCtx, req.Upstream.StreamKey)
+			upstream, err := d.acquireSegmenter(upstreamCtx, *req.Upstream)
+			if err != nil {
+				seg.setReady(err)
+				d.pool.remove(req.StreamKey)
+				return nil, err
+			}
+			seg.setUpstream(upstream, req.Profile)
+		} else {
+			mirror, ok := d.acquireMirror(clientCtx, req)
+			if !ok {
+				seg.setReady(ErrSubscriptionSemaphore)
+				d.pool.remove(req.StreamKey)
+				return nil, ErrSubscriptionSemaphore
+			}
+			req = mirror
+			seg.setSource(req.StreamURL)
 		}
+		seg.setSemaphore(req.Semaphore, req.Limits...)
+		seg.setFallbacks(req.Fallbacks)
 		logging.Debug(streamCtx, "acquired subscription semaphore")
 		go d.runSegmenter(streamCtx, req, seg)
 		logging.Info(streamCtx, "started new segmenter")
 	} else {
-		seg.addClient()
-		metrics.IncStreamsReused(ctx)
+		metrics.IncStreamsReused(clientCtx)
 		logging.Info(clientCtx, "joined existing segmenter")
 	}
 
@@ -89,43 +145,240 @@ func (d *StreamPool) GetReader(ctx context.Context, req Request) (io.ReadCloser,
 		return nil, clientCtx.Err()
 	}
 
+	if errors.Is(seg.startErr, ErrSubscriptionSemaphore) {
+		seg.removeClient()
+		return nil, ErrSubscriptionSemaphore
+	}
 	if seg.startErr != nil {
 		seg.removeClient()
 		return nil, fmt.Errorf("%w: %v", ErrSegmenterFailed, seg.startErr)
 	}
 
-	cs := newClientStream(clientCtx, req.ClientStreamer(seg.playlistPath))
+	return seg, nil
+}
 
-	return &clientReader{
-		clientStream: cs,
-		seg:          seg,
-	}, nil
+func (d *StreamPool) acquireMirror(ctx context.Context, req Request) (Request, bool) {
+	var candidates []Request
+	if req.Mirrors != nil {
+		candidates = req.Mirrors(req)
+	}
+	if len(candidates) == 0 {
+		return req, d.acquireSubscription(ctx, req)
+	}
+
+	for _, candidate := range candidates {
+		if tryAcquireSubscription(ctx, candidate) {
+			logging.Debug(ctx, "selected mirror account", "account", candidate.Account)
+			return candidate, true
+		}
+	}
+
+	logging.Debug(ctx, "no mirror account has a free slot, waiting for preferred", "account", candidates[0].Account)
+	return candidates[0], d.acquireSubscription(ctx, candidates[0])
+}
+
+func tryAcquireSubscription(ctx context.Context, req Request) bool {
+	if !utils.AcquireSemaphore(ctx, req.Semaphore, 0, metrics.FailureReasonPlaylistLimit) {
+		return false
+	}
+
+	for i, limit := range req.Limits {
+		if !limit.Acquire(ctx, 0) {
+			releaseSubscription(req.Semaphore, req.Limits[:i])
+			return false
+		}
+	}
+
+	return true
+}
+
+func releaseSubscription(sem *utils.Semaphore, limits []*utils.Limit) {
+	for _, limit := range limits {
+		limit.Release()
+	}
+	if sem != nil {
+		sem.Release()
+	}
+}
+
+type subscriptionSlot struct {
+	sem     *utils.Semaphore
+	reason  string
+	acquire func(time.Duration) bool
+	release func()
+}
+
+func subscriptionSlots(ctx context.Context, req Request) []subscriptionSlot {
+	var slots []subscriptionSlot
+	if req.Semaphore != nil {
+		slots = append(slots, subscriptionSlot{
+			sem:    req.Semaphore,
+			reason: metrics.FailureReasonPlaylistLimit,
+			acquire: func(timeout time.Duration) bool {
+				return utils.AcquireSemaphore(ctx, req.Semaphore, timeout, metrics.FailureReasonPlaylistLimit)
+			},
+			release: req.Semaphore.Release,
+		})
+	}
+	for _, limit := range req.Limits {
+		if limit == nil {
+			continue
+		}
+		slots = append(slots, subscriptionSlot{
+			sem:    limit.Semaphore(),
+			reason: limit.Reason,
+			acquire: func(timeout time.Duration) bool {
+				return limit.Acquire(ctx, timeout)
+			},
+			release: limit.Release,
+		})
+	}
+	return slots
+}
+
+// acquireSubscription takes every slot the request needs. Saturated slots are
+// taken over from a single lingering or lower priority segmenter that holds
+// all of them, so nothing is stopped unless the request can start afterwards.
+// Otherwise it waits for the slots up to the queue timeout.
+func (d *StreamPool) acquireSubscription(ctx context.Context, req Request) bool {
+	slots := subscriptionSlots(ctx, req)
+	acquired := make([]bool, len(slots))
+
+	var saturated []*utils.Semaphore
+	for i, slot := range slots {
+		if acquired[i] = slot.acquire(0); !acquired[i] {
+			saturated = append(saturated, slot.sem)
+		}
+	}
+	if len(saturated) == 0 || d.takeOverSlots(ctx, saturated) {
+		return true
+	}
+
+	for i, slot := range slots {
+		if acquired[i] {
+			continue
+		}
+		if acquired[i] = slot.acquire(req.QueueTimeout); acquired[i] {
+			continue
+		}
+
+		metrics.IncStreamsFailures(ctx, slot.reason)
+		for j, held := range acquired {
+			if held {
+				slots[j].release()
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func (d *StreamPool) takeOverSlots(ctx context.Context, sems []*utils.Semaphore) bool {
+	if victim := d.pool.takeLingering(sems...); victim != nil {
+		logging.Info(ctx, "preempted lingering segmenter", "preempted_stream_id", victim.streamKey)
+		victim.stop()
+		victim.cleanup()
+		victim.releaseExcept(sems...)
+		return true
+	}
+
+	if victim := d.pool.takePreemptible(ctxutil.Priority(ctx), sems...); victim != nil {
+		logging.Info(ctx, "preempted lower priority segmenter", "preempted_stream_id", victim.streamKey)
+		d.preempt(victim)
+		victim.stop()
+		victim.cleanup()
+		victim.releaseExcept(sems...)
+		return true
+	}
+
+	return false
+}
+
+func (d *StreamPool) preempt(seg *segmenter) {
+	for _, dep := range seg.allDependents() {
+		d.preempt(dep)
+	}
+	for _, cr := range seg.allReaders() {
+		cr.preempt()
+	}
+	for _, l := range seg.allLeases() {
+		l.close()
+	}
 }
 
 func (d *StreamPool) runSegmenter(ctx context.Context, req Request, seg *segmenter) {
-	metrics.IncPlaylistStreamsActive(ctx)
-	defer metrics.DecPlaylistStreamsActive(ctx)
-	defer d.pool.remove(req.StreamKey)
+	if seg.upstream != nil {
+		defer seg.releaseUpstream()
+	} else {
+		metrics.IncPlaylistStreamsActive(ctx)
+		defer metrics.DecPlaylistStreamsActive(ctx)
+	}
+	defer d.pool.removeSegmenter(seg)
 	defer func() {
-		if req.Semaphore != nil {
-			req.Semaphore.Release(1)
+		if seg.releaseSemaphore() {
 			logging.Debug(ctx, "releasing subscription semaphore")
 		}
 	}()
 
-	segCtx, segCancel := context.WithCancel(ctx)
+	segCtx, segCancel := context.WithCancel(seg.ctx)
 	defer segCancel()
 
 	go func() {
-		select {
-		case <-seg.waitEmpty():
-			logging.Debug(ctx, "no clients left, stopping segmenter")
-			segCancel()
-		case <-segCtx.Done():
+		for {
+			select {
+			case <-seg.waitEmpty():
+			case <-seg.upstreamExited():
+				logging.Debug(ctx, "upstream segmenter exited, stopping segmenter")
+				segCancel()
+				return
+			case <-segCtx.Done():
+				return
+			}
+
+			if !d.linger(segCtx, seg) {
+				logging.Debug(ctx, "no clients left, stopping segmenter")
+				segCancel()
+				return
+			}
 		}
 	}()
 
 	seg.start(segCtx)
+	close(seg.exited)
+	segCancel()
 
 	<-seg.waitEmpty()
 }
+
+func (d *StreamPool) linger(ctx context.Context, seg *segmenter) bool {
+	linger := seg.linger()
+	if linger <= 0 || !seg.lingering() {
+		return false
+	}
+
+	logging.Debug(ctx, "no clients left, lingering", "linger", linger)
+
+	timer := time.NewTimer(linger)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+
+		if seg.clientCount.Load() > 0 {
+			return true
+		}
+		if idle := seg.idleFor(); idle < linger {
+			timer.Reset(linger - idle)
+			continue
+		}
+		if !d.pool.retireIdle(seg) {
+			return seg.clientCount.Load() > 0
+		}
+		return false
+	}
+}
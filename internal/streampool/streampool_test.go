@@ -5,14 +5,15 @@ import (
 	"io"
 	"majmun/internal/config/common"
 	"majmun/internal/config/proxy"
+	"majmun/internal/ctxutil"
+	"majmun/internal/utils"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sync"
 	"testing"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
 const testStreamURL = "testsrc=duration=60:size=320x240:rate=10"
@@ -48,7 +49,7 @@ func durationPtr(d time.Duration) *common.Duration {
 	return &cd
 }
 
-var testClientStreamer = ClientStreamerFunc(func(playlistPath string) Streamer {
+var testClientStreamer = ClientStreamerFunc(func(playlistPath string, _ int) Streamer {
 	return &mockClientStreamer{playlistPath: playlistPath}
 })
 
@@ -374,7 +375,7 @@ func TestGetReader_SemaphoreBlocksSecondStream(t *testing.T) {
 	d := New()
 	defer d.Stop()
 
-	sem := semaphore.NewWeighted(1)
+	sem := utils.NewSemaphore(1)
 
 	ctx1, cancel1 := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel1()
@@ -418,7 +419,7 @@ func TestGetReader_JoiningExistingStreamDoesNotConsumeSemaphore(t *testing.T) {
 	d := New()
 	defer d.Stop()
 
-	sem := semaphore.NewWeighted(1)
+	sem := utils.NewSemaphore(1)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -471,3 +472,971 @@ func TestStop_ReaderFailsAfterPoolStopped(t *testing.T) {
 		t.Error("expected error after Stop")
 	}
 }
+
+func TestSegmenterPool_RemoveSegmenterKeepsNewerInstance(t *testing.T) {
+	pool := newSegmenterPool()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	old, _, err := pool.getOrCreate("stream-1", ctx, dir, testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+	pool.remove("stream-1")
+
+	current, _, err := pool.getOrCreate("stream-1", ctx, dir, testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+
+	pool.removeSegmenter(old)
+
+	if pool.get("stream-1") != current {
+		t.Error("expected newer segmenter to remain in pool")
+	}
+}
+
+func TestInspect_SegmentersAndStop(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	_, _, err := d.pool.getOrCreate("stream-1", context.Background(), t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+
+	segmenters := d.Segmenters()
+	if len(segmenters) != 1 {
+		t.Fatalf("expected 1 segmenter, got %d", len(segmenters))
+	}
+	if segmenters[0].StreamKey != "stream-1" {
+		t.Errorf("expected stream key stream-1, got %s", segmenters[0].StreamKey)
+	}
+
+	clients, ok := d.Clients("stream-1")
+	if !ok {
+		t.Fatal("expected segmenter to be found")
+	}
+	if len(clients) != 0 {
+		t.Errorf("expected 0 clients, got %d", len(clients))
+	}
+
+	if d.DisconnectClient("stream-1", "missing") {
+		t.Error("expected disconnect of unknown client to fail")
+	}
+	if !d.StopSegmenter("stream-1") {
+		t.Error("expected StopSegmenter to succeed")
+	}
+	if d.StopSegmenter("stream-1") {
+		t.Error("expected second StopSegmenter to fail")
+	}
+	if len(d.Segmenters()) != 0 {
+		t.Error("expected no segmenters after stop")
+	}
+}
+
+func TestSegmenter_HLSLeaseExpiresWhenIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seg, err := newSegmenter(ctx, "test", t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("newSegmenter failed: %v", err)
+	}
+
+	released := make(chan struct{})
+	l := &hlsLease{id: "hls-test", release: func() { close(released) }, done: make(chan struct{})}
+	l.touch()
+
+	if !seg.addLease(l) {
+		t.Fatal("expected lease to be added")
+	}
+	if seg.addLease(&hlsLease{id: "hls-test"}) {
+		t.Error("expected duplicate lease to be rejected")
+	}
+
+	go seg.watchLease(ctx, l, 200*time.Millisecond)
+
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease was not released")
+	}
+
+	select {
+	case <-seg.waitEmpty():
+	case <-time.After(time.Second):
+		t.Fatal("expected segmenter to become empty after lease expired")
+	}
+
+	if seg.lease("hls-test") != nil {
+		t.Error("expected lease to be removed")
+	}
+}
+
+func TestSegmenter_HLSLeaseKeptAliveByTouch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seg, err := newSegmenter(ctx, "test", t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("newSegmenter failed: %v", err)
+	}
+
+	var released sync.WaitGroup
+	released.Add(1)
+	l := &hlsLease{id: "hls-test", release: released.Done, done: make(chan struct{})}
+	l.touch()
+	seg.addLease(l)
+
+	go seg.watchLease(ctx, l, 300*time.Millisecond)
+
+	for range 6 {
+		time.Sleep(100 * time.Millisecond)
+		l.touch()
+	}
+
+	if seg.lease("hls-test") == nil {
+		t.Fatal("expected lease to stay alive while touched")
+	}
+
+	l.close()
+	released.Wait()
+}
+
+func TestSegmenter_EmptySignalRearmedOnRejoin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seg, err := newSegmenter(ctx, "test", t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("newSegmenter failed: %v", err)
+	}
+
+	seg.removeClient()
+	select {
+	case <-seg.waitEmpty():
+	default:
+		t.Fatal("expected empty signal after last client removed")
+	}
+
+	seg.addClient()
+	select {
+	case <-seg.waitEmpty():
+		t.Fatal("expected empty signal to be rearmed after rejoin")
+	default:
+	}
+
+	seg.removeClient()
+	select {
+	case <-seg.waitEmpty():
+	default:
+		t.Fatal("expected empty signal after client left again")
+	}
+}
+
+func TestLinger_RetiresIdleSegmenter(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	cfg := testSegmenterCfg
+	cfg.Linger = durationPtr(200 * time.Millisecond)
+
+	seg, _, err := d.pool.getOrCreate("stream-1", context.Background(), t.TempDir(), cfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+	seg.setReady(nil)
+	seg.removeClient()
+
+	start := time.Now()
+	if d.linger(context.Background(), seg) {
+		t.Fatal("expected idle segmenter to be retired")
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("expected segmenter to linger before retiring")
+	}
+	if d.pool.get("stream-1") != nil {
+		t.Error("expected retired segmenter to be removed from pool")
+	}
+}
+
+func TestLinger_RejoinKeepsSegmenter(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	cfg := testSegmenterCfg
+	cfg.Linger = durationPtr(200 * time.Millisecond)
+
+	seg, _, err := d.pool.getOrCreate("stream-1", context.Background(), t.TempDir(), cfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+	seg.setReady(nil)
+	seg.removeClient()
+
+	result := make(chan bool, 1)
+	go func() { result <- d.linger(context.Background(), seg) }()
+
+	time.Sleep(50 * time.Millisecond)
+	joined, isNew, err := d.pool.getOrCreate("stream-1", context.Background(), t.TempDir(), cfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+	if isNew || joined != seg {
+		t.Fatal("expected lingering segmenter to be rejoined")
+	}
+
+	select {
+	case kept := <-result:
+		if !kept {
+			t.Error("expected rejoined segmenter to keep running")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("linger did not return")
+	}
+	if d.pool.get("stream-1") != seg {
+		t.Error("expected rejoined segmenter to stay in pool")
+	}
+}
+
+func TestSegmenterPool_TakeLingeringHandsOverSemaphore(t *testing.T) {
+	pool := newSegmenterPool()
+	sem := utils.NewSemaphore(1)
+
+	seg, _, err := pool.getOrCreate("stream-1", context.Background(), t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate failed: %v", err)
+	}
+	if !sem.TryAcquire() {
+		t.Fatal("failed to acquire semaphore")
+	}
+	seg.setSemaphore(sem)
+	seg.setReady(nil)
+
+	if pool.takeLingering(sem) != nil {
+		t.Fatal("expected segmenter with clients not to be preempted")
+	}
+
+	seg.removeClient()
+
+	if pool.takeLingering(utils.NewSemaphore(1)) != nil {
+		t.Error("expected segmenter on another semaphore not to be preempted")
+	}
+	if pool.takeLingering(sem) != seg {
+		t.Fatal("expected lingering segmenter to be preempted")
+	}
+	if pool.get("stream-1") != nil {
+		t.Error("expected preempted segmenter to be removed from pool")
+	}
+	if seg.releaseSemaphore() {
+		t.Error("expected preempted segmenter to no longer hold the semaphore")
+	}
+	if sem.TryAcquire() {
+		t.Error("expected semaphore slot to be handed over, not released")
+	}
+}
+
+func TestSegmenterPool_UpstreamOfLingeringProfileLingers(t *testing.T) {
+	pool := newSegmenterPool()
+	sem := utils.NewSemaphore(1)
+
+	up, _, err := pool.getOrCreate("upstream", context.Background(), t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate upstream failed: %v", err)
+	}
+	if !sem.TryAcquire() {
+		t.Fatal("failed to acquire semaphore")
+	}
+	up.setSemaphore(sem)
+	up.setReady(nil)
+
+	dep, _, err := pool.getOrCreate("upstream-720p", context.Background(), t.TempDir(), testSegmenterCfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("getOrCreate profile failed: %v", err)
+	}
+	dep.setUpstream(up, "720p")
+	dep.setReady(nil)
+
+	if up.lingering() {
+		t.Fatal("expected upstream with a watched profile not to linger")
+	}
+	if pool.takeLingering(sem) != nil {
+		t.Fatal("expected upstream with a watched profile not to be taken over")
+	}
+
+	dep.removeClient()
+
+	if !dep.lingering() || !up.lingering() {
+		t.Fatal("expected upstream of a lingering profile to linger")
+	}
+	if priority, ok := up.viewerPriority(); !ok || priority != math.MinInt {
+		t.Errorf("expected upstream without viewers to be preemptible by any priority, got %d", priority)
+	}
+	if pool.takeLingering(sem) != up {
+		t.Fatal("expected upstream of a lingering profile to be taken over")
+	}
+}
+
+var testShellSegmenterCfg = proxy.Segmenter{
+	Command: common.StringOrArr{
+		"sh", "-c",
+		`i=0; while true; do touch "$(printf "$0" $i)"; i=$((i+1)); sleep 0.1; done`,
+		"{{ .segment_path }}",
+	},
+	InitSegments: intPtr(1),
+	ReadyTimeout: durationPtr(5 * time.Second),
+}
+
+func TestSetPinned_StartsAndReleasesSegmenter(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	sem := utils.NewSemaphore(1)
+	pinned := PinnedStream{
+		StreamKey: "pinned-1",
+		StreamURL: testStreamURL,
+		Semaphore: sem,
+		Segmenter: testShellSegmenterCfg,
+	}
+
+	d.SetPinned([]PinnedStream{pinned})
+	if !d.Pinned("pinned-1") {
+		t.Fatal("expected stream to be pinned")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for d.pool.get("pinned-1") == nil || d.pool.get("pinned-1").clientCount.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("pinned segmenter was not started")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	seg := d.pool.get("pinned-1")
+	select {
+	case <-seg.ready:
+		if seg.startErr != nil {
+			t.Fatalf("pinned segmenter failed to start: %v", seg.startErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pinned segmenter did not become ready")
+	}
+
+	if sem.TryAcquire() {
+		t.Error("expected pinned segmenter to hold the playlist semaphore")
+	}
+
+	d.SetPinned(nil)
+	if d.Pinned("pinned-1") {
+		t.Error("expected stream to be unpinned")
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for d.pool.get("pinned-1") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("unpinned segmenter was not stopped")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for !sem.TryAcquire() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected semaphore to be released after unpinning")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSetPinned_RestartsExitedSegmenter(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	cfg := testShellSegmenterCfg
+	cfg.Command = common.StringOrArr{"sh", "-c", `touch "$(printf "$0" 0)"; sleep 0.3`, "{{ .segment_path }}"}
+
+	d.SetPinned([]PinnedStream{{StreamKey: "pinned-1", StreamURL: testStreamURL, Segmenter: cfg}})
+
+	var first *segmenter
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatal("pinned segmenter was not restarted")
+		}
+		seg := d.pool.get("pinned-1")
+		if first == nil {
+			first = seg
+		} else if seg != nil && seg != first {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSegmenter_RestartsWhenStalled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := testShellSegmenterCfg
+	cfg.Command = common.StringOrArr{"sh", "-c", `touch "$(printf "$0" $$)"; exec sleep 60`, "{{ .segment_path }}"}
+	cfg.TemplateVars = []common.NameValue{{Name: "segment_duration", Value: "0.2"}}
+	cfg.StallSegments = intPtr(1)
+
+	seg, err := newSegmenter(ctx, "test", t.TempDir(), cfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("newSegmenter failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		seg.start(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for seg.countSegments() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected stalled segmenter to be restarted, got %d segments", seg.countSegments())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if seg.startErr != nil {
+		t.Errorf("expected no start error, got %v", seg.startErr)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("segmenter did not stop after cancel")
+	}
+}
+
+func TestSegmenter_RecoversAfterStallBeforeFirstSegment(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	script := `runs="$(dirname "$0")/runs"
+n=$(($(cat "$runs" 2>/dev/null || echo 0) + 1))
+echo $n > "$runs"
+case $n in
+1) touch "$(printf "$0" 0)"; exec sleep 60 ;;
+2) exec sleep 60 ;;
+esac
+i=1
+while :; do touch "$(printf "$0" $i)"; i=$((i + 1)); sleep 0.1; done`
+
+	cfg := testShellSegmenterCfg
+	cfg.Command = common.StringOrArr{"sh", "-c", script, "{{ .segment_path }}"}
+	cfg.TemplateVars = []common.NameValue{{Name: "segment_duration", Value: "0.2"}}
+	cfg.StallSegments = intPtr(1)
+
+	seg, err := newSegmenter(ctx, "test", t.TempDir(), cfg, testStreamURL)
+	if err != nil {
+		t.Fatalf("newSegmenter failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		seg.start(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for seg.countSegments() < 5 {
+		select {
+		case <-done:
+			t.Fatalf("segmenter gave up after a stalled restart, got %d segments", seg.countSegments())
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected segmenter to recover, got %d segments", seg.countSegments())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("segmenter did not stop after cancel")
+	}
+}
+
+func TestSegmenter_StallTimeout(t *testing.T) {
+	cfg := proxy.Segmenter{StallSegments: intPtr(5)}
+	seg := &segmenter{config: cfg}
+	if got := seg.stallTimeout(); got != 5*defaultSegmentDuration {
+		t.Errorf("expected %v, got %v", 5*defaultSegmentDuration, got)
+	}
+
+	seg.config.TemplateVars = []common.NameValue{{Name: "segment_duration", Value: "4"}}
+	if got := seg.stallTimeout(); got != 20*time.Second {
+		t.Errorf("expected 20s, got %v", got)
+	}
+
+	seg.config.StallSegments = intPtr(0)
+	if got := seg.stallTimeout(); got != 0 {
+		t.Errorf("expected stall detection to be disabled, got %v", got)
+	}
+}
+
+func TestSegmenter_TimeshiftSegments(t *testing.T) {
+	cfg := proxy.Segmenter{TemplateVars: []common.NameValue{{Name: "max_segments", Value: "15"}}}
+	if got := timeshiftSegments(cfg); got != 0 {
+		t.Errorf("expected no override without timeshift, got %d", got)
+	}
+
+	cfg.Timeshift = durationPtr(time.Hour)
+	if got := timeshiftSegments(cfg); got != 1800 {
+		t.Errorf("expected 1800 segments, got %d", got)
+	}
+
+	cfg.TemplateVars = append(cfg.TemplateVars, common.NameValue{Name: "segment_duration", Value: "6"})
+	if got := timeshiftSegments(cfg); got != 600 {
+		t.Errorf("expected 600 segments, got %d", got)
+	}
+
+	cfg.Timeshift = durationPtr(30 * time.Second)
+	if got := timeshiftSegments(cfg); got != 0 {
+		t.Errorf("expected configured max_segments to be kept, got %d", got)
+	}
+}
+
+func TestSegmenter_LiveStartIndex(t *testing.T) {
+	seg := &segmenter{config: proxy.Segmenter{}}
+	if got := seg.liveStartIndex(0); got != defaultLiveStartIndex {
+		t.Errorf("expected %d, got %d", defaultLiveStartIndex, got)
+	}
+	if got := seg.liveStartIndex(5 * time.Minute); got != defaultLiveStartIndex-150 {
+		t.Errorf("expected %d, got %d", defaultLiveStartIndex-150, got)
+	}
+	if got := seg.liveStartIndex(3 * time.Second); got != defaultLiveStartIndex-2 {
+		t.Errorf("expected %d, got %d", defaultLiveStartIndex-2, got)
+	}
+}
+
+func TestSegmenter_FailsOverToNextSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runsLog := filepath.Join(t.TempDir(), "runs")
+
+	cfg := testShellSegmenterCfg
+	cfg.Command = common.StringOrArr{
+		"sh", "-c",
+		`touch "$(printf "$0" $$)"; echo "$1 $2" >> "$3"; [ "$1" = primary ] && sleep 0.3 && exit 1; exec sleep 60`,
+		"{{ .segment_path }}", "{{ .url }}", "{{ .restarted }}", runsLog,
+	}
+
+	seg, err := newSegmenter(ctx, "test", t.TempDir(), cfg, "primary")
+	if err != nil {
+		t.Fatalf("newSegmenter failed: %v", err)
+	}
+	seg.setFallbacks([]string{"backup"})
+
+	done := make(chan struct{})
+	go func() {
+		seg.start(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, _ := os.ReadFile(runsLog)
+		if string(data) == "primary false\nbackup true\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected failover to backup source, got runs %q", data)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("segmenter should keep running on the backup source")
+	default:
+	}
+	if seg.startErr != nil {
+		t.Errorf("expected no start error, got %v", seg.startErr)
+	}
+}
+
+func TestOpenHLS_ProfilesShareUpstreamSegmenter(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sem := utils.NewSemaphore(1)
+	upstream := Request{
+		StreamKey: "upstream",
+		StreamURL: testStreamURL,
+		Semaphore: sem,
+		Segmenter: testShellSegmenterCfg,
+	}
+
+	for _, profile := range []string{"720p", "audio"} {
+		req := Request{
+			StreamKey: "upstream-" + profile,
+			Segmenter: testShellSegmenterCfg,
+			Profile:   profile,
+			Upstream:  &upstream,
+		}
+		if err := d.OpenHLS(ctx, req, "hls-"+profile, func() {}); err != nil {
+			t.Fatalf("OpenHLS %s failed: %v", profile, err)
+		}
+	}
+
+	up := d.pool.get("upstream")
+	if up == nil {
+		t.Fatal("expected upstream segmenter to be running")
+	}
+	if got := up.clientCount.Load(); got != 2 {
+		t.Errorf("expected upstream to be used by 2 profile segmenters, got %d", got)
+	}
+
+	for _, key := range []string{"upstream-720p", "upstream-audio"} {
+		seg := d.pool.get(key)
+		if seg == nil {
+			t.Fatalf("expected profile segmenter %s to be running", key)
+		}
+		if seg.sources[0] != up.playlistPath {
+			t.Errorf("expected %s to read from the upstream playlist, got %q", key, seg.sources[0])
+		}
+		d.StopSegmenter(key)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for d.pool.get("upstream") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("upstream segmenter was not stopped after its profiles")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for !sem.TryAcquire() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected upstream semaphore to be released")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOpenHLS_UpstreamLimitCountsSegmentersNotViewers(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	limit := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "global", "", "global_upstream_limit")
+	req := Request{
+		StreamKey: "limited-1",
+		StreamURL: testStreamURL,
+		Limits:    []*utils.Limit{limit},
+		Segmenter: testShellSegmenterCfg,
+	}
+
+	for _, lease := range []string{"hls-alice", "hls-bob"} {
+		if err := d.OpenHLS(ctx, req, lease, func() {}); err != nil {
+			t.Fatalf("OpenHLS %s failed: %v", lease, err)
+		}
+	}
+
+	other := req
+	other.StreamKey = "limited-2"
+	if err := d.OpenHLS(ctx, other, "hls-carol", func() {}); err != ErrSubscriptionSemaphore {
+		t.Fatalf("expected ErrSubscriptionSemaphore, got %v", err)
+	}
+
+	d.StopSegmenter("limited-1")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !limit.Semaphore().TryAcquire() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected upstream limit to be released")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOpenHLS_QueuedRequestStartsWhenSlotFrees(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sem := utils.NewSemaphore(1)
+	req := Request{
+		StreamKey:    "queued-1",
+		StreamURL:    testStreamURL,
+		Semaphore:    sem,
+		QueueTimeout: 5 * time.Second,
+		Segmenter:    testShellSegmenterCfg,
+	}
+	if err := d.OpenHLS(ctx, req, "hls-alice", func() {}); err != nil {
+		t.Fatalf("OpenHLS 1 failed: %v", err)
+	}
+
+	go func() {
+		for sem.Waiting() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		d.StopSegmenter("queued-1")
+	}()
+
+	queued := req
+	queued.StreamKey = "queued-2"
+	if err := d.OpenHLS(ctx, queued, "hls-bob", func() {}); err != nil {
+		t.Fatalf("expected queued request to start once the slot was freed, got %v", err)
+	}
+}
+
+type testClient struct {
+	name     string
+	priority int
+}
+
+func (c testClient) Name() string  { return c.name }
+func (c testClient) Priority() int { return c.priority }
+
+var tickingClientStreamer = ClientStreamerFunc(func(string, int) Streamer {
+	return tickingStreamer{}
+})
+
+type tickingStreamer struct{}
+
+func (tickingStreamer) RunWithStdout(ctx context.Context, w io.Writer) (int64, error) {
+	var total int64
+	for {
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+		n, err := w.Write([]byte{0x47})
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func TestGetReader_HigherPriorityPreemptsSegmenter(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sem := utils.NewSemaphore(1)
+	request := func(key string) Request {
+		return Request{
+			StreamKey:      key,
+			StreamURL:      testStreamURL,
+			ClientStreamer: tickingClientStreamer,
+			Semaphore:      sem,
+			Segmenter:      testShellSegmenterCfg,
+		}
+	}
+
+	guestCtx := ctxutil.WithClient(ctx, testClient{name: "guest", priority: 0})
+	guest, err := d.GetReader(guestCtx, request("guest-channel"))
+	if err != nil {
+		t.Fatalf("GetReader guest failed: %v", err)
+	}
+	defer func() { _ = guest.Close() }()
+
+	guestErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, guest)
+		guestErr <- err
+	}()
+
+	otherCtx := ctxutil.WithClient(ctx, testClient{name: "other", priority: 0})
+	if _, err := d.GetReader(otherCtx, request("other-channel")); err != ErrSubscriptionSemaphore {
+		t.Fatalf("expected equal priority to be rejected, got %v", err)
+	}
+
+	livingRoomCtx := ctxutil.WithClient(ctx, testClient{name: "living-room", priority: 10})
+	livingRoom, err := d.GetReader(livingRoomCtx, request("living-room-channel"))
+	if err != nil {
+		t.Fatalf("expected higher priority to preempt, got %v", err)
+	}
+	defer func() { _ = livingRoom.Close() }()
+
+	select {
+	case err := <-guestErr:
+		if err != ErrPreempted {
+			t.Errorf("expected ErrPreempted for preempted viewer, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("preempted viewer was not disconnected")
+	}
+
+	if d.pool.get("guest-channel") != nil {
+		t.Error("expected preempted segmenter to be removed from pool")
+	}
+}
+
+func TestGetReader_NoPreemptionWhenAnotherLimitStaysFull(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	playlistLimit := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "playlist", "tv", "playlist_upstream_limit")
+	accountLimit := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "account", "main", "account_limit")
+	request := func(key string, limits ...*utils.Limit) Request {
+		return Request{
+			StreamKey:      key,
+			StreamURL:      testStreamURL,
+			ClientStreamer: tickingClientStreamer,
+			Limits:         limits,
+			Segmenter:      testShellSegmenterCfg,
+		}
+	}
+
+	guestCtx := ctxutil.WithClient(ctx, testClient{name: "guest", priority: 0})
+	guest, err := d.GetReader(guestCtx, request("guest-channel", playlistLimit))
+	if err != nil {
+		t.Fatalf("GetReader guest failed: %v", err)
+	}
+	defer func() { _ = guest.Close() }()
+
+	otherCtx := ctxutil.WithClient(ctx, testClient{name: "other", priority: 10})
+	other, err := d.GetReader(otherCtx, request("other-channel", accountLimit))
+	if err != nil {
+		t.Fatalf("GetReader other failed: %v", err)
+	}
+	defer func() { _ = other.Close() }()
+
+	livingRoomCtx := ctxutil.WithClient(ctx, testClient{name: "living-room", priority: 10})
+	if _, err := d.GetReader(livingRoomCtx, request("living-room-channel", playlistLimit, accountLimit)); err != ErrSubscriptionSemaphore {
+		t.Fatalf("expected request to be rejected, got %v", err)
+	}
+
+	if d.pool.get("guest-channel") == nil {
+		t.Error("expected lower priority segmenter to keep running")
+	}
+	if usage := playlistLimit.Usage(); usage != 1 {
+		t.Errorf("expected guest to keep its playlist slot, got usage %v", usage)
+	}
+	if usage := accountLimit.Usage(); usage != 1 {
+		t.Errorf("expected other to keep its account slot, got usage %v", usage)
+	}
+}
+
+func TestOpenHLS_MirrorUsedWhenPrimaryAccountFull(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	primary := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "account", "first", "account_limit")
+	second := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "account", "second", "account_limit")
+
+	mirrors := func(req Request) []Request {
+		mirror := req
+		mirror.Account = "second"
+		mirror.StreamURL = "mirror-" + req.StreamURL
+		mirror.Limits = []*utils.Limit{second}
+		req.Account = "first"
+		return []Request{req, mirror}
+	}
+
+	req := Request{
+		StreamKey: "mirrored-1",
+		StreamURL: testStreamURL,
+		Limits:    []*utils.Limit{primary},
+		Segmenter: testShellSegmenterCfg,
+		Mirrors:   mirrors,
+	}
+	if err := d.OpenHLS(ctx, req, "hls-alice", func() {}); err != nil {
+		t.Fatalf("OpenHLS 1 failed: %v", err)
+	}
+
+	other := req
+	other.StreamKey = "mirrored-2"
+	if err := d.OpenHLS(ctx, other, "hls-bob", func() {}); err != nil {
+		t.Fatalf("OpenHLS 2 failed: %v", err)
+	}
+
+	seg := d.pool.get("mirrored-2")
+	if seg == nil || seg.sources[0] != "mirror-"+testStreamURL {
+		t.Fatal("expected second segmenter to use the mirror url")
+	}
+	if second.Semaphore().TryAcquire() {
+		t.Fatal("expected mirror account slot to be held")
+	}
+
+	third := req
+	third.StreamKey = "mirrored-3"
+	if err := d.OpenHLS(ctx, third, "hls-carol", func() {}); err != ErrSubscriptionSemaphore {
+		t.Fatalf("expected ErrSubscriptionSemaphore, got %v", err)
+	}
+}
+
+func TestOpenHLS_MirrorFailsOverToMirrorFallback(t *testing.T) {
+	d := New()
+	defer d.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	runsLog := filepath.Join(t.TempDir(), "runs")
+
+	cfg := testShellSegmenterCfg
+	cfg.Command = common.StringOrArr{
+		"sh", "-c",
+		`touch "$(printf "$0" $$)"; echo "$1" >> "$2"; [ "$1" = mirror-primary ] && sleep 0.3 && exit 1; exec sleep 60`,
+		"{{ .segment_path }}", "{{ .url }}", runsLog,
+	}
+
+	primary := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "account", "first", "account_limit")
+	second := utils.NewLimit(utils.NewSemaphore(1), "upstream_concurrency", "account", "second", "account_limit")
+	if !primary.Semaphore().TryAcquire() {
+		t.Fatal("failed to fill primary account")
+	}
+	defer primary.Semaphore().Release()
+
+	req := Request{
+		StreamKey: "mirrored-failover",
+		StreamURL: "primary",
+		Fallbacks: []string{"backup"},
+		Limits:    []*utils.Limit{primary},
+		Segmenter: cfg,
+		Mirrors: func(req Request) []Request {
+			mirror := req
+			mirror.Account = "second"
+			mirror.StreamURL = "mirror-" + req.StreamURL
+			mirror.Fallbacks = []string{"mirror-" + req.Fallbacks[0]}
+			mirror.Limits = []*utils.Limit{second}
+			return []Request{req, mirror}
+		},
+	}
+	if err := d.OpenHLS(ctx, req, "hls-alice", func() {}); err != nil {
+		t.Fatalf("OpenHLS failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(runsLog)
+		if string(data) == "mirror-primary\nmirror-backup\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected failover to mirror backup, got runs %q", data)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,17 +28,42 @@ const (
 	ProviderTypeEPG
 )
 
+const (
+	hlsExtension    = ".m3u8"
+	tokenSeparator  = "."
+	clientIDByteLen = 6
+)
+
 var (
 	ErrExpiredStreamURL = errors.New("expired stream URL")
 	ErrExpiredFileURL   = errors.New("expired file URL")
 	ErrInvalidToken     = errors.New("invalid token")
+	ErrRetiredKey       = errors.New("retired key")
+	ErrRevokedToken     = errors.New("revoked token")
 )
 
+type Key struct {
+	ID      string
+	Secret  string
+	Retired bool
+}
+
 type Generator struct {
-	PublicURL string
-	aead      cipher.AEAD
-	streamTTL time.Duration
-	fileTTL   time.Duration
+	PublicURL    string
+	clientID     string
+	activeKey    *key
+	keys         map[string]*key
+	streamTTL    time.Duration
+	fileTTL      time.Duration
+	stableWindow time.Duration
+
+	revokedBefore atomic.Int64
+}
+
+type key struct {
+	id      string
+	aead    cipher.AEAD
+	retired bool
 }
 
 type Data struct {
@@ -61,6 +87,7 @@ type Stream struct {
 	ProviderInfo ProviderInfo `json:"pi"`
 	URL          string       `json:"u"`
 	Hidden       bool         `json:"h,omitempty"`
+	Catchup      string       `json:"c,omitempty"`
 }
 
 type FileData struct {
@@ -68,9 +95,78 @@ type FileData struct {
 	URL          string       `json:"u"`
 }
 
-func NewGenerator(publicURL, secret string, streamTTL, fileTTL time.Duration) (*Generator, error) {
-	key := sha256.Sum256([]byte(secret))
-	block, err := aes.NewCipher(key[:])
+func NewGenerator(publicURL, secret string, streamTTL, fileTTL, stableWindow time.Duration) (*Generator, error) {
+	return NewRotatingGenerator(publicURL, "", []Key{{Secret: secret}}, "", streamTTL, fileTTL, stableWindow)
+}
+
+func NewRotatingGenerator(
+	publicURL, clientID string, keys []Key, activeKeyID string,
+	streamTTL, fileTTL, stableWindow time.Duration) (*Generator, error) {
+	if strings.Contains(clientID, tokenSeparator) {
+		return nil, fmt.Errorf("client id %q cannot contain %q", clientID, tokenSeparator)
+	}
+
+	g := &Generator{
+		PublicURL:    publicURL,
+		clientID:     clientID,
+		keys:         make(map[string]*key, len(keys)),
+		streamTTL:    streamTTL,
+		fileTTL:      fileTTL,
+		stableWindow: stableWindow,
+	}
+
+	for _, k := range keys {
+		if strings.Contains(k.ID, tokenSeparator) {
+			return nil, fmt.Errorf("key id %q cannot contain %q", k.ID, tokenSeparator)
+		}
+		if _, exists := g.keys[k.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+
+		aead, err := newAEAD(k.Secret)
+		if err != nil {
+			return nil, err
+		}
+		g.keys[k.ID] = &key{id: k.ID, aead: aead, retired: k.Retired}
+	}
+
+	active, ok := g.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not defined", activeKeyID)
+	}
+	if active.retired {
+		return nil, fmt.Errorf("active key %q is retired", activeKeyID)
+	}
+	g.activeKey = active
+
+	return g, nil
+}
+
+func NewClientID(clientName string) string {
+	sum := sha256.Sum256([]byte(clientName))
+	return base64.RawURLEncoding.EncodeToString(sum[:clientIDByteLen])
+}
+
+func TokenClientID(token string) (string, bool) {
+	clientID, _, _ := parseToken(token)
+	return clientID, clientID != ""
+}
+
+func parseToken(token string) (clientID, keyID, body string) {
+	parts := strings.Split(token, tokenSeparator)
+	switch len(parts) {
+	case 3:
+		return parts[0], parts[1], parts[2]
+	case 2:
+		return "", parts[0], parts[1]
+	default:
+		return "", "", token
+	}
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -78,17 +174,25 @@ func NewGenerator(publicURL, secret string, streamTTL, fileTTL time.Duration) (*
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
-	return &Generator{PublicURL: publicURL, aead: aead, streamTTL: streamTTL, fileTTL: fileTTL}, nil
+	return aead, nil
 }
 
 func (g *Generator) CreateStreamURL(channelName string, streams []Stream) (*url.URL, error) {
 	return g.CreateURL(Data{
 		RequestType: RequestTypeStream,
 		StreamData:  StreamData{ChannelName: channelName, Streams: streams},
-		CreatedAt:   time.Now().Unix(),
+		CreatedAt:   g.createdAt(),
 	})
 }
 
+func (g *Generator) CreateHLSStreamURL(channelName string, streams []Stream) (*url.URL, error) {
+	return g.createURL(Data{
+		RequestType: RequestTypeStream,
+		StreamData:  StreamData{ChannelName: channelName, Streams: streams},
+		CreatedAt:   g.createdAt(),
+	}, hlsExtension)
+}
+
 func (g *Generator) CreateFileURL(providerInfo ProviderInfo, fileURL string) (*url.URL, error) {
 	return g.CreateURL(Data{
 		RequestType: RequestTypeFile,
@@ -96,22 +200,49 @@ func (g *Generator) CreateFileURL(providerInfo ProviderInfo, fileURL string) (*u
 			ProviderInfo: providerInfo,
 			URL:          fileURL,
 		},
-		CreatedAt: time.Now().Unix(),
+		CreatedAt: g.createdAt(),
 	})
 }
 
+func (g *Generator) createdAt() int64 {
+	now := time.Now()
+	if g.stableWindow > 0 {
+		now = now.Truncate(g.stableWindow)
+	}
+	return max(now.Unix(), g.revokedBefore.Load())
+}
+
+func (g *Generator) SetRevokedBefore(t time.Time) {
+	if t.IsZero() {
+		g.revokedBefore.Store(0)
+		return
+	}
+	g.revokedBefore.Store(t.Unix())
+}
+
+func (g *Generator) RevokedBefore() time.Time {
+	if v := g.revokedBefore.Load(); v > 0 {
+		return time.Unix(v, 0)
+	}
+	return time.Time{}
+}
+
 func (g *Generator) CreateURL(d Data) (*url.URL, error) {
+	return g.createURL(d, determineExtension(d))
+}
+
+func (g *Generator) createURL(d Data, ext string) (*url.URL, error) {
 	jsonData, err := json.Marshal(d)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
+	aead := g.activeKey.aead
 	hash := sha256.Sum256(jsonData)
-	nonce := hash[:g.aead.NonceSize()]
+	nonce := hash[:aead.NonceSize()]
 
-	ct := g.aead.Seal(nonce, nonce, jsonData, nil)
-	token := base64.RawURLEncoding.EncodeToString(ct)
-	ext := determineExtension(d)
+	ct := aead.Seal(nonce, nonce, jsonData, nil)
+	token := g.encodeToken(ct)
 
 	u, err := url.Parse(fmt.Sprintf("%s/%s/f%s", g.PublicURL, token, ext))
 	if err != nil {
@@ -120,18 +251,40 @@ func (g *Generator) CreateURL(d Data) (*url.URL, error) {
 	return u, nil
 }
 
+func (g *Generator) encodeToken(ct []byte) string {
+	token := base64.RawURLEncoding.EncodeToString(ct)
+	switch {
+	case g.clientID != "":
+		return g.clientID + tokenSeparator + g.activeKey.id + tokenSeparator + token
+	case g.activeKey.id != "":
+		return g.activeKey.id + tokenSeparator + token
+	default:
+		return token
+	}
+}
+
 func (g *Generator) Decrypt(token string) (*Data, error) {
-	ct, err := base64.RawURLEncoding.DecodeString(token)
+	clientID, keyID, body := parseToken(token)
+	if clientID != "" && clientID != g.clientID {
+		return nil, ErrInvalidToken
+	}
+
+	k, ok := g.keys[keyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	ct, err := base64.RawURLEncoding.DecodeString(body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode token: %w", err)
 	}
 
-	nonceSize := g.aead.NonceSize()
+	nonceSize := k.aead.NonceSize()
 	if len(ct) < nonceSize {
 		return nil, ErrInvalidToken
 	}
 
-	plain, err := g.aead.Open(nil, ct[:nonceSize], ct[nonceSize:], nil)
+	plain, err := k.aead.Open(nil, ct[:nonceSize], ct[nonceSize:], nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
@@ -141,6 +294,14 @@ func (g *Generator) Decrypt(token string) (*Data, error) {
 		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
 
+	if k.retired {
+		return &data, fmt.Errorf("%w: %w", ErrRetiredKey, expiredError(data.RequestType))
+	}
+
+	if data.CreatedAt < g.revokedBefore.Load() {
+		return &data, fmt.Errorf("%w: %w", ErrRevokedToken, expiredError(data.RequestType))
+	}
+
 	if data.CreatedAt > 0 {
 		createdTime := time.Unix(data.CreatedAt, 0)
 		var ttl time.Duration
@@ -150,17 +311,21 @@ func (g *Generator) Decrypt(token string) (*Data, error) {
 			ttl = g.fileTTL
 		}
 
-		if ttl > 0 && createdTime.Add(ttl).Before(time.Now()) {
-			if data.RequestType == RequestTypeStream {
-				return nil, ErrExpiredStreamURL
-			}
-			return nil, ErrExpiredFileURL
+		if ttl > 0 && createdTime.Add(ttl+g.stableWindow).Before(time.Now()) {
+			return &data, expiredError(data.RequestType)
 		}
 	}
 
 	return &data, nil
 }
 
+func expiredError(requestType RequestType) error {
+	if requestType == RequestTypeStream {
+		return ErrExpiredStreamURL
+	}
+	return ErrExpiredFileURL
+}
+
 func determineExtension(d Data) string {
 	if d.RequestType == RequestTypeStream {
 		return ".ts"
@@ -2,13 +2,14 @@ package urlgen
 
 import (
 	"errors"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 )
 
 func TestGenerator_CreateURL(t *testing.T) {
-	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour)
+	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 0)
 	if err != nil {
 		t.Fatalf("Failed to create generator: %v", err)
 	}
@@ -129,7 +130,7 @@ func TestGenerator_CreateURL(t *testing.T) {
 }
 
 func TestGenerator_CreateStreamURL(t *testing.T) {
-	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour)
+	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 0)
 	if err != nil {
 		t.Fatalf("Failed to create generator: %v", err)
 	}
@@ -187,8 +188,39 @@ func TestGenerator_CreateStreamURL(t *testing.T) {
 	}
 }
 
+func TestGenerator_CreateHLSStreamURL(t *testing.T) {
+	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	streams := []Stream{{
+		ProviderInfo: ProviderInfo{ProviderType: ProviderTypePlaylist, ProviderName: "test1"},
+		URL:          "https://stream1.example.com/video",
+	}}
+
+	u, err := g.CreateHLSStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("CreateHLSStreamURL() failed: %v", err)
+	}
+
+	if !strings.HasSuffix(u.Path, "/f.m3u8") {
+		t.Errorf("CreateHLSStreamURL() URL doesn't end with .m3u8 extension")
+	}
+
+	parts := strings.Split(u.Path, "/")
+	decrypted, err := g.Decrypt(parts[len(parts)-2])
+	if err != nil {
+		t.Fatalf("Failed to decrypt token: %v", err)
+	}
+
+	if decrypted.RequestType != RequestTypeStream {
+		t.Errorf("Expected RequestTypeStream, got %v", decrypted.RequestType)
+	}
+}
+
 func TestGenerator_CreateFileURL(t *testing.T) {
-	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour)
+	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 0)
 	if err != nil {
 		t.Fatalf("Failed to create generator: %v", err)
 	}
@@ -227,7 +259,7 @@ func TestGenerator_CreateFileURL(t *testing.T) {
 }
 
 func TestGenerator_Decrypt(t *testing.T) {
-	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour)
+	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 0)
 	if err != nil {
 		t.Fatalf("Failed to create generator: %v", err)
 	}
@@ -304,7 +336,7 @@ func TestGenerator_Decrypt(t *testing.T) {
 
 func TestGenerator_ExpiredLinkDecryption(t *testing.T) {
 	t.Run("expired token with TTL", func(t *testing.T) {
-		g, err := NewGenerator("https://example.com", "test-secret", time.Millisecond, time.Millisecond)
+		g, err := NewGenerator("https://example.com", "test-secret", time.Millisecond, time.Millisecond, 0)
 		if err != nil {
 			t.Fatalf("Failed to create generator: %v", err)
 		}
@@ -340,7 +372,7 @@ func TestGenerator_ExpiredLinkDecryption(t *testing.T) {
 	})
 
 	t.Run("valid token with zero TTL", func(t *testing.T) {
-		g, err := NewGenerator("https://example.com", "test-secret", 0, 0)
+		g, err := NewGenerator("https://example.com", "test-secret", 0, 0, 0)
 		if err != nil {
 			t.Fatalf("Failed to create generator: %v", err)
 		}
@@ -372,6 +404,63 @@ func TestGenerator_ExpiredLinkDecryption(t *testing.T) {
 	})
 }
 
+func TestGenerator_StableWindow(t *testing.T) {
+	t.Run("same data within window yields same URL", func(t *testing.T) {
+		g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 1000*time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create generator: %v", err)
+		}
+
+		streams := []Stream{{URL: "https://example.com/video"}}
+
+		first, err := g.CreateStreamURL("1", streams)
+		if err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+		second, err := g.CreateStreamURL("1", streams)
+		if err != nil {
+			t.Fatalf("Failed to create URL: %v", err)
+		}
+
+		if first.String() != second.String() {
+			t.Errorf("expected stable URL, got %s and %s", first, second)
+		}
+	})
+
+	t.Run("ttl starts at the end of the window", func(t *testing.T) {
+		g, err := NewGenerator("https://example.com", "test-secret", time.Minute, time.Minute, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create generator: %v", err)
+		}
+
+		tests := []struct {
+			name      string
+			createdAt time.Time
+			expired   bool
+		}{
+			{"inside window", time.Now().Add(-30 * time.Minute), false},
+			{"after window and ttl", time.Now().Add(-2 * time.Hour), true},
+		}
+
+		for _, tt := range tests {
+			u, err := g.CreateURL(Data{
+				RequestType: RequestTypeStream,
+				StreamData:  StreamData{ChannelName: "1", Streams: []Stream{{URL: "https://example.com/video"}}},
+				CreatedAt:   tt.createdAt.Unix(),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create URL: %v", err)
+			}
+
+			parts := strings.Split(u.Path, "/")
+			_, err = g.Decrypt(parts[len(parts)-2])
+			if got := errors.Is(err, ErrExpiredStreamURL); got != tt.expired {
+				t.Errorf("%s: expected expired=%v, got error %v", tt.name, tt.expired, err)
+			}
+		}
+	})
+}
+
 func TestDetermineExtension(t *testing.T) {
 	tests := []struct {
 		name string
@@ -418,3 +507,171 @@ func TestDetermineExtension(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerator_KeyRotation(t *testing.T) {
+	streams := []Stream{{URL: "https://example.com/video"}}
+
+	tokenOf := func(u *url.URL) string {
+		parts := strings.Split(u.Path, "/")
+		return parts[len(parts)-2]
+	}
+
+	legacy, err := NewGenerator("https://example.com", "old-secret", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	legacyURL, err := legacy.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	first, err := NewRotatingGenerator("https://example.com", "",
+		[]Key{{Secret: "old-secret"}, {ID: "k1", Secret: "secret-1"}}, "k1", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	firstURL, err := first.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if !strings.HasPrefix(tokenOf(firstURL), "k1.") {
+		t.Errorf("expected token to carry key id, got %s", tokenOf(firstURL))
+	}
+
+	rotated, err := NewRotatingGenerator("https://example.com", "",
+		[]Key{{Secret: "old-secret", Retired: true}, {ID: "k1", Secret: "secret-1"}, {ID: "k2", Secret: "secret-2"}},
+		"k2", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	if _, err := rotated.Decrypt(tokenOf(firstURL)); err != nil {
+		t.Errorf("expected accepted key to decrypt, got %v", err)
+	}
+
+	data, err := rotated.Decrypt(tokenOf(legacyURL))
+	if !errors.Is(err, ErrRetiredKey) || !errors.Is(err, ErrExpiredStreamURL) {
+		t.Errorf("expected retired key error, got %v", err)
+	}
+	if data == nil || data.StreamData.ChannelName != "1" {
+		t.Errorf("expected data for retired key, got %v", data)
+	}
+
+	if _, err := rotated.Decrypt("k9." + strings.TrimPrefix(tokenOf(firstURL), "k1.")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for unknown key, got %v", err)
+	}
+}
+
+func TestNewRotatingGenerator_InvalidKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []Key
+		active string
+	}{
+		{"unknown active key", []Key{{ID: "k1", Secret: "s"}}, "k2"},
+		{"retired active key", []Key{{ID: "k1", Secret: "s", Retired: true}}, "k1"},
+		{"duplicate id", []Key{{ID: "k1", Secret: "a"}, {ID: "k1", Secret: "b"}}, "k1"},
+		{"separator in id", []Key{{ID: "k.1", Secret: "s"}}, "k.1"},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewRotatingGenerator("https://example.com", "", tt.keys, tt.active, 0, 0, 0); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestGenerator_ClientID(t *testing.T) {
+	streams := []Stream{{URL: "https://example.com/video"}}
+	keys := []Key{{Secret: "secret"}, {ID: "k1", Secret: "secret-1"}}
+
+	g, err := NewRotatingGenerator("https://example.com", NewClientID("alice"), keys, "k1", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	u, err := g.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	parts := strings.Split(u.Path, "/")
+	token := parts[len(parts)-2]
+
+	clientID, ok := TokenClientID(token)
+	if !ok || clientID != NewClientID("alice") {
+		t.Errorf("expected client id %s, got %s", NewClientID("alice"), clientID)
+	}
+	if _, err := g.Decrypt(token); err != nil {
+		t.Errorf("expected token to decrypt, got %v", err)
+	}
+
+	other, err := NewRotatingGenerator("https://example.com", NewClientID("bob"), keys, "k1", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if _, err := other.Decrypt(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for other client, got %v", err)
+	}
+
+	legacy, err := NewGenerator("https://example.com", "secret", time.Hour, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	legacyURL, err := legacy.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	parts = strings.Split(legacyURL.Path, "/")
+	legacyToken := parts[len(parts)-2]
+
+	if _, ok := TokenClientID(legacyToken); ok {
+		t.Error("expected no client id in legacy token")
+	}
+	if _, err := g.Decrypt(legacyToken); err != nil {
+		t.Errorf("expected legacy token to decrypt, got %v", err)
+	}
+}
+
+func TestGenerator_RevokedBefore(t *testing.T) {
+	g, err := NewGenerator("https://example.com", "test-secret", time.Hour, time.Hour, 1000*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	streams := []Stream{{URL: "https://example.com/video"}}
+	tokenOf := func(u *url.URL) string {
+		parts := strings.Split(u.Path, "/")
+		return parts[len(parts)-2]
+	}
+
+	before, err := g.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+
+	g.SetRevokedBefore(time.Now())
+
+	data, err := g.Decrypt(tokenOf(before))
+	if !errors.Is(err, ErrRevokedToken) || !errors.Is(err, ErrExpiredStreamURL) {
+		t.Errorf("expected revoked token error, got %v", err)
+	}
+	if data == nil {
+		t.Error("expected data for revoked token")
+	}
+
+	after, err := g.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if _, err := g.Decrypt(tokenOf(after)); err != nil {
+		t.Errorf("expected token issued after revocation to decrypt, got %v", err)
+	}
+
+	again, err := g.CreateStreamURL("1", streams)
+	if err != nil {
+		t.Fatalf("Failed to create URL: %v", err)
+	}
+	if after.String() != again.String() {
+		t.Errorf("expected stable URL after revocation, got %s and %s", after, again)
+	}
+}
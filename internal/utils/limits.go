@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"majmun/internal/metrics"
+	"time"
+)
+
+type Limit struct {
+	Name   string
+	Scope  string
+	Target string
+	Reason string
+	sem    *Semaphore
+}
+
+func NewLimit(sem *Semaphore, name, scope, target, reason string) *Limit {
+	if sem == nil {
+		return nil
+	}
+	return &Limit{
+		Name:   name,
+		Scope:  scope,
+		Target: target,
+		Reason: reason,
+		sem:    sem,
+	}
+}
+
+func (l *Limit) Acquire(ctx context.Context, timeout time.Duration) bool {
+	if l == nil {
+		return true
+	}
+	if !AcquireSemaphore(ctx, l.sem, timeout, l.Reason) {
+		return false
+	}
+	metrics.IncConcurrencyUsed(l.Name, l.Scope, l.Target)
+	return true
+}
+
+func (l *Limit) Release() {
+	if l == nil {
+		return
+	}
+	l.sem.Release()
+	metrics.DecConcurrencyUsed(l.Name, l.Scope, l.Target)
+}
+
+func (l *Limit) Semaphore() *Semaphore {
+	if l == nil {
+		return nil
+	}
+	return l.sem
+}
+
+func (l *Limit) Usage() float64 {
+	if l == nil {
+		return 0
+	}
+	return l.sem.Usage()
+}
@@ -5,29 +5,162 @@ import (
 	"errors"
 	"majmun/internal/ctxutil"
 	"majmun/internal/logging"
+	"majmun/internal/metrics"
+	"sort"
+	"sync"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
-func AcquireSemaphore(ctx context.Context, sem *semaphore.Weighted, timeout time.Duration, name string) bool {
+type Semaphore struct {
+	size    int64
+	cur     int64
+	waiters []*semaphoreWaiter
+	mu      sync.Mutex
+}
+
+type semaphoreWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+func (s *Semaphore) TryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.waiters) > 0 || s.cur >= s.size {
+		return false
+	}
+	s.cur++
+	return true
+}
+
+func (s *Semaphore) Acquire(ctx context.Context, priority int) error {
+	s.mu.Lock()
+	if len(s.waiters) == 0 && s.cur < s.size {
+		s.cur++
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &semaphoreWaiter{priority: priority, ready: make(chan struct{})}
+	i := sort.Search(len(s.waiters), func(i int) bool { return s.waiters[i].priority < priority })
+	s.waiters = append(s.waiters, nil)
+	copy(s.waiters[i+1:], s.waiters[i:])
+	s.waiters[i] = w
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		s.cur--
+	default:
+		for i, waiter := range s.waiters {
+			if waiter == w {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	s.notifyWaiters()
+
+	return ctx.Err()
+}
+
+func (s *Semaphore) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cur <= 0 {
+		logging.Error(context.Background(), errors.New("semaphore released more than held"), "ignoring semaphore release")
+		return
+	}
+	s.cur--
+	s.notifyWaiters()
+}
+
+// Resize changes the number of slots. Held slots stay held, so after shrinking
+// new acquisitions wait until usage drops below the new size.
+func (s *Semaphore) Resize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = size
+	s.notifyWaiters()
+}
+
+func (s *Semaphore) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiters)
+}
+
+func (s *Semaphore) Usage() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return float64(s.cur) / float64(s.size)
+}
+
+func (s *Semaphore) notifyWaiters() {
+	for len(s.waiters) > 0 && s.cur < s.size {
+		w := s.waiters[0]
+		s.waiters = s.waiters[1:]
+		s.cur++
+		close(w.ready)
+	}
+}
+
+func AcquireSemaphore(ctx context.Context, sem *Semaphore, timeout time.Duration, name string) bool {
 	if sem == nil {
 		return true
 	}
 
-	semCtx, cancel := context.WithTimeout(ctx, timeout)
-	semCtx = ctxutil.WithSemaphoreName(semCtx, name)
+	ctx = ctxutil.WithSemaphoreName(ctx, name)
+
+	if sem.TryAcquire() {
+		logging.Debug(ctx, "semaphore acquired")
+		return true
+	}
+	if timeout <= 0 {
+		logging.Debug(ctx, "semaphore not available")
+		return false
+	}
 
+	if onQueued := ctxutil.OnQueued(ctx); onQueued != nil {
+		onQueued()
+	}
+
+	semCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	logging.Debug(ctx, "acquiring semaphore")
+	logging.Debug(ctx, "waiting for semaphore", "priority", ctxutil.Priority(ctx))
 
-	if err := sem.Acquire(semCtx, 1); err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			logging.Info(ctx, "semaphore acquisition timeout")
-		} else {
-			logging.Error(ctx, err, "semaphore acquisition failed")
-		}
+	metrics.IncQueueDepth(ctx, name)
+	start := time.Now()
+	err := sem.Acquire(semCtx, ctxutil.Priority(ctx))
+	metrics.DecQueueDepth(ctx, name)
+
+	switch {
+	case err == nil:
+		metrics.ObserveQueueWait(ctx, name, metrics.QueueOutcomeAcquired, time.Since(start))
+	case errors.Is(err, context.DeadlineExceeded):
+		metrics.ObserveQueueWait(ctx, name, metrics.QueueOutcomeTimeout, time.Since(start))
+		logging.Info(ctx, "semaphore acquisition timeout")
+		return false
+	default:
+		metrics.ObserveQueueWait(ctx, name, metrics.QueueOutcomeCanceled, time.Since(start))
+		logging.Error(ctx, err, "semaphore acquisition failed")
 		return false
 	}
 
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"majmun/internal/ctxutil"
+	"testing"
+	"time"
+)
+
+func TestSemaphore_WaitersOrderedByPriority(t *testing.T) {
+	sem := NewSemaphore(1)
+	if !sem.TryAcquire() {
+		t.Fatal("expected free semaphore to be acquired")
+	}
+
+	order := make(chan int, 3)
+	for i, priority := range []int{0, 10, 0} {
+		go func() {
+			if err := sem.Acquire(context.Background(), priority); err != nil {
+				t.Errorf("Acquire failed: %v", err)
+				return
+			}
+			order <- i
+			sem.Release()
+		}()
+		for sem.Waiting() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if sem.TryAcquire() {
+		t.Fatal("expected TryAcquire to fail while requests are waiting")
+	}
+
+	sem.Release()
+
+	for _, want := range []int{1, 0, 2} {
+		if got := <-order; got != want {
+			t.Errorf("expected waiter %d, got %d", want, got)
+		}
+	}
+}
+
+func TestSemaphore_CanceledWaiterLeavesQueue(t *testing.T) {
+	sem := NewSemaphore(1)
+	if !sem.TryAcquire() {
+		t.Fatal("expected free semaphore to be acquired")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := sem.Acquire(ctx, 0); err == nil {
+		t.Fatal("expected Acquire to time out")
+	}
+	if n := sem.Waiting(); n != 0 {
+		t.Errorf("expected empty queue, got %d waiters", n)
+	}
+
+	sem.Release()
+	if !sem.TryAcquire() {
+		t.Error("expected released slot to be available")
+	}
+}
+
+func TestSemaphore_ResizeKeepsHeldSlots(t *testing.T) {
+	sem := NewSemaphore(2)
+	if !sem.TryAcquire() || !sem.TryAcquire() {
+		t.Fatal("expected two free slots")
+	}
+
+	sem.Resize(1)
+	sem.Release()
+	if sem.TryAcquire() {
+		t.Fatal("expected shrunk semaphore to stay full while a held slot drains")
+	}
+
+	sem.Release()
+	if !sem.TryAcquire() {
+		t.Fatal("expected slot once usage dropped below the new size")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if err := sem.Acquire(context.Background(), 0); err != nil {
+			t.Errorf("Acquire failed: %v", err)
+		}
+		close(done)
+	}()
+	for sem.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	sem.Resize(2)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected grown semaphore to admit the waiter")
+	}
+}
+
+func TestSemaphore_OverReleaseIsIgnored(t *testing.T) {
+	sem := NewSemaphore(1)
+	sem.Release()
+
+	if !sem.TryAcquire() {
+		t.Fatal("expected free slot after ignored release")
+	}
+	if sem.TryAcquire() {
+		t.Error("expected ignored release not to add a slot")
+	}
+}
+
+func TestAcquireSemaphore_NotifiesWhenQueued(t *testing.T) {
+	sem := NewSemaphore(1)
+
+	var queued int
+	ctx := ctxutil.WithOnQueued(context.Background(), func() { queued++ })
+
+	if !AcquireSemaphore(ctx, sem, time.Second, "test") {
+		t.Fatal("expected free semaphore to be acquired")
+	}
+	if queued != 0 {
+		t.Error("expected no notification when a slot is free")
+	}
+
+	if AcquireSemaphore(ctx, sem, 20*time.Millisecond, "test") {
+		t.Fatal("expected acquisition to time out")
+	}
+	if queued != 1 {
+		t.Errorf("expected one notification, got %d", queued)
+	}
+}
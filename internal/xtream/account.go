@@ -0,0 +1,50 @@
+package xtream
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	statusActive = "Active"
+	timeLayout   = "2006-01-02 15:04:05"
+)
+
+func NewAccountInfo(username, password string, maxConnections int64, publicURL *url.URL, now time.Time) AccountInfo {
+	protocol := publicURL.Scheme
+	port := publicURL.Port()
+	if port == "" {
+		port = "80"
+		if protocol == "https" {
+			port = "443"
+		}
+	}
+
+	serverInfo := ServerInfo{
+		URL:            publicURL.Hostname(),
+		Port:           port,
+		ServerProtocol: protocol,
+		Timezone:       "UTC",
+		TimestampNow:   now.Unix(),
+		TimeNow:        now.UTC().Format(timeLayout),
+	}
+	if protocol == "https" {
+		serverInfo.HTTPSPort = port
+	}
+
+	return AccountInfo{
+		UserInfo: UserInfo{
+			Username:             username,
+			Password:             password,
+			Auth:                 1,
+			Status:               statusActive,
+			IsTrial:              "0",
+			ActiveCons:           "0",
+			CreatedAt:            strconv.FormatInt(now.Unix(), 10),
+			MaxConnections:       strconv.FormatInt(maxConnections, 10),
+			AllowedOutputFormats: []string{"ts"},
+		},
+		ServerInfo: serverInfo,
+	}
+}
@@ -0,0 +1,160 @@
+package xtream
+
+import (
+	"hash/fnv"
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	StreamTypeLive      = "live"
+	uncategorizedName   = "Uncategorized"
+	maxStreamID         = 1<<31 - 1
+	categoryIDHashRange = 1 << 20
+)
+
+// StreamIDs hands out stream IDs that stay with a channel across catalog
+// rebuilds. An ID starts from a hash of the channel name; a name whose ID is
+// already taken by another one gets the next free ID and keeps it.
+type StreamIDs struct {
+	ids   map[string]int
+	names map[int]string
+	mu    sync.Mutex
+}
+
+func NewStreamIDs() *StreamIDs {
+	return &StreamIDs{
+		ids:   make(map[string]int),
+		names: make(map[int]string),
+	}
+}
+
+func (r *StreamIDs) id(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.ids[key]; ok {
+		return id
+	}
+
+	id := int(hash32(key)%maxStreamID) + 1
+	for {
+		if _, taken := r.names[id]; !taken {
+			break
+		}
+		id = id%maxStreamID + 1
+	}
+
+	r.ids[key] = id
+	r.names[id] = key
+	return id
+}
+
+type Catalog struct {
+	categories []Category
+	streams    []LiveStream
+	urls       map[int]*url.URL
+}
+
+func NewCatalog(channels []*store.Channel, ids *StreamIDs) *Catalog {
+	c := &Catalog{
+		urls: make(map[int]*url.URL, len(channels)),
+	}
+
+	names := make(map[string]int)
+	categoryIDs := make(map[string]string)
+	usedCategoryIDs := make(map[string]bool)
+
+	for _, ch := range channels {
+		if ch.URI() == nil {
+			continue
+		}
+
+		group, _ := ch.GetAttr(m3u8.AttrGroupTitle)
+		if group == "" {
+			group = uncategorizedName
+		}
+
+		categoryID, ok := categoryIDs[group]
+		if !ok {
+			categoryID = uniqueCategoryID(group, usedCategoryIDs)
+			categoryIDs[group] = categoryID
+			usedCategoryIDs[categoryID] = true
+			c.categories = append(c.categories, Category{
+				CategoryID:   categoryID,
+				CategoryName: group,
+			})
+		}
+
+		streamID := ids.id(streamKey(ch.Name(), names))
+		c.urls[streamID] = ch.URI()
+
+		icon, _ := ch.GetAttr(m3u8.AttrTvgLogo)
+		epgID, _ := ch.GetAttr(m3u8.AttrTvgID)
+
+		c.streams = append(c.streams, LiveStream{
+			Num:          len(c.streams) + 1,
+			Name:         ch.Name(),
+			StreamType:   StreamTypeLive,
+			StreamID:     streamID,
+			StreamIcon:   icon,
+			EPGChannelID: epgID,
+			CategoryID:   categoryID,
+			DirectSource: ch.URI().String(),
+		})
+	}
+
+	return c
+}
+
+func (c *Catalog) Categories() []Category {
+	if c.categories == nil {
+		return []Category{}
+	}
+	return c.categories
+}
+
+func (c *Catalog) Streams(categoryID string) []LiveStream {
+	result := make([]LiveStream, 0, len(c.streams))
+	for _, s := range c.streams {
+		if categoryID == "" || s.CategoryID == categoryID {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (c *Catalog) StreamURL(streamID int) (*url.URL, bool) {
+	u, ok := c.urls[streamID]
+	return u, ok
+}
+
+// streamKey tells apart channels sharing a name by their position among them.
+func streamKey(name string, seen map[string]int) string {
+	n := seen[name]
+	seen[name]++
+	if n == 0 {
+		return name
+	}
+	return name + "\x00" + strconv.Itoa(n)
+}
+
+func uniqueCategoryID(name string, used map[string]bool) string {
+	id := int(hash32(name)%categoryIDHashRange) + 1
+	for {
+		s := strconv.Itoa(id)
+		if !used[s] {
+			return s
+		}
+		id = id%categoryIDHashRange + 1
+	}
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,109 @@
+package xtream
+
+import (
+	"majmun/internal/listing/m3u8/store"
+	"majmun/internal/parser/m3u8"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChannel(t *testing.T, name, rawURL string, attrs map[string]string) *store.Channel {
+	t.Helper()
+	track := &m3u8.Track{Name: name, Attrs: attrs}
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		track.URI = u
+	}
+	return store.NewChannel(track, nil)
+}
+
+func TestCatalog(t *testing.T) {
+	channels := []*store.Channel{
+		newChannel(t, "News", "http://proxy/token1/s.ts", map[string]string{
+			m3u8.AttrGroupTitle: "Info",
+			m3u8.AttrTvgID:      "news.tv",
+			m3u8.AttrTvgLogo:    "http://logo/news.png",
+		}),
+		newChannel(t, "Sports", "http://proxy/token2/s.ts", map[string]string{
+			m3u8.AttrGroupTitle: "Sport",
+		}),
+		newChannel(t, "Weather", "http://proxy/token3/s.ts", map[string]string{
+			m3u8.AttrGroupTitle: "Info",
+		}),
+		newChannel(t, "Other", "http://proxy/token4/s.ts", nil),
+		newChannel(t, "Broken", "", nil),
+	}
+
+	ids := NewStreamIDs()
+	catalog := NewCatalog(channels, ids)
+
+	categories := catalog.Categories()
+	require.Len(t, categories, 3)
+	assert.Equal(t, "Info", categories[0].CategoryName)
+	assert.Equal(t, "Sport", categories[1].CategoryName)
+	assert.Equal(t, uncategorizedName, categories[2].CategoryName)
+
+	streams := catalog.Streams("")
+	require.Len(t, streams, 4)
+	assert.Equal(t, 1, streams[0].Num)
+	assert.Equal(t, "news.tv", streams[0].EPGChannelID)
+	assert.Equal(t, "http://logo/news.png", streams[0].StreamIcon)
+	assert.Equal(t, StreamTypeLive, streams[0].StreamType)
+
+	info := catalog.Streams(categories[0].CategoryID)
+	require.Len(t, info, 2)
+	assert.Equal(t, "News", info[0].Name)
+	assert.Equal(t, "Weather", info[1].Name)
+
+	u, ok := catalog.StreamURL(streams[1].StreamID)
+	require.True(t, ok)
+	assert.Equal(t, "http://proxy/token2/s.ts", u.String())
+
+	again := NewCatalog(channels, ids)
+	assert.Equal(t, streams[1].StreamID, again.Streams("")[1].StreamID)
+}
+
+func TestCatalog_StreamIDsSurviveRefresh(t *testing.T) {
+	news := newChannel(t, "News", "http://proxy/token1/s.ts", nil)
+	sports := newChannel(t, "Sports", "http://proxy/token2/s.ts", nil)
+	newsCopy := newChannel(t, "News", "http://proxy/token3/s.ts", nil)
+
+	ids := NewStreamIDs()
+	// Take the hash slot of "Sports" so it collides.
+	ids.names[int(hash32("Sports")%maxStreamID)+1] = "other"
+
+	first := NewCatalog([]*store.Channel{news, sports, newsCopy}, ids).Streams("")
+	require.Len(t, first, 3)
+	assert.NotEqual(t, first[0].StreamID, first[2].StreamID, "expected channels sharing a name to get distinct ids")
+	assert.NotEqual(t, int(hash32("Sports")%maxStreamID)+1, first[1].StreamID)
+
+	second := NewCatalog([]*store.Channel{sports, news}, ids).Streams("")
+	require.Len(t, second, 2)
+	assert.Equal(t, first[1].StreamID, second[0].StreamID)
+	assert.Equal(t, first[0].StreamID, second[1].StreamID)
+}
+
+func TestCatalog_Empty(t *testing.T) {
+	catalog := NewCatalog(nil, NewStreamIDs())
+	assert.NotNil(t, catalog.Categories())
+	assert.Empty(t, catalog.Streams(""))
+}
+
+func TestNewAccountInfo(t *testing.T) {
+	u, err := url.Parse("https://iptv.example.com")
+	require.NoError(t, err)
+
+	info := NewAccountInfo("user", "pass", 2, u, time.Unix(1700000000, 0))
+
+	assert.Equal(t, 1, info.UserInfo.Auth)
+	assert.Equal(t, "2", info.UserInfo.MaxConnections)
+	assert.Equal(t, "iptv.example.com", info.ServerInfo.URL)
+	assert.Equal(t, "443", info.ServerInfo.Port)
+	assert.Equal(t, "443", info.ServerInfo.HTTPSPort)
+	assert.Equal(t, "https", info.ServerInfo.ServerProtocol)
+}
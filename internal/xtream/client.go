@@ -0,0 +1,218 @@
+package xtream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"majmun/internal/config/common"
+	"majmun/internal/logging"
+	"majmun/internal/parser/m3u8"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	liveStreamExtension = "ts"
+	trackLengthLive     = -1
+)
+
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient HTTPClient
+}
+
+type apiCategory struct {
+	CategoryID   flexString `json:"category_id"`
+	CategoryName string     `json:"category_name"`
+}
+
+type apiLiveStream struct {
+	Name         string     `json:"name"`
+	StreamID     flexString `json:"stream_id"`
+	StreamIcon   string     `json:"stream_icon"`
+	EPGChannelID string     `json:"epg_channel_id"`
+	CategoryID   flexString `json:"category_id"`
+}
+
+type flexString string
+
+func (f *flexString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*f = ""
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*f = flexString(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("expected string or number, got %s", data)
+	}
+	*f = flexString(n.String())
+	return nil
+}
+
+func NewClient(baseURL, username, password string, httpClient HTTPClient) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) XMLTVURL() string {
+	return c.baseURL + "/xmltv.php?" + c.credentials().Encode()
+}
+
+func (c *Client) StreamURL(streamID string) string {
+	return fmt.Sprintf("%s%s.%s", LiveURLPrefix(c.baseURL, c.username, c.password),
+		url.PathEscape(streamID), liveStreamExtension)
+}
+
+func LiveURLPrefix(baseURL, username, password string) string {
+	return fmt.Sprintf("%s/live/%s/%s/",
+		strings.TrimRight(baseURL, "/"),
+		url.PathEscape(username),
+		url.PathEscape(password),
+	)
+}
+
+// credentialPaths are the path forms that carry account credentials in
+// stream URLs. The empty one is the short /<username>/<password>/ form.
+var credentialPaths = []string{"live", "movie", "series", "timeshift", ""}
+
+// RewriteCredentials moves a stream URL of the from account to the to
+// account. Besides the credential path forms it handles the username and
+// password query parameters. It reports false when the URL carries no
+// credentials of the from account.
+func RewriteCredentials(rawURL string, from, to *common.Xtream) (string, bool) {
+	fromBase := strings.TrimRight(from.URL, "/") + "/"
+	toBase := strings.TrimRight(to.URL, "/") + "/"
+
+	rest, ok := strings.CutPrefix(rawURL, fromBase)
+	if !ok {
+		return rawURL, false
+	}
+
+	for _, path := range credentialPaths {
+		if tail, ok := strings.CutPrefix(rest, credentialPath(path, from.Username, from.Password)); ok {
+			return toBase + credentialPath(path, to.Username, to.Password) + tail, true
+		}
+	}
+
+	u, err := url.Parse(toBase + rest)
+	if err != nil {
+		return rawURL, false
+	}
+	query := u.Query()
+	if query.Get("username") != from.Username || query.Get("password") != from.Password {
+		return rawURL, false
+	}
+	query.Set("username", to.Username)
+	query.Set("password", to.Password)
+	u.RawQuery = query.Encode()
+	return u.String(), true
+}
+
+func credentialPath(path, username, password string) string {
+	credentials := url.PathEscape(username) + "/" + url.PathEscape(password) + "/"
+	if path == "" {
+		return credentials
+	}
+	return path + "/" + credentials
+}
+
+func (c *Client) LiveTracks(ctx context.Context) ([]*m3u8.Track, error) {
+	var categories []apiCategory
+	if err := c.call(ctx, ActionLiveCategories, &categories); err != nil {
+		return nil, err
+	}
+
+	var streams []apiLiveStream
+	if err := c.call(ctx, ActionLiveStreams, &streams); err != nil {
+		return nil, err
+	}
+
+	categoryNames := make(map[flexString]string, len(categories))
+	for _, cat := range categories {
+		categoryNames[cat.CategoryID] = cat.CategoryName
+	}
+
+	tracks := make([]*m3u8.Track, 0, len(streams))
+	for _, s := range streams {
+		if s.StreamID == "" {
+			continue
+		}
+
+		u, err := url.Parse(c.StreamURL(string(s.StreamID)))
+		if err != nil {
+			return nil, fmt.Errorf("build stream url: %w", err)
+		}
+
+		attrs := make(map[string]string)
+		if s.EPGChannelID != "" {
+			attrs[m3u8.AttrTvgID] = s.EPGChannelID
+		}
+		if s.StreamIcon != "" {
+			attrs[m3u8.AttrTvgLogo] = s.StreamIcon
+		}
+		if name, ok := categoryNames[s.CategoryID]; ok && name != "" {
+			attrs[m3u8.AttrGroupTitle] = name
+		}
+
+		tracks = append(tracks, &m3u8.Track{
+			Name:   s.Name,
+			Length: trackLengthLive,
+			URI:    u,
+			Attrs:  attrs,
+			Tags:   make(map[string]string),
+		})
+	}
+
+	return tracks, nil
+}
+
+func (c *Client) call(ctx context.Context, action string, v any) error {
+	query := c.credentials()
+	query.Set("action", action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/player_api.php?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("xtream %s: %w", action, logging.RedactURLError(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("xtream %s: status code: %d", action, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("xtream %s: decode response: %w", action, err)
+	}
+
+	return nil
+}
+
+func (c *Client) credentials() url.Values {
+	return url.Values{
+		"username": {c.username},
+		"password": {c.password},
+	}
+}
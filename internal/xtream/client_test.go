@@ -0,0 +1,108 @@
+package xtream
+
+import (
+	"context"
+	"majmun/internal/config/common"
+	"majmun/internal/parser/m3u8"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_LiveTracks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/player_api.php", r.URL.Path)
+		require.Equal(t, "user", r.URL.Query().Get("username"))
+		require.Equal(t, "pass", r.URL.Query().Get("password"))
+
+		switch r.URL.Query().Get("action") {
+		case ActionLiveCategories:
+			_, _ = w.Write([]byte(`[{"category_id":"1","category_name":"News"},{"category_id":2,"category_name":"Sport"}]`))
+		case ActionLiveStreams:
+			_, _ = w.Write([]byte(`[
+				{"name":"CNN","stream_id":101,"stream_icon":"http://logo/cnn.png","epg_channel_id":"cnn.us","category_id":"1"},
+				{"name":"ESPN","stream_id":"102","stream_icon":"","epg_channel_id":null,"category_id":2},
+				{"name":"Broken","stream_id":null}
+			]`))
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL+"/", "user", "pass", srv.Client())
+
+	tracks, err := client.LiveTracks(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tracks, 2)
+
+	assert.Equal(t, "CNN", tracks[0].Name)
+	assert.Equal(t, srv.URL+"/live/user/pass/101.ts", tracks[0].URI.String())
+	assert.Equal(t, "cnn.us", tracks[0].Attrs[m3u8.AttrTvgID])
+	assert.Equal(t, "http://logo/cnn.png", tracks[0].Attrs[m3u8.AttrTvgLogo])
+	assert.Equal(t, "News", tracks[0].Attrs[m3u8.AttrGroupTitle])
+
+	assert.Equal(t, "ESPN", tracks[1].Name)
+	assert.Equal(t, srv.URL+"/live/user/pass/102.ts", tracks[1].URI.String())
+	assert.Equal(t, "Sport", tracks[1].Attrs[m3u8.AttrGroupTitle])
+	assert.NotContains(t, tracks[1].Attrs, m3u8.AttrTvgID)
+}
+
+func TestClient_LiveTracksUpstreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "user", "pass", srv.Client()).LiveTracks(context.Background())
+	assert.Error(t, err)
+}
+
+func TestClient_ConnectionErrorRedactsCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := NewClient(srv.URL, "user", "secret-pass", srv.Client()).LiveTracks(context.Background())
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-pass")
+	assert.Contains(t, err.Error(), "/player_api.php")
+}
+
+func TestClient_XMLTVURL(t *testing.T) {
+	client := NewClient("http://provider.example.com", "user", "p&ss", nil)
+	assert.Equal(t, "http://provider.example.com/xmltv.php?password=p%26ss&username=user", client.XMLTVURL())
+}
+
+func TestRewriteCredentials(t *testing.T) {
+	from := &common.Xtream{URL: "http://provider.com/", Username: "alice", Password: "one"}
+	to := &common.Xtream{URL: "http://mirror.com", Username: "bob", Password: "two"}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+		ok   bool
+	}{
+		{"live", "http://provider.com/live/alice/one/42.ts", "http://mirror.com/live/bob/two/42.ts", true},
+		{"short", "http://provider.com/alice/one/42", "http://mirror.com/bob/two/42", true},
+		{"movie", "http://provider.com/movie/alice/one/7.mkv", "http://mirror.com/movie/bob/two/7.mkv", true},
+		{"series", "http://provider.com/series/alice/one/9.mp4", "http://mirror.com/series/bob/two/9.mp4", true},
+		{"timeshift", "http://provider.com/timeshift/alice/one/60/2024-01-01:10-00/42.ts",
+			"http://mirror.com/timeshift/bob/two/60/2024-01-01:10-00/42.ts", true},
+		{"query", "http://provider.com/streaming/timeshift.php?username=alice&password=one&stream=42",
+			"http://mirror.com/streaming/timeshift.php?password=two&stream=42&username=bob", true},
+		{"other account", "http://provider.com/live/carol/three/42.ts", "http://provider.com/live/carol/three/42.ts", false},
+		{"other host", "http://other.com/live/alice/one/42.ts", "http://other.com/live/alice/one/42.ts", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := RewriteCredentials(tt.url, from, to)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
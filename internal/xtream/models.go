@@ -0,0 +1,59 @@
+package xtream
+
+const (
+	ActionLiveCategories   = "get_live_categories"
+	ActionLiveStreams      = "get_live_streams"
+	ActionVODCategories    = "get_vod_categories"
+	ActionVODStreams       = "get_vod_streams"
+	ActionSeriesCategories = "get_series_categories"
+	ActionSeries           = "get_series"
+)
+
+type UserInfo struct {
+	Username             string   `json:"username"`
+	Password             string   `json:"password"`
+	Message              string   `json:"message"`
+	Auth                 int      `json:"auth"`
+	Status               string   `json:"status"`
+	ExpDate              *string  `json:"exp_date"`
+	IsTrial              string   `json:"is_trial"`
+	ActiveCons           string   `json:"active_cons"`
+	CreatedAt            string   `json:"created_at"`
+	MaxConnections       string   `json:"max_connections"`
+	AllowedOutputFormats []string `json:"allowed_output_formats"`
+}
+
+type ServerInfo struct {
+	URL            string `json:"url"`
+	Port           string `json:"port"`
+	HTTPSPort      string `json:"https_port"`
+	ServerProtocol string `json:"server_protocol"`
+	Timezone       string `json:"timezone"`
+	TimestampNow   int64  `json:"timestamp_now"`
+	TimeNow        string `json:"time_now"`
+}
+
+type AccountInfo struct {
+	UserInfo   UserInfo   `json:"user_info"`
+	ServerInfo ServerInfo `json:"server_info"`
+}
+
+type Category struct {
+	CategoryID   string `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	ParentID     int    `json:"parent_id"`
+}
+
+type LiveStream struct {
+	Num          int    `json:"num"`
+	Name         string `json:"name"`
+	StreamType   string `json:"stream_type"`
+	StreamID     int    `json:"stream_id"`
+	StreamIcon   string `json:"stream_icon"`
+	EPGChannelID string `json:"epg_channel_id"`
+	Added        string `json:"added"`
+	CategoryID   string `json:"category_id"`
+	CustomSID    string `json:"custom_sid"`
+	TVArchive    int    `json:"tv_archive"`
+	DirectSource string `json:"direct_source"`
+}